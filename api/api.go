@@ -2,9 +2,13 @@ package api
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
+	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/matiasinsaurralde/product-services/payment"
 )
@@ -20,28 +24,89 @@ const (
 	PATH_DIR
 	// PATH_PAYMENT state is used for paths that involve a payments file:
 	PATH_PAYMENT
+	// PATH_QUERY state is used for range queries that span multiple payments files:
+	PATH_QUERY
+	// PATH_EVENTS state is used for the Server-Sent Events stream of new payments files:
+	PATH_EVENTS
+	// PATH_SUMMARY state is used for the aggregates of a payments file, a directory or a range query:
+	PATH_SUMMARY
+	// PATH_INTEGRITY state is used for the sequence number checks of a directory:
+	PATH_INTEGRITY
+	// PATH_METRICS state is used for the Prometheus metrics endpoint:
+	PATH_METRICS
+	// PATH_HEALTH state is used for the liveness check:
+	PATH_HEALTH
+	// PATH_READY state is used for the readiness check of the data directory:
+	PATH_READY
 	// PATH_ERROR state is used for all other paths that don't match the existing ones:
 	PATH_ERROR
 )
 
+const (
+	// queryPath is the route used for range queries, it never collides with a YYYYMMDD directory:
+	queryPath = "payments"
+	// eventsPath is the route used for the events stream:
+	eventsPath = "events"
+	// summaryPath is appended to file, directory and range query routes to get their aggregates:
+	summaryPath = "summary"
+	// integrityPath is appended to directory routes to check their sequence numbers:
+	integrityPath = "integrity"
+	// maxUploadSize limits the size of uploaded payments files:
+	maxUploadSize = 512 << 20
+	// rejectedRowsHeader reports how many rows were skipped when parsing a payments file:
+	rejectedRowsHeader = "X-Rejected-Rows"
+)
+
 // Handler is the main API Handler abstraction
 // Handler implements ServeHTTP in order to satisfy the http.Handler interface
 type Handler struct {
 	// paymentsService wraps the logic of the payments service associated with this handler
 	paymentsService *payment.PaymentsService
+	// watcher publishes new directories and payments files to the events stream,
+	// it's started when the first client subscribes:
+	watcher      *payment.Watcher
+	watcherStart sync.Once
+	// closed ends the event streams when the handler is closed:
+	closed    chan struct{}
+	closeOnce sync.Once
+	// metrics counts requests and parsed files, see the /metrics route:
+	metrics *handlerMetrics
+
+	// StaleAfter makes /readyz fail when no payments file newer than this window exists, zero disables the check:
+	StaleAfter time.Duration
 }
 
 // NewHandler initializes a new API handler with baseDir as the base data directory
-// NewHandler returns an http.Handler and an error if the payment service initialization failed.
-func NewHandler(baseDir string) (http.Handler, error) {
+// NewHandler returns a Handler and an error if the payment service initialization failed.
+func NewHandler(baseDir string) (*Handler, error) {
 	paymentsService, err := payment.NewWithBaseDir(baseDir)
 	if err != nil {
 		return nil, err
 	}
+	return NewHandlerWithService(paymentsService), nil
+}
+
+// NewHandlerWithService initializes a new API handler with an existing payments service,
+// this is useful when the service isn't backed by a data directory.
+// The handler becomes the parse observer of the service unless it already has one.
+func NewHandlerWithService(paymentsService *payment.PaymentsService) *Handler {
 	h := &Handler{
 		paymentsService: paymentsService,
+		watcher:         payment.NewWatcher(paymentsService, defaultWatchInterval),
+		metrics:         newHandlerMetrics(paymentsService),
+		closed:          make(chan struct{}),
 	}
-	return h, nil
+	if paymentsService.Observer == nil {
+		paymentsService.Observer = h.metrics
+	}
+	return h
+}
+
+// Close stops the background work of the handler, like the events watcher, and ends the active event streams.
+// Other requests aren't affected, so Close can be called when a graceful shutdown starts.
+func (h *Handler) Close() error {
+	h.closeOnce.Do(func() { close(h.closed) })
+	return h.watcher.Close()
 }
 
 // parsePath is a helper to cleanup the URL path and extract its params
@@ -59,86 +124,267 @@ func (h *Handler) parsePath(path string) (t PathType, params []string) {
 	case 0:
 		return PATH_ROOT, nil
 	case 1:
+		switch params[0] {
+		case queryPath:
+			return PATH_QUERY, nil
+		case eventsPath:
+			return PATH_EVENTS, nil
+		case metricsPath:
+			return PATH_METRICS, nil
+		case healthPath:
+			return PATH_HEALTH, nil
+		case readyPath:
+			return PATH_READY, nil
+		}
 		return PATH_DIR, params
 	case 2:
+		switch params[1] {
+		case summaryPath:
+			return PATH_SUMMARY, params[:1]
+		case integrityPath:
+			return PATH_INTEGRITY, params[:1]
+		}
 		return PATH_PAYMENT, params
+	case 3:
+		if params[2] == summaryPath {
+			return PATH_SUMMARY, params[:2]
+		}
+		return PATH_ERROR, nil
 	default:
 		return PATH_ERROR, nil
 	}
 }
 
-// serveNotFound is a helper that returns HTTP 404
-func (h *Handler) serveNotFound(w http.ResponseWriter) {
-	w.WriteHeader(404)
-	w.Write([]byte("not found"))
+// parseRange is a helper that reads the from and to parameters of range queries,
+// timestamps without an offset use the time zone of the data directory
+func (h *Handler) parseRange(r *http.Request) (from, to time.Time, err error) {
+	query := r.URL.Query()
+	if from, err = h.paymentsService.ParseTimestamp(query.Get("from")); err != nil {
+		return
+	}
+	if to, err = h.paymentsService.ParseTimestamp(query.Get("to")); err != nil {
+		return
+	}
+	if to.Before(from) {
+		err = errors.New("invalid range")
+	}
+	return
 }
 
-// serveError is a helper that returns HTTP 500
-func (h *Handler) serveError(w http.ResponseWriter) {
-	w.WriteHeader(500)
-	w.Write([]byte("server error"))
+// serveJSON is a helper that returns a given HTTP status with the JSON representation of v
+func (h *Handler) serveJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	rawJSON, err := json.Marshal(v)
+	if err != nil {
+		h.serveError(w, r, err)
+		return
+	}
+	w.Header().Add("content-type", "application/json")
+	w.WriteHeader(status)
+	w.Write(rawJSON)
 }
 
 // ServeHTTP satisfies the http.Handler interface by implementing all the HTTP logic of the API
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r = withRequestID(w, r)
 	pathType, urlParams := h.parsePath(r.URL.Path)
+	// Requests are counted even when the handler aborts a stream:
+	recorder := &statusRecorder{ResponseWriter: w}
+	start := time.Now()
+	defer func() {
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		h.metrics.observeRequest(pathType, r.Method, status, time.Since(start))
+	}()
+	h.route(recorder, r, pathType, urlParams)
+}
+
+// route is a helper that dispatches a request to the logic of its route
+func (h *Handler) route(w http.ResponseWriter, r *http.Request, pathType PathType, urlParams []string) {
+	if pathType == PATH_ERROR {
+		h.serveNotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPut:
+		// Uploads are only allowed for payments files:
+		if pathType == PATH_PAYMENT {
+			h.putPayments(w, r, urlParams[0], urlParams[1])
+			return
+		}
+		h.serveMethodNotAllowed(w, r, "GET, HEAD")
+		return
+	default:
+		if pathType == PATH_PAYMENT {
+			h.serveMethodNotAllowed(w, r, "GET, HEAD, PUT")
+			return
+		}
+		h.serveMethodNotAllowed(w, r, "GET, HEAD")
+		return
+	}
+	// Listings and payments are available in all registered formats,
+	// the report, summaries, integrity checks, events and errors are always JSON:
+	var enc Encoder
+	switch pathType {
+	case PATH_ROOT, PATH_DIR, PATH_PAYMENT, PATH_QUERY:
+		// Only payments files have a report, the parameter is ignored by the other routes:
+		if pathType == PATH_PAYMENT && r.URL.Query().Get("report") == "true" {
+			break
+		}
+		var ok bool
+		if enc, ok = h.negotiate(w, r); !ok {
+			return
+		}
+	}
 	switch pathType {
 	case PATH_PAYMENT:
+		filter, sortKeys, err := parsePaymentQuery(r)
+		if err != nil {
+			h.serveBadRequest(w, r, err.Error())
+			return
+		}
+		// Sorted pages are keyed by position, otherwise pages are keyed by sequence:
+		cursorKind := cursorSequence
+		if sortKeys != nil {
+			cursorKind = cursorOffset
+		}
+		page, err := parsePageRequest(r, cursorKind)
+		if err != nil {
+			h.serveBadRequest(w, r, err.Error())
+			return
+		}
+		// Formats that support it are written row by row, so big files don't have to fit in memory,
+		// pages and sorted responses need the whole file:
+		if streamEnc, ok := enc.(StreamEncoder); ok && !page.paginated && sortKeys == nil {
+			h.streamPayments(w, r, streamEnc, strings.Join(urlParams, "/"), filter)
+			return
+		}
 		// Call GetPayments with all available URL params
 		// In this case urlParams looks like YYYYMMDD/HHMMSS.payment
-		payments, err := h.paymentsService.GetPayments(strings.Join(urlParams, "/"))
+		result, err := h.paymentsService.GetPaymentsReport(strings.Join(urlParams, "/"))
 		if err != nil {
-			log.Printf("error: %s\n", err.Error())
-			h.serveNotFound(w)
+			// Invalid headers, or invalid rows in strict mode, are reported as unparsable files:
+			h.serveServiceError(w, r, err)
 			return
 		}
-		paymentsJSON, err := json.Marshal(payments)
-		if err != nil {
-			log.Printf("error: %s\n", err.Error())
-			h.serveError(w)
+		// Rows rejected in lenient mode are counted in a header,
+		// the full report is available with ?report=true
+		if len(result.Rejected) > 0 {
+			w.Header().Set(rejectedRowsHeader, strconv.Itoa(len(result.Rejected)))
+		}
+		if r.URL.Query().Get("report") == "true" {
+			h.serveJSON(w, r, 200, result)
 			return
 		}
-		w.WriteHeader(200)
-		w.Header().Add("content-type", "application/json")
-		w.Write(paymentsJSON)
+		payments := filter.Apply(result.Payments)
+		if sortKeys != nil {
+			payment.SortPayments(payments, sortKeys)
+		}
+		if page.paginated {
+			var next *pageCursor
+			total := len(payments)
+			if sortKeys != nil {
+				payments, next = paginateOffset(page, payments)
+			} else {
+				payments, next = paginatePayments(page, payments)
+			}
+			setPageHeaders(w, r, page, total, next)
+		}
+		h.servePayments(w, r, enc, payments)
 		return
-	case PATH_ROOT:
-		dirs, err := h.paymentsService.ListDirectories()
+	case PATH_QUERY:
+		// Range queries look like /payments?from=20220717063000&to=20220718235959
+		from, to, err := h.parseRange(r)
 		if err != nil {
-			log.Printf("error: %s\n", err.Error())
-			h.serveError(w)
+			h.serveBadRequest(w, r, err.Error())
 			return
 		}
-		dirsJSON, err := json.Marshal(dirs)
+		filter, sortKeys, err := parsePaymentQuery(r)
 		if err != nil {
-			log.Printf("error: %s\n", err.Error())
-			h.serveError(w)
+			h.serveBadRequest(w, r, err.Error())
 			return
 		}
-		w.WriteHeader(200)
-		w.Header().Add("content-type", "application/json")
-		w.Write(dirsJSON)
+		payments, err := h.paymentsService.QueryRange(from, to)
+		if err != nil {
+			h.serveServiceError(w, r, err)
+			return
+		}
+		payments = filter.Apply(payments)
+		if sortKeys != nil {
+			payment.SortPayments(payments, sortKeys)
+		}
+		h.servePayments(w, r, enc, payments)
 		return
-	case PATH_DIR:
-		// Call ListPayments with a single parameter, like "YYYYMMDD":
-		dirs, err := h.paymentsService.ListPayments(urlParams[0])
+	case PATH_EVENTS:
+		h.serveEvents(w, r)
+		return
+	case PATH_METRICS:
+		h.metrics.registry.ServeHTTP(w, r)
+		return
+	case PATH_HEALTH:
+		h.serveHealth(w, r)
+		return
+	case PATH_READY:
+		h.serveReady(w, r)
+		return
+	case PATH_SUMMARY:
+		h.serveSummary(w, r, urlParams)
+		return
+	case PATH_INTEGRITY:
+		h.serveIntegrity(w, r, urlParams[0])
+		return
+	case PATH_ROOT:
+		dirs, err := h.paymentsService.ListDirectories()
 		if err != nil {
-			log.Printf("error: %s\n", err.Error())
-			h.serveNotFound(w)
+			h.serveError(w, r, err)
 			return
 		}
-		dirsJSON, err := json.Marshal(dirs)
+		// Authenticated clients only see the directories they can access:
+		if permissions := h.permissions(r); permissions != nil {
+			dirs = permissions.filterDirectories(dirs)
+		}
+		h.serveNamesPage(w, r, enc, cursorDirectory, dirs)
+		return
+	case PATH_DIR:
+		// Call ListPayments with a single parameter, like "YYYYMMDD":
+		files, err := h.paymentsService.ListPayments(urlParams[0])
 		if err != nil {
-			log.Printf("error: %s\n", err.Error())
-			h.serveError(w)
+			h.serveServiceError(w, r, err)
 			return
 		}
-		w.WriteHeader(200)
-		w.Header().Add("content-type", "application/json")
-		w.Write(dirsJSON)
+		h.serveNamesPage(w, r, enc, cursorFile, files)
+		return
+	}
+}
+
+// putPayments handles payments file uploads, like PUT /YYYYMMDD/HHMMSS.payments
+func (h *Handler) putPayments(w http.ResponseWriter, r *http.Request, dir, name string) {
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxUploadSize))
+	if errors.Is(err, errBodyHash) {
+		h.serveErrorCode(w, r, CodeUnauthorized, err.Error(), nil)
+		return
+	}
+	if err != nil {
+		h.serveBadRequest(w, r, "couldn't read request body: "+err.Error())
 		return
-	case PATH_ERROR:
-		h.serveError(w)
+	}
+	payments, created, err := h.paymentsService.PutPayments(dir, name, data)
+	if err != nil {
+		h.serveServiceError(w, r, err)
 		return
 	}
+	result := struct {
+		Path  string `json:"path"`
+		Count int    `json:"count"`
+	}{
+		Path:  dir + "/" + name,
+		Count: len(payments),
+	}
+	status := 200
+	if created {
+		status = 201
+	}
+	h.serveJSON(w, r, status, result)
 }
@@ -10,10 +10,17 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/matiasinsaurralde/product-services/payment"
 )
 
+// testTimestamp is a helper that parses a date+time string like 20220717090000 as UTC
+func testTimestamp(s string) time.Time {
+	t, _ := payment.ParseTimestamp(s)
+	return t
+}
+
 var (
 	testRawData = map[string]string{
 		"20220717/090000.payments": `date,time,sequence,amount,comment
@@ -26,12 +33,12 @@ var (
 
 	testDesiredData = map[string][]payment.Payment{
 		"20220717/090000.payments": {
-			{AsOf: 20220717090000, Sequence: 211, Amount: 500, Comment: "payment2"},
-			{AsOf: 20220717090000, Sequence: 212, Amount: 600, Comment: "payment3"},
+			{AsOf: testTimestamp("20220717090000"), Sequence: 211, Amount: payment.Money{Minor: 50000, Currency: "USD"}, Comment: "payment2"},
+			{AsOf: testTimestamp("20220717090000"), Sequence: 212, Amount: payment.Money{Minor: 60000, Currency: "USD"}, Comment: "payment3"},
 		},
 		"20220718/010101.payments": {
-			{AsOf: 20220718010101, Sequence: 300, Amount: 1500, Comment: "payment4"},
-			{AsOf: 20220718010101, Sequence: 301, Amount: 3000, Comment: "payment5"},
+			{AsOf: testTimestamp("20220718010101"), Sequence: 300, Amount: payment.Money{Minor: 150000, Currency: "USD"}, Comment: "payment4"},
+			{AsOf: testTimestamp("20220718010101"), Sequence: 301, Amount: payment.Money{Minor: 300000, Currency: "USD"}, Comment: "payment5"},
 		},
 	}
 
@@ -50,31 +57,155 @@ func strInSlice(a string, s []string) bool {
 	}
 	return false
 }
-func TestHandler(t *testing.T) {
-	// Initialize some test data:
-	tempDir, err := ioutil.TempDir("/tmp", "test")
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer os.RemoveAll(tempDir)
-	// Populate test directory:
+
+// testPaymentsService is a helper that initializes a PaymentsService backed by a MemoryStore
+// populated with testRawData
+func testPaymentsService() *payment.PaymentsService {
+	store := payment.NewMemoryStore()
 	for fullPath, rawCSV := range testRawData {
-		basePath := filepath.Dir(fullPath)                  // 20220717
-		prefixedDirPath := filepath.Join(tempDir, basePath) // /tmpdir/20220717
-		if err := os.Mkdir(prefixedDirPath, 0700); err != nil {
+		// fullPath looks like 20220717/090000.payments:
+		store.AddFile(filepath.Dir(fullPath), filepath.Base(fullPath), []byte(rawCSV))
+	}
+	return payment.NewWithStore(store)
+}
+
+// TestHandlerParseModes covers how rejected rows are surfaced in both parse modes
+func TestHandlerParseModes(t *testing.T) {
+	store := payment.NewMemoryStore()
+	store.AddFile("20220717", "090000.payments", []byte(`date,time,sequence,amount,comment
+20220717,090000,211,500,payment2
+20220717,090000,212,abc,payment3`))
+	store.AddFile("20220717", "090100.payments", []byte(`date,time,sequence,amount,comment
+20220717,090100,213,abc,payment4
+20220717,090100,214,500,payment5`))
+	paymentsService := payment.NewWithStore(store)
+	ts := httptest.NewServer(NewHandlerWithService(paymentsService))
+	defer ts.Close()
+
+	t.Run("lenient", func(t *testing.T) {
+		res, err := http.Get(ts.URL + "/20220717/090000.payments?report=true")
+		if err != nil {
 			t.Fatal(err)
 		}
-		prefixedFilePath := filepath.Join(tempDir, fullPath) // /tmpdir/20220717/090000.payments
-		err := ioutil.WriteFile(prefixedFilePath, []byte(rawCSV), 0700)
-		if err != nil {
+		defer res.Body.Close()
+		if res.StatusCode != 200 {
+			t.Fatalf("invalid status code, got %d, expected %d", res.StatusCode, 200)
+		}
+		if res.Header.Get(rejectedRowsHeader) != "1" {
+			t.Fatalf("invalid %s header, got '%s', expected '1'", rejectedRowsHeader, res.Header.Get(rejectedRowsHeader))
+		}
+		var result payment.ParseResult
+		if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
 			t.Fatal(err)
 		}
+		if len(result.Payments) != 1 || len(result.Rejected) != 1 {
+			t.Fatalf("unexpected report %+v", result)
+		}
+		if result.Rejected[0].Line != 3 || result.Rejected[0].Column != "amount" || result.Rejected[0].Value != "abc" {
+			t.Fatalf("unexpected row error %+v", result.Rejected[0])
+		}
+	})
+	t.Run("strict", func(t *testing.T) {
+		paymentsService.ParseMode = payment.ParseStrict
+		// Invalid files are rejected wherever the invalid rows are, streamed or not:
+		for _, path := range []string{
+			"/20220717/090000.payments",
+			"/20220717/090000.payments?format=ndjson",
+			"/20220717/090100.payments",
+			"/20220717/090100.payments?format=csv",
+		} {
+			res, err := http.Get(ts.URL + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != 422 {
+				t.Fatalf("invalid status code for %s, got %d, expected %d", path, res.StatusCode, 422)
+			}
+		}
+	})
+}
+
+// TestHandlerReportParameter covers routes that don't have a report and ignore the report parameter
+func TestHandlerReportParameter(t *testing.T) {
+	h := NewHandlerWithService(testPaymentsService())
+	defer h.Close()
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	for _, path := range []string{
+		"/?report=true",
+		"/20220717/?report=true",
+		"/payments?from=20220717000000&to=20220719000000&report=true",
+	} {
+		res, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("request for %s failed: %s", path, err.Error())
+		}
+		var body []interface{}
+		err = json.NewDecoder(res.Body).Decode(&body)
+		res.Body.Close()
+		if res.StatusCode != 200 || err != nil || len(body) == 0 {
+			t.Fatalf("invalid response for %s, got %d %v", path, res.StatusCode, err)
+		}
 	}
+}
 
-	h, err := NewHandler(tempDir)
+// TestHandlerPathTraversal covers encoded and symlink escapes from the data directory
+func TestHandlerPathTraversal(t *testing.T) {
+	rootDir := t.TempDir()
+	baseDir := filepath.Join(rootDir, "data")
+	if err := os.MkdirAll(filepath.Join(baseDir, "20220717"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	secret := "date,time,sequence,amount,comment\n20220717,090000,1,1,secret"
+	if err := ioutil.WriteFile(filepath.Join(rootDir, "090000.payments"), []byte(secret), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Symlinks pointing outside of the data directory, using valid names:
+	if err := os.Symlink(rootDir, filepath.Join(baseDir, "20220718")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(rootDir, "090000.payments"), filepath.Join(baseDir, "20220717", "090000.payments")); err != nil {
+		t.Fatal(err)
+	}
+	h, err := NewHandler(baseDir)
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer h.Close()
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	paths := []string{
+		"/../090000.payments",
+		"/%2e%2e/090000.payments",
+		"/%2E%2E/",
+		"/20220717/..%2F090000.payments",
+		"/20220717%2F..%2F../090000.payments",
+		"/..%5C090000.payments/",
+		"/20220718/",
+		"/20220718/090000.payments",
+		"/20220717/090000.payments",
+	}
+	for _, path := range paths {
+		res, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rawBody, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode == 200 || strings.Contains(string(rawBody), "secret") {
+			t.Fatalf("path '%s' escaped the data directory: %d %s", path, res.StatusCode, rawBody)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	h := NewHandlerWithService(testPaymentsService())
 	ts := httptest.NewServer(h)
 
 	t.Run("list directories", func(t *testing.T) {
@@ -178,10 +309,10 @@ func TestHandler(t *testing.T) {
 				}
 				// Compare fields:
 				if p.Amount != p2.Amount {
-					t.Fatalf("amount field doesn't match, got %d, expected %d", p.Amount, p2.Amount)
+					t.Fatalf("amount field doesn't match, got %s, expected %s", p.Amount, p2.Amount)
 				}
-				if p.AsOf != p2.AsOf {
-					t.Fatalf("asOf field doesn't match, got %d, expected %d", p.AsOf, p2.AsOf)
+				if !p.AsOf.Equal(p2.AsOf) {
+					t.Fatalf("asOf field doesn't match, got %s, expected %s", p.AsOf, p2.AsOf)
 				}
 				if strings.Compare(p.Comment, p2.Comment) != 0 {
 					t.Fatalf("comment field doesn't match, got '%s', expected '%s'", p.Comment, p2.Comment)
@@ -189,6 +320,82 @@ func TestHandler(t *testing.T) {
 			}
 		}
 	})
+	t.Run("query range", func(t *testing.T) {
+		url := fmt.Sprintf("%s/payments?from=20220717000000&to=20220718235959", ts.URL)
+		res, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != 200 {
+			t.Fatalf("invalid status code, got %d, expected %d", res.StatusCode, 200)
+		}
+		var payments []payment.Payment
+		if err := json.NewDecoder(res.Body).Decode(&payments); err != nil {
+			t.Fatal(err)
+		}
+		expectedSequences := []int{211, 212, 300, 301}
+		if len(payments) != len(expectedSequences) {
+			t.Fatalf("invalid payments length, got %d, expected %d", len(payments), len(expectedSequences))
+		}
+		for i, p := range payments {
+			if p.Sequence != expectedSequences[i] {
+				t.Fatalf("invalid sequence at position %d, got %d, expected %d", i, p.Sequence, expectedSequences[i])
+			}
+		}
+	})
+	t.Run("query range with invalid params", func(t *testing.T) {
+		for _, query := range []string{"", "?from=20220717000000", "?from=xyz&to=20220718235959", "?from=20220718000000&to=20220717000000"} {
+			res, err := http.Get(ts.URL + "/payments" + query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != 400 {
+				t.Fatalf("invalid status code for '%s', got %d, expected %d", query, res.StatusCode, 400)
+			}
+		}
+	})
+	t.Run("upload payments", func(t *testing.T) {
+		testCases := []struct {
+			path, body string
+			status     int
+		}{
+			{"/20220719/120000.payments", testRawData["20220717/090000.payments"], 201},
+			{"/20220719/120000.payments", testRawData["20220717/090000.payments"], 200},
+			{"/2022_07_19/120000.payments", testRawData["20220717/090000.payments"], 400},
+			{"/20220719/12.payments", testRawData["20220717/090000.payments"], 400},
+			{"/20220719/130000.payments", "date,time,sequence,amount,comment\n20220719,130000,x,1,c", 422},
+			{"/20220719/", testRawData["20220717/090000.payments"], 405},
+		}
+		for _, tc := range testCases {
+			req, err := http.NewRequest(http.MethodPut, ts.URL+tc.path, strings.NewReader(tc.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != tc.status {
+				t.Fatalf("invalid status code for '%s', got %d, expected %d", tc.path, res.StatusCode, tc.status)
+			}
+		}
+		// Uploaded file should be available:
+		res, err := http.Get(ts.URL + "/20220719/120000.payments")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var payments []payment.Payment
+		if err := json.NewDecoder(res.Body).Decode(&payments); err != nil {
+			t.Fatal(err)
+		}
+		if len(payments) != 2 {
+			t.Fatalf("invalid payments length, got %d, expected %d", len(payments), 2)
+		}
+	})
 
 	defer ts.Close()
 }
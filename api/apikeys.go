@@ -0,0 +1,186 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// AuthAPIKey is the authentication method of API keys:
+	AuthAPIKey = "api-key"
+	// apiKeyHeader carries API keys, they're also accepted as "Authorization: ApiKey <key>":
+	apiKeyHeader = "X-Api-Key"
+	// apiKeyScheme is the authorization scheme and challenge of API keys:
+	apiKeyScheme = "ApiKey"
+	// sha256Prefix is the only hash format supported by keys files:
+	sha256Prefix = "sha256:"
+)
+
+// APIKey is an entry of the keys file, the key itself isn't stored, only its SHA-256 hash
+type APIKey struct {
+	// ID names the key in logs and error messages:
+	ID string `json:"id" yaml:"id"`
+	// Hash is the hex encoded SHA-256 hash of the key, like sha256:9f86d0...
+	Hash        string `json:"hash" yaml:"hash"`
+	Permissions `yaml:",inline"`
+}
+
+// ClientCertificate is an entry of the keys file granting permissions to verified client certificates
+type ClientCertificate struct {
+	// Name matches the common name, the whole subject, or a DNS, email or URI subject alternative name of the certificate:
+	Name        string `json:"name" yaml:"name"`
+	Permissions `yaml:",inline"`
+}
+
+// apiKeysFile is the layout of the keys file
+type apiKeysFile struct {
+	Keys        []APIKey            `json:"keys" yaml:"keys"`
+	ClientCerts []ClientCertificate `json:"clientCerts" yaml:"clientCerts"`
+}
+
+// APIKeys authenticates requests with the API keys of a keys file,
+// requests without a key are authenticated with their verified client certificate, if it's listed in the file
+type APIKeys struct {
+	// keys maps the hex encoded hashes to their entries:
+	keys map[string]*APIKey
+	// certs maps certificate names to their entries:
+	certs map[string]*ClientCertificate
+}
+
+// HashAPIKey returns the value stored in the hash field of the keys file for a given key
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return sha256Prefix + hex.EncodeToString(sum[:])
+}
+
+// LoadAPIKeys reads a keys file, files ending with .json are read as JSON and everything else as YAML
+func LoadAPIKeys(path string) (*APIKeys, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read API keys file: %w", err)
+	}
+	var file apiKeysFile
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&file)
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(&file)
+		if err == io.EOF {
+			err = nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid API keys file '%s': %s", path, err.Error())
+	}
+	keys, err := NewAPIKeys(file.Keys)
+	if err == nil {
+		err = keys.addClientCertificates(file.ClientCerts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid API keys file '%s': %s", path, err.Error())
+	}
+	return keys, nil
+}
+
+// NewAPIKeys validates a list of keys and returns their authenticator
+func NewAPIKeys(keys []APIKey) (*APIKeys, error) {
+	a := &APIKeys{keys: make(map[string]*APIKey, len(keys)), certs: make(map[string]*ClientCertificate)}
+	ids := make(map[string]bool, len(keys))
+	for i := range keys {
+		key := &keys[i]
+		if key.ID == "" {
+			return nil, fmt.Errorf("key %d: missing id", i+1)
+		}
+		if ids[key.ID] {
+			return nil, fmt.Errorf("key '%s': duplicate id", key.ID)
+		}
+		ids[key.ID] = true
+		hash := strings.ToLower(key.Hash)
+		if !strings.HasPrefix(hash, sha256Prefix) {
+			return nil, fmt.Errorf("key '%s': expected a %s hash", key.ID, sha256Prefix)
+		}
+		if b, err := hex.DecodeString(hash[len(sha256Prefix):]); err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("key '%s': invalid SHA-256 hash", key.ID)
+		}
+		if _, ok := a.keys[hash]; ok {
+			return nil, fmt.Errorf("key '%s': duplicate hash", key.ID)
+		}
+		if err := key.Permissions.Validate(); err != nil {
+			return nil, fmt.Errorf("key '%s': %s", key.ID, err.Error())
+		}
+		a.keys[hash] = key
+	}
+	return a, nil
+}
+
+// addClientCertificates is a helper that validates and adds the client certificate entries of the keys file
+func (a *APIKeys) addClientCertificates(certs []ClientCertificate) error {
+	for i := range certs {
+		cert := &certs[i]
+		if cert.Name == "" {
+			return fmt.Errorf("client certificate %d: missing name", i+1)
+		}
+		if _, ok := a.certs[cert.Name]; ok {
+			return fmt.Errorf("client certificate '%s': duplicate name", cert.Name)
+		}
+		if err := cert.Permissions.Validate(); err != nil {
+			return fmt.Errorf("client certificate '%s': %s", cert.Name, err.Error())
+		}
+		a.certs[cert.Name] = cert
+	}
+	return nil
+}
+
+// Authenticate satisfies Authenticator, the key is read from the X-Api-Key or Authorization headers
+func (a *APIKeys) Authenticate(r *http.Request) (*Identity, error) {
+	key := r.Header.Get(apiKeyHeader)
+	if key == "" {
+		fields := strings.Fields(r.Header.Get("authorization"))
+		if len(fields) != 2 || !strings.EqualFold(fields[0], apiKeyScheme) {
+			return a.authenticateCertificate(r)
+		}
+		key = fields[1]
+	}
+	entry, ok := a.keys[HashAPIKey(key)]
+	if !ok {
+		return nil, errors.New("invalid API key")
+	}
+	permissions := entry.Permissions
+	return &Identity{Name: entry.ID, Method: AuthAPIKey, Permissions: &permissions}, nil
+}
+
+// authenticateCertificate is a helper that returns the identity of a verified client certificate listed in the keys file,
+// ErrNoCredentials is returned for other requests
+func (a *APIKeys) authenticateCertificate(r *http.Request) (*Identity, error) {
+	cert := verifiedCertificate(r)
+	// Other credentials, like bearer tokens, take precedence over certificates:
+	if cert == nil || r.Header.Get("authorization") != "" {
+		return nil, ErrNoCredentials
+	}
+	for _, name := range certificateNames(cert) {
+		if entry, ok := a.certs[name]; ok {
+			permissions := entry.Permissions
+			return &Identity{Name: certificateName(cert), Method: AuthClientCertificate, Permissions: &permissions}, nil
+		}
+	}
+	return nil, ErrNoCredentials
+}
+
+// Challenge satisfies Authenticator
+func (a *APIKeys) Challenge() string {
+	return apiKeyScheme
+}
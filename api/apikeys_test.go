@@ -0,0 +1,64 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadAPIKeys(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	hash := HashAPIKey("secret")
+
+	t.Run("yaml and json", func(t *testing.T) {
+		files := []string{
+			writeFile("keys.yaml", "keys:\n  - id: reports\n    hash: "+hash+"\n    read: true\n    from: \"20220701\"\n"),
+			writeFile("keys.json", `{"keys": [{"id": "reports", "hash": "`+hash+`", "read": true, "from": "20220701"}]}`),
+		}
+		for _, path := range files {
+			keys, err := LoadAPIKeys(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("authorization", "ApiKey secret")
+			identity, err := keys.Authenticate(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if identity.Name != "reports" || identity.Method != AuthAPIKey || !identity.Permissions.Read || identity.Permissions.From != "20220701" {
+				t.Fatalf("invalid identity from %s, got %+v %+v", path, identity, identity.Permissions)
+			}
+		}
+	})
+
+	cases := []struct {
+		name, content, expected string
+	}{
+		{"missing id", "keys:\n  - hash: " + hash, "missing id"},
+		{"plaintext key", "keys:\n  - id: a\n    hash: secret", "expected a sha256: hash"},
+		{"short hash", "keys:\n  - id: a\n    hash: sha256:abcd", "invalid SHA-256 hash"},
+		{"duplicate hash", "keys:\n  - id: a\n    hash: " + hash + "\n  - id: b\n    hash: " + hash, "duplicate hash"},
+		{"invalid permissions", "keys:\n  - id: a\n    hash: " + hash + "\n    to: tomorrow", "invalid date"},
+		{"unknown key", "keys:\n  - id: a\n    hash: " + hash + "\n    admin: true", "field admin not found"},
+		{"client certificate without name", "clientCerts:\n  - read: true", "missing name"},
+		{"duplicate client certificate", "clientCerts:\n  - name: a\n  - name: a", "duplicate name"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := LoadAPIKeys(writeFile("invalid.yaml", c.content))
+			if err == nil || !strings.Contains(err.Error(), c.expected) {
+				t.Fatalf("expected error containing '%s', got %v", c.expected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// dateLayout matches the directory names of the data directory:
+	dateLayout = "20060102"
+)
+
+// ErrNoCredentials is returned by authenticators when a request doesn't carry credentials for their method
+var ErrNoCredentials = errors.New("no credentials")
+
+// ErrAuthUnavailable is wrapped by authenticators that can't check credentials for the time being,
+// it's reported with HTTP 503 instead of 401
+var ErrAuthUnavailable = errors.New("authentication unavailable")
+
+// Authenticator authenticates requests with a given method, like API keys
+type Authenticator interface {
+	// Authenticate returns the identity of the request, ErrNoCredentials when the request doesn't use this method,
+	// or another error when the credentials are invalid:
+	Authenticate(r *http.Request) (*Identity, error)
+	// Challenge is the WWW-Authenticate value returned to unauthenticated requests, like ApiKey:
+	Challenge() string
+}
+
+// Permissions limit the operations and directories available to an identity
+type Permissions struct {
+	// Read allows listings, downloads, queries, summaries, integrity checks, events and metrics:
+	Read bool `json:"read" yaml:"read"`
+	// Write allows uploads:
+	Write bool `json:"write" yaml:"write"`
+	// Directories lists the YYYYMMDD directories that can be accessed, every directory when empty:
+	Directories []string `json:"directories,omitempty" yaml:"directories,omitempty"`
+	// From and To are inclusive YYYYMMDD bounds on the directories that can be accessed, unbounded when empty:
+	From string `json:"from,omitempty" yaml:"from,omitempty"`
+	To   string `json:"to,omitempty" yaml:"to,omitempty"`
+}
+
+// Validate checks the directory names and date bounds of the permissions
+func (p *Permissions) Validate() error {
+	for _, dir := range p.Directories {
+		if _, err := time.Parse(dateLayout, dir); err != nil {
+			return fmt.Errorf("invalid directory '%s'", dir)
+		}
+	}
+	for _, bound := range []string{p.From, p.To} {
+		if bound == "" {
+			continue
+		}
+		if _, err := time.Parse(dateLayout, bound); err != nil {
+			return fmt.Errorf("invalid date '%s', expected YYYYMMDD", bound)
+		}
+	}
+	if p.From != "" && p.To != "" && p.To < p.From {
+		return fmt.Errorf("invalid date range: %s is before %s", p.To, p.From)
+	}
+	return nil
+}
+
+// restricted reports whether the permissions are limited to some directories
+func (p *Permissions) restricted() bool {
+	return len(p.Directories) > 0 || p.From != "" || p.To != ""
+}
+
+// AllowsDirectory reports whether a YYYYMMDD directory can be accessed.
+// Valid directory names sort like dates, so bounds are compared as strings.
+func (p *Permissions) AllowsDirectory(dir string) bool {
+	if p.From != "" && dir < p.From {
+		return false
+	}
+	if p.To != "" && dir > p.To {
+		return false
+	}
+	if len(p.Directories) == 0 {
+		return true
+	}
+	for _, allowed := range p.Directories {
+		if dir == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsDates reports whether every directory between two YYYYMMDD dates can be accessed
+func (p *Permissions) AllowsDates(from, to string) bool {
+	if !p.restricted() {
+		return true
+	}
+	if !p.AllowsDirectory(from) || !p.AllowsDirectory(to) {
+		return false
+	}
+	if len(p.Directories) == 0 {
+		return true
+	}
+	// Every day must be listed, so ranges longer than the list are rejected without iterating:
+	day, _ := time.Parse(dateLayout, from)
+	last, _ := time.Parse(dateLayout, to)
+	if int(last.Sub(day).Hours()/24) >= len(p.Directories) {
+		return false
+	}
+	for ; !day.After(last); day = day.AddDate(0, 0, 1) {
+		if !p.AllowsDirectory(day.Format(dateLayout)) {
+			return false
+		}
+	}
+	return true
+}
+
+// filterDirectories is a helper that keeps the directory names, or YYYYMMDD/... paths, that can be accessed
+func (p *Permissions) filterDirectories(names []string) []string {
+	if !p.restricted() {
+		return names
+	}
+	allowed := make([]string, 0, len(names))
+	for _, name := range names {
+		if p.AllowsDirectory(strings.SplitN(name, "/", 2)[0]) {
+			allowed = append(allowed, name)
+		}
+	}
+	return allowed
+}
+
+// authHandler authenticates and authorizes requests before passing them to the API handler
+type authHandler struct {
+	handler        *Handler
+	authenticators []Authenticator
+}
+
+// RequireAuth returns an http.Handler that only passes authenticated and authorized requests to h.
+// Authenticators are tried in order, the first one that finds credentials decides. Requests without credentials
+// keep the identity set by previous middleware, like ClientCertificateIdentity, if any.
+// Requests without a valid identity are rejected with HTTP 401 and requests outside the identity permissions with HTTP 403,
+// health and readiness checks are always allowed.
+func (h *Handler) RequireAuth(authenticators ...Authenticator) http.Handler {
+	return &authHandler{handler: h, authenticators: authenticators}
+}
+
+func (a *authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h := a.handler
+	r = withRequestID(w, r)
+	pathType, urlParams := h.parsePath(r.URL.Path)
+	if pathType == PATH_HEALTH || pathType == PATH_READY {
+		h.ServeHTTP(w, r)
+		return
+	}
+	start := time.Now()
+	identity, err := a.authenticate(r)
+	if errors.Is(err, ErrAuthUnavailable) {
+		h.serveErrorCode(w, r, CodeUnavailable, err.Error(), nil)
+		h.metrics.observeRequest(pathType, r.Method, http.StatusServiceUnavailable, time.Since(start))
+		return
+	}
+	if err != nil {
+		challenges := make([]string, 0, len(a.authenticators))
+		for _, authenticator := range a.authenticators {
+			challenges = append(challenges, authenticator.Challenge())
+		}
+		// Client certificates are the only method when there are no authenticators:
+		if len(challenges) > 0 {
+			sort.Strings(challenges)
+			w.Header().Set("www-authenticate", strings.Join(challenges, ", "))
+		}
+		h.serveErrorCode(w, r, CodeUnauthorized, err.Error(), nil)
+		h.metrics.observeRequest(pathType, r.Method, http.StatusUnauthorized, time.Since(start))
+		return
+	}
+	r = r.WithContext(WithIdentity(r.Context(), identity))
+	if err := h.authorize(r, identity, pathType, urlParams); err != nil {
+		h.serveErrorCode(w, r, CodeForbidden, err.Error(), nil)
+		h.metrics.observeRequest(pathType, r.Method, http.StatusForbidden, time.Since(start))
+		return
+	}
+	h.ServeHTTP(w, r)
+}
+
+// authenticate is a helper that returns the identity of a request, an error is returned for unauthenticated requests
+func (a *authHandler) authenticate(r *http.Request) (*Identity, error) {
+	for _, authenticator := range a.authenticators {
+		identity, err := authenticator.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return identity, nil
+	}
+	if identity, ok := IdentityFromContext(r.Context()); ok {
+		return identity, nil
+	}
+	return nil, errors.New("authentication required")
+}
+
+// authorize is a helper that checks the permissions of an identity for a given route,
+// requests for unknown routes are allowed so they get their usual error response
+func (h *Handler) authorize(r *http.Request, identity *Identity, pathType PathType, urlParams []string) error {
+	permissions := identity.Permissions
+	if permissions == nil {
+		return fmt.Errorf("'%s' doesn't have any permission", identity.Name)
+	}
+	if pathType == PATH_ERROR {
+		return nil
+	}
+	switch {
+	case r.Method == http.MethodPut:
+		if !permissions.Write {
+			return fmt.Errorf("'%s' can't upload payments files", identity.Name)
+		}
+	case !permissions.Read:
+		return fmt.Errorf("'%s' can't read payments", identity.Name)
+	}
+	// Invalid directory names are allowed so they get their usual error response:
+	allowsDirectory := func(dir string) error {
+		if _, err := time.Parse(dateLayout, dir); err != nil || permissions.AllowsDirectory(dir) {
+			return nil
+		}
+		return fmt.Errorf("'%s' can't access directory '%s'", identity.Name, dir)
+	}
+	switch pathType {
+	case PATH_DIR, PATH_PAYMENT, PATH_INTEGRITY:
+		return allowsDirectory(urlParams[0])
+	case PATH_SUMMARY:
+		if urlParams[0] != queryPath {
+			return allowsDirectory(urlParams[0])
+		}
+		return h.authorizeRange(r, identity)
+	case PATH_QUERY:
+		return h.authorizeRange(r, identity)
+	}
+	// Listings and events are filtered, see Handler.permissions:
+	return nil
+}
+
+// authorizeRange is a helper that checks that every directory of a range query can be accessed,
+// invalid ranges are allowed so they get their usual error response
+func (h *Handler) authorizeRange(r *http.Request, identity *Identity) error {
+	from, to, err := h.parseRange(r)
+	if err != nil {
+		return nil
+	}
+	loc := h.paymentsService.TimeZone
+	if loc == nil {
+		loc = time.UTC
+	}
+	fromDate, toDate := from.In(loc).Format(dateLayout), to.In(loc).Format(dateLayout)
+	if !identity.Permissions.AllowsDates(fromDate, toDate) {
+		return fmt.Errorf("'%s' can't access directories between %s and %s", identity.Name, fromDate, toDate)
+	}
+	return nil
+}
+
+// permissions is a helper that returns the permissions of the request identity, nil when authentication isn't enabled
+func (h *Handler) permissions(r *http.Request) *Permissions {
+	if identity, ok := IdentityFromContext(r.Context()); ok {
+		return identity.Permissions
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPermissions(t *testing.T) {
+	t.Run("validate", func(t *testing.T) {
+		invalid := []Permissions{
+			{Directories: []string{"2022-07-17"}},
+			{From: "xyz"},
+			{From: "20220718", To: "20220717"},
+		}
+		for _, p := range invalid {
+			if err := p.Validate(); err == nil {
+				t.Fatalf("expected error for %+v", p)
+			}
+		}
+	})
+
+	cases := []struct {
+		name        string
+		permissions Permissions
+		from, to    string
+		expected    bool
+	}{
+		{"unrestricted", Permissions{}, "20220101", "20221231", true},
+		{"inside bounds", Permissions{From: "20220717", To: "20220718"}, "20220717", "20220718", true},
+		{"outside bounds", Permissions{From: "20220717", To: "20220718"}, "20220717", "20220719", false},
+		{"open bound", Permissions{From: "20220717"}, "20220718", "20230101", true},
+		{"listed directories", Permissions{Directories: []string{"20220717", "20220718"}}, "20220717", "20220718", true},
+		{"gap in directories", Permissions{Directories: []string{"20220716", "20220718"}}, "20220716", "20220718", false},
+		{"long range", Permissions{Directories: []string{"20220717"}}, "20220101", "20221231", false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if allowed := c.permissions.AllowsDates(c.from, c.to); allowed != c.expected {
+				t.Fatalf("invalid result for %s-%s, got %t, expected %t", c.from, c.to, allowed, c.expected)
+			}
+		})
+	}
+}
+
+// TestRequireAuth covers authentication and authorization with API keys
+func TestRequireAuth(t *testing.T) {
+	keys, err := NewAPIKeys([]APIKey{
+		{ID: "reader", Hash: HashAPIKey("reader-key"), Permissions: Permissions{Read: true}},
+		{ID: "scoped", Hash: HashAPIKey("scoped-key"), Permissions: Permissions{Read: true, Directories: []string{"20220717"}}},
+		{ID: "writer", Hash: HashAPIKey("writer-key"), Permissions: Permissions{Write: true, From: "20220719"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandlerWithService(testPaymentsService())
+	defer h.Close()
+	ts := httptest.NewServer(h.RequireAuth(keys))
+	defer ts.Close()
+
+	do := func(t *testing.T, method, path, key string) *http.Response {
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(testRawData["20220717/090000.payments"]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != "" {
+			req.Header.Set(apiKeyHeader, key)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	cases := []struct {
+		name, method, path, key string
+		statusCode              int
+	}{
+		{"health without key", http.MethodGet, "/healthz", "", 200},
+		{"missing key", http.MethodGet, "/", "", 401},
+		{"invalid key", http.MethodGet, "/", "xyz", 401},
+		{"listing", http.MethodGet, "/", "reader-key", 200},
+		{"download", http.MethodGet, "/20220718/010101.payments", "reader-key", 200},
+		{"download outside scope", http.MethodGet, "/20220718/010101.payments", "scoped-key", 403},
+		{"download inside scope", http.MethodGet, "/20220717/090000.payments", "scoped-key", 200},
+		{"invalid name inside scope", http.MethodGet, "/xyz/", "scoped-key", 400},
+		{"query inside scope", http.MethodGet, "/payments?from=20220717000000&to=20220717235959", "scoped-key", 200},
+		{"query outside scope", http.MethodGet, "/payments?from=20220717000000&to=20220718235959", "scoped-key", 403},
+		{"summary outside scope", http.MethodGet, "/20220718/010101.payments/summary", "scoped-key", 403},
+		{"upload without write", http.MethodPut, "/20220719/090000.payments", "reader-key", 403},
+		{"upload", http.MethodPut, "/20220719/090000.payments", "writer-key", 201},
+		{"upload outside scope", http.MethodPut, "/20220717/100000.payments", "writer-key", 403},
+		{"read without read", http.MethodGet, "/20220719/090000.payments", "writer-key", 403},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res := do(t, c.method, c.path, c.key)
+			defer res.Body.Close()
+			if res.StatusCode != c.statusCode {
+				t.Fatalf("invalid status code, got %d, expected %d", res.StatusCode, c.statusCode)
+			}
+			if c.statusCode != 401 && c.statusCode != 403 {
+				return
+			}
+			var response ErrorResponse
+			if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			if ErrorCodes[response.Code] != c.statusCode || response.RequestID == "" {
+				t.Fatalf("invalid error response %+v", response)
+			}
+			if c.statusCode == 401 && res.Header.Get("www-authenticate") != apiKeyScheme {
+				t.Fatalf("invalid challenge, got '%s', expected '%s'", res.Header.Get("www-authenticate"), apiKeyScheme)
+			}
+		})
+	}
+
+	t.Run("filtered listing", func(t *testing.T) {
+		res := do(t, http.MethodGet, "/", "scoped-key")
+		defer res.Body.Close()
+		var dirs []string
+		if err := json.NewDecoder(res.Body).Decode(&dirs); err != nil {
+			t.Fatal(err)
+		}
+		if len(dirs) != 1 || dirs[0] != "20220717" {
+			t.Fatalf("invalid directories, got %v, expected %v", dirs, []string{"20220717"})
+		}
+	})
+
+	t.Run("client certificates", func(t *testing.T) {
+		err := keys.addClientCertificates([]ClientCertificate{
+			{Name: "batch-job", Permissions: Permissions{Read: true}},
+			{Name: "reports.example.com", Permissions: Permissions{Read: true, Directories: []string{"20220717"}}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler := ClientCertificateIdentity(h.RequireAuth(keys))
+		verified := func(cert *x509.Certificate) *tls.ConnectionState {
+			return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		}
+		cases := []struct {
+			name       string
+			state      *tls.ConnectionState
+			path       string
+			statusCode int
+		}{
+			{"common name", verified(&x509.Certificate{Subject: pkix.Name{CommonName: "batch-job"}}), "/20220718/", 200},
+			{"DNS name", verified(&x509.Certificate{Subject: pkix.Name{CommonName: "reports"}, DNSNames: []string{"reports.example.com"}}), "/20220717/", 200},
+			{"DNS name outside scope", verified(&x509.Certificate{Subject: pkix.Name{CommonName: "reports"}, DNSNames: []string{"reports.example.com"}}), "/20220718/", 403},
+			{"unlisted certificate", verified(&x509.Certificate{Subject: pkix.Name{CommonName: "intruder"}}), "/", 403},
+			{"unverified certificate", &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "batch-job"}}}}, "/", 401},
+		}
+		for _, c := range cases {
+			t.Run(c.name, func(t *testing.T) {
+				r := httptest.NewRequest(http.MethodGet, c.path, nil)
+				r.TLS = c.state
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, r)
+				if w.Code != c.statusCode {
+					t.Fatalf("invalid status code, got %d, expected %d", w.Code, c.statusCode)
+				}
+			})
+		}
+	})
+}
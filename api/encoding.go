@@ -0,0 +1,432 @@
+package api
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/matiasinsaurralde/product-services/payment"
+)
+
+// Encoder writes API responses in a given format
+type Encoder interface {
+	// ContentType is the value used for the content-type response header:
+	ContentType() string
+	// EncodeNames writes a list of directory or file names, element describes a single name (e.g. "directory"):
+	EncodeNames(w io.Writer, element string, names []string) error
+	// EncodePayments writes a list of payments:
+	EncodePayments(w io.Writer, payments []payment.Payment) error
+}
+
+// PaymentsStream writes payments one at a time, Close completes the document
+type PaymentsStream interface {
+	Write(p payment.Payment) error
+	Close() error
+}
+
+// StreamEncoder is implemented by encoders that can write payments without holding the whole list in memory
+type StreamEncoder interface {
+	Encoder
+	NewPaymentsStream(w io.Writer) PaymentsStream
+}
+
+// encodePayments is a helper that implements EncodePayments for stream encoders
+func encodePayments(enc StreamEncoder, w io.Writer, payments []payment.Payment) error {
+	stream := enc.NewPaymentsStream(w)
+	for _, p := range payments {
+		if err := stream.Write(p); err != nil {
+			return err
+		}
+	}
+	return stream.Close()
+}
+
+// encoderEntry is a registered format
+type encoderEntry struct {
+	format     string
+	mediaTypes []string
+	encoder    Encoder
+}
+
+var (
+	// encoders is the registry of supported formats, in order of preference.
+	// The first entry is used when clients accept any format:
+	encoders = []encoderEntry{
+		{"json", []string{"application/json"}, jsonEncoder{}},
+		{"ndjson", []string{"application/x-ndjson", "application/ndjson"}, ndjsonEncoder{}},
+		{"csv", []string{"text/csv"}, csvEncoder{}},
+		{"xml", []string{"application/xml", "text/xml"}, xmlEncoder{}},
+	}
+)
+
+const (
+	// formatParam overrides the Accept header:
+	formatParam = "format"
+	// csvDateLayout and csvTimeLayout match the date and time columns of payments files:
+	csvDateLayout = "20060102"
+	csvTimeLayout = "150405"
+)
+
+// errNotAcceptable is returned by negotiateEncoder when no registered format satisfies the Accept header
+var errNotAcceptable = fmt.Errorf("none of the accepted media types is supported")
+
+// negotiateEncoder picks an encoder using the ?format= parameter or the Accept header
+func negotiateEncoder(r *http.Request) (Encoder, error) {
+	if format := r.URL.Query().Get(formatParam); format != "" {
+		for _, entry := range encoders {
+			if entry.format == format {
+				return entry.encoder, nil
+			}
+		}
+		return nil, fmt.Errorf("unsupported format '%s'", format)
+	}
+	accept := r.Header.Get("accept")
+	if accept == "" {
+		return encoders[0].encoder, nil
+	}
+	// Pick the media range with the highest quality, ties are resolved by the order of the Accept header:
+	type mediaRange struct {
+		mediaType string
+		quality   float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		if quality <= 0 {
+			continue
+		}
+		ranges = append(ranges, mediaRange{mediaType, quality})
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].quality > ranges[j].quality
+	})
+	for _, mr := range ranges {
+		for _, entry := range encoders {
+			for _, mediaType := range entry.mediaTypes {
+				if mediaTypeMatches(mr.mediaType, mediaType) {
+					return entry.encoder, nil
+				}
+			}
+		}
+	}
+	return nil, errNotAcceptable
+}
+
+// negotiate is a helper that picks the encoder for a request, writing an error response when none is available
+func (h *Handler) negotiate(w http.ResponseWriter, r *http.Request) (Encoder, bool) {
+	w.Header().Add("vary", "Accept")
+	enc, err := negotiateEncoder(r)
+	switch {
+	case err == errNotAcceptable:
+		h.serveErrorCode(w, r, CodeNotAcceptable, err.Error(), supportedMediaTypes())
+		return nil, false
+	case err != nil:
+		h.serveBadRequest(w, r, err.Error())
+		return nil, false
+	}
+	return enc, true
+}
+
+// supportedMediaTypes lists the media types of all registered formats
+func supportedMediaTypes() []string {
+	var mediaTypes []string
+	for _, entry := range encoders {
+		mediaTypes = append(mediaTypes, entry.mediaTypes...)
+	}
+	return mediaTypes
+}
+
+// serveNames is a helper that returns HTTP 200 with a list of names in the given format
+func (h *Handler) serveNames(w http.ResponseWriter, r *http.Request, enc Encoder, element string, names []string) {
+	var buf bytes.Buffer
+	if err := enc.EncodeNames(&buf, element, names); err != nil {
+		h.serveError(w, r, err)
+		return
+	}
+	w.Header().Set("content-type", enc.ContentType())
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// servePayments is a helper that returns HTTP 200 with a list of payments in the given format
+func (h *Handler) servePayments(w http.ResponseWriter, r *http.Request, enc Encoder, payments []payment.Payment) {
+	var buf bytes.Buffer
+	if err := enc.EncodePayments(&buf, payments); err != nil {
+		h.serveError(w, r, err)
+		return
+	}
+	w.Header().Set("content-type", enc.ContentType())
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// mediaTypeMatches reports whether a media range like "text/*" matches a media type
+func mediaTypeMatches(mediaRange, mediaType string) bool {
+	if mediaRange == "*/*" || mediaRange == mediaType {
+		return true
+	}
+	if strings.HasSuffix(mediaRange, "/*") {
+		return strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*"))
+	}
+	return false
+}
+
+// jsonEncoder writes JSON arrays
+type jsonEncoder struct{}
+
+// jsonStream writes a JSON array element by element
+type jsonStream struct {
+	w       io.Writer
+	started bool
+}
+
+func (jsonEncoder) ContentType() string { return "application/json" }
+
+func (jsonEncoder) EncodeNames(w io.Writer, element string, names []string) error {
+	return json.NewEncoder(w).Encode(names)
+}
+
+func (enc jsonEncoder) EncodePayments(w io.Writer, payments []payment.Payment) error {
+	return encodePayments(enc, w, payments)
+}
+
+func (jsonEncoder) NewPaymentsStream(w io.Writer) PaymentsStream {
+	return &jsonStream{w: w}
+}
+
+func (s *jsonStream) Write(p payment.Payment) error {
+	rawJSON, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	separator := ","
+	if !s.started {
+		separator = "["
+		s.started = true
+	}
+	if _, err := io.WriteString(s.w, separator); err != nil {
+		return err
+	}
+	_, err = s.w.Write(rawJSON)
+	return err
+}
+
+func (s *jsonStream) Close() error {
+	if !s.started {
+		_, err := io.WriteString(s.w, "[]\n")
+		return err
+	}
+	_, err := io.WriteString(s.w, "]\n")
+	return err
+}
+
+// ndjsonEncoder writes one JSON value per line
+type ndjsonEncoder struct{}
+
+func (ndjsonEncoder) ContentType() string { return "application/x-ndjson" }
+
+func (ndjsonEncoder) EncodeNames(w io.Writer, element string, names []string) error {
+	enc := json.NewEncoder(w)
+	for _, name := range names {
+		if err := enc.Encode(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (enc ndjsonEncoder) EncodePayments(w io.Writer, payments []payment.Payment) error {
+	return encodePayments(enc, w, payments)
+}
+
+func (ndjsonEncoder) NewPaymentsStream(w io.Writer) PaymentsStream {
+	return ndjsonStream{json.NewEncoder(w)}
+}
+
+// ndjsonStream writes a JSON value per line
+type ndjsonStream struct {
+	enc *json.Encoder
+}
+
+func (s ndjsonStream) Write(p payment.Payment) error { return s.enc.Encode(p) }
+func (s ndjsonStream) Close() error                  { return nil }
+
+// csvEncoder writes CSV files, payments use the same format as payments files so they can be uploaded again
+type csvEncoder struct{}
+
+func (csvEncoder) ContentType() string { return "text/csv" }
+
+func (csvEncoder) EncodeNames(w io.Writer, element string, names []string) error {
+	csvWriter := csv.NewWriter(w)
+	csvWriter.Write([]string{element})
+	for _, name := range names {
+		csvWriter.Write([]string{name})
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+func (csvEncoder) EncodePayments(w io.Writer, payments []payment.Payment) error {
+	// Extra columns are the union of all the extra columns, in lexical order:
+	extraColumns := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, p := range payments {
+		for name := range p.Extra {
+			if !seen[name] {
+				seen[name] = true
+				extraColumns = append(extraColumns, name)
+			}
+		}
+	}
+	sort.Strings(extraColumns)
+	csvWriter := csv.NewWriter(w)
+	header := append([]string{"date", "time", "sequence", "amount", "currency", "comment"}, extraColumns...)
+	csvWriter.Write(header)
+	for _, p := range payments {
+		row := []string{p.AsOf.Format(csvDateLayout), p.AsOf.Format(csvTimeLayout), strconv.Itoa(p.Sequence), p.Amount.Decimal(), p.Amount.Currency, p.Comment}
+		for _, name := range extraColumns {
+			row = append(row, p.Extra[name])
+		}
+		csvWriter.Write(row)
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// xmlEncoder writes XML documents
+type xmlEncoder struct{}
+
+// xmlPayment is the XML representation of a payment
+type xmlPayment struct {
+	XMLName   xml.Name   `xml:"payment"`
+	AsOf      int        `xml:"asOf,attr"`
+	Timestamp string     `xml:"timestamp,attr"`
+	Sequence  int        `xml:"sequence,attr"`
+	Amount    xmlAmount  `xml:"amount"`
+	Comment   string     `xml:"comment,omitempty"`
+	Extra     []xmlExtra `xml:"extra"`
+}
+
+// xmlAmount is the XML representation of payment.Money
+type xmlAmount struct {
+	Currency   string `xml:"currency,attr"`
+	MinorUnits int64  `xml:"minorUnits,attr"`
+	Value      string `xml:",chardata"`
+}
+
+// xmlExtra is the XML representation of extra columns
+type xmlExtra struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:",chardata"`
+}
+
+func (xmlEncoder) ContentType() string { return "application/xml" }
+
+func (xmlEncoder) EncodeNames(w io.Writer, element string, names []string) error {
+	io.WriteString(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	// Lists use the plural form of their element, e.g. <directories><directory>...
+	plural := element + "s"
+	if strings.HasSuffix(element, "y") {
+		plural = strings.TrimSuffix(element, "y") + "ies"
+	}
+	list := xml.StartElement{Name: xml.Name{Local: plural}}
+	if err := enc.EncodeToken(list); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := enc.EncodeElement(name, xml.StartElement{Name: xml.Name{Local: element}}); err != nil {
+			return err
+		}
+	}
+	if err := enc.EncodeToken(list.End()); err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
+func (enc xmlEncoder) EncodePayments(w io.Writer, payments []payment.Payment) error {
+	return encodePayments(enc, w, payments)
+}
+
+func (xmlEncoder) NewPaymentsStream(w io.Writer) PaymentsStream {
+	return &xmlStream{w: w, enc: xml.NewEncoder(w)}
+}
+
+// xmlStream writes a <payments> document element by element
+type xmlStream struct {
+	w       io.Writer
+	enc     *xml.Encoder
+	started bool
+}
+
+// start is a helper that writes the XML header and opens the list
+func (s *xmlStream) start() error {
+	if s.started {
+		return nil
+	}
+	s.started = true
+	if _, err := io.WriteString(s.w, xml.Header); err != nil {
+		return err
+	}
+	return s.enc.EncodeToken(xml.StartElement{Name: xml.Name{Local: "payments"}})
+}
+
+func (s *xmlStream) Write(p payment.Payment) error {
+	if err := s.start(); err != nil {
+		return err
+	}
+	return s.enc.Encode(newXMLPayment(p))
+}
+
+func (s *xmlStream) Close() error {
+	if err := s.start(); err != nil {
+		return err
+	}
+	if err := s.enc.EncodeToken(xml.EndElement{Name: xml.Name{Local: "payments"}}); err != nil {
+		return err
+	}
+	return s.enc.Flush()
+}
+
+// newXMLPayment is a helper that converts a payment to its XML representation
+func newXMLPayment(p payment.Payment) xmlPayment {
+	xp := xmlPayment{
+		AsOf:      p.LegacyAsOf(),
+		Timestamp: p.AsOf.Format(time.RFC3339),
+		Sequence:  p.Sequence,
+		Amount: xmlAmount{
+			Currency:   p.Amount.Currency,
+			MinorUnits: p.Amount.Minor,
+			Value:      p.Amount.Decimal(),
+		},
+		Comment: p.Comment,
+	}
+	names := make([]string, 0, len(p.Extra))
+	for name := range p.Extra {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		xp.Extra = append(xp.Extra, xmlExtra{Name: name, Value: p.Extra[name]})
+	}
+	return xp
+}
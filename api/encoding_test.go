@@ -0,0 +1,186 @@
+package api
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/matiasinsaurralde/product-services/payment"
+)
+
+func TestNegotiateEncoder(t *testing.T) {
+	cases := []struct {
+		accept string
+		format string
+		want   Encoder
+		err    bool
+	}{
+		{"", "", jsonEncoder{}, false},
+		{"*/*", "", jsonEncoder{}, false},
+		{"text/csv", "", csvEncoder{}, false},
+		{"text/*", "", csvEncoder{}, false},
+		{"application/xml;q=0.5, application/x-ndjson", "", ndjsonEncoder{}, false},
+		{"text/html, text/xml;q=0.9", "", xmlEncoder{}, false},
+		{"text/csv;q=0", "", nil, true},
+		{"image/png", "", nil, true},
+		{"image/png", "csv", csvEncoder{}, false},
+		{"", "yaml", nil, true},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		if c.format != "" {
+			r = httptest.NewRequest("GET", "/?format="+c.format, nil)
+		}
+		if c.accept != "" {
+			r.Header.Set("accept", c.accept)
+		}
+		enc, err := negotiateEncoder(r)
+		if c.err {
+			if err == nil {
+				t.Fatalf("expected error for accept '%s' and format '%s'", c.accept, c.format)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if enc != c.want {
+			t.Fatalf("invalid encoder for accept '%s', got %T, expected %T", c.accept, enc, c.want)
+		}
+	}
+}
+
+func TestContentNegotiation(t *testing.T) {
+	store := payment.NewMemoryStore()
+	store.AddFile("20220717", "090000.payments", []byte(`date,time,sequence,amount,currency,comment,branch
+20220717,090000,211,500,USD,"payment, 2",north
+20220717,090000,212,600.5,EUR,payment3,`))
+	paymentsService := payment.NewWithStore(store)
+	ts := httptest.NewServer(NewHandlerWithService(paymentsService))
+	defer ts.Close()
+
+	get := func(t *testing.T, path, accept string) (*http.Response, []byte) {
+		req, err := http.NewRequest("GET", ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if accept != "" {
+			req.Header.Set("accept", accept)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res, body
+	}
+
+	t.Run("csv round trip", func(t *testing.T) {
+		res, body := get(t, "/20220717/090000.payments", "text/csv")
+		if res.StatusCode != 200 {
+			t.Fatalf("invalid status code, got %d, expected 200", res.StatusCode)
+		}
+		if contentType := res.Header.Get("content-type"); contentType != "text/csv" {
+			t.Fatalf("invalid content type, got '%s'", contentType)
+		}
+		// Files served as CSV can be uploaded again without changes:
+		expected, err := paymentsService.GetPayments("20220717/090000.payments")
+		if err != nil {
+			t.Fatal(err)
+		}
+		payments, _, err := payment.NewWithStore(payment.NewMemoryStore()).PutPayments("20220717", "090000.payments", body)
+		if err != nil {
+			t.Fatalf("couldn't parse CSV response: %s\n%s", err.Error(), body)
+		}
+		if len(payments) != len(expected) {
+			t.Fatalf("invalid number of payments, got %d, expected %d", len(payments), len(expected))
+		}
+		for i := range payments {
+			if !reflect.DeepEqual(payments[i], expected[i]) {
+				t.Fatalf("invalid payment, got %+v, expected %+v", payments[i], expected[i])
+			}
+		}
+	})
+
+	t.Run("ndjson", func(t *testing.T) {
+		res, body := get(t, "/20220717/090000.payments?format=ndjson", "")
+		if contentType := res.Header.Get("content-type"); contentType != "application/x-ndjson" {
+			t.Fatalf("invalid content type, got '%s'", contentType)
+		}
+		lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("invalid number of lines, got %d, expected 2", len(lines))
+		}
+		var p payment.Payment
+		if err := json.Unmarshal([]byte(lines[1]), &p); err != nil {
+			t.Fatal(err)
+		}
+		if p.Sequence != 212 {
+			t.Fatalf("invalid sequence, got %d, expected 212", p.Sequence)
+		}
+	})
+
+	t.Run("xml", func(t *testing.T) {
+		res, body := get(t, "/20220717/090000.payments", "application/xml")
+		if contentType := res.Header.Get("content-type"); contentType != "application/xml" {
+			t.Fatalf("invalid content type, got '%s'", contentType)
+		}
+		var doc struct {
+			Payments []xmlPayment `xml:"payment"`
+		}
+		if err := xml.Unmarshal(body, &doc); err != nil {
+			t.Fatal(err)
+		}
+		if len(doc.Payments) != 2 {
+			t.Fatalf("invalid number of payments, got %d, expected 2", len(doc.Payments))
+		}
+		amount := doc.Payments[1].Amount
+		if amount.Value != "600.50" || amount.Currency != "EUR" || amount.MinorUnits != 60050 {
+			t.Fatalf("invalid amount, got %+v", amount)
+		}
+	})
+
+	t.Run("listings", func(t *testing.T) {
+		_, body := get(t, "/?format=xml", "")
+		var dirs struct {
+			Names []string `xml:"directory"`
+		}
+		if err := xml.Unmarshal(body, &dirs); err != nil {
+			t.Fatal(err)
+		}
+		if len(dirs.Names) != 1 || dirs.Names[0] != "20220717" {
+			t.Fatalf("invalid directories, got %v", dirs.Names)
+		}
+		_, body = get(t, "/20220717", "text/csv")
+		if string(body) != "file\n090000.payments\n" {
+			t.Fatalf("invalid CSV listing, got '%s'", body)
+		}
+	})
+
+	t.Run("not acceptable", func(t *testing.T) {
+		res, body := get(t, "/20220717", "image/png")
+		if res.StatusCode != 406 {
+			t.Fatalf("invalid status code, got %d, expected 406", res.StatusCode)
+		}
+		var errResponse ErrorResponse
+		if err := json.Unmarshal(body, &errResponse); err != nil {
+			t.Fatal(err)
+		}
+		if errResponse.Code != CodeNotAcceptable {
+			t.Fatalf("invalid error code, got '%s'", errResponse.Code)
+		}
+		res, _ = get(t, "/20220717?format=yaml", "")
+		if res.StatusCode != 400 {
+			t.Fatalf("invalid status code, got %d, expected 400", res.StatusCode)
+		}
+	})
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+
+	"github.com/matiasinsaurralde/product-services/payment"
+)
+
+// ErrorCode is a stable, machine readable identifier included in every error response
+type ErrorCode string
+
+const (
+	// CodeInvalidName is used when a directory or file name doesn't follow the YYYYMMDD/HHMMSS.payments layout:
+	CodeInvalidName ErrorCode = "invalid_name"
+	// CodeInvalidRequest is used for invalid query parameters or request bodies:
+	CodeInvalidRequest ErrorCode = "invalid_request"
+	// CodeUnauthorized is used when a request doesn't carry valid credentials:
+	CodeUnauthorized ErrorCode = "unauthorized"
+	// CodeForbidden is used when the identity of a request isn't allowed to access a route or directory:
+	CodeForbidden ErrorCode = "forbidden"
+	// CodeNotFound is used for unknown routes and missing directories or files:
+	CodeNotFound ErrorCode = "not_found"
+	// CodeMethodNotAllowed is used when a route doesn't support the request method:
+	CodeMethodNotAllowed ErrorCode = "method_not_allowed"
+	// CodeNotAcceptable is used when none of the formats in the Accept header is supported:
+	CodeNotAcceptable ErrorCode = "not_acceptable"
+	// CodeReadOnly is used for uploads when the data store doesn't accept new files:
+	CodeReadOnly ErrorCode = "read_only"
+	// CodeUnparsableFile is used when a payments file is rejected, the row-level report is included in details:
+	CodeUnparsableFile ErrorCode = "unparsable_file"
+	// CodeUnavailable is used when a request can't be served for the time being:
+	CodeUnavailable ErrorCode = "unavailable"
+	// CodeInternal is used for all other errors:
+	CodeInternal ErrorCode = "internal_error"
+)
+
+// ErrorCodes is the catalogue of error codes and their HTTP status codes
+var ErrorCodes = map[ErrorCode]int{
+	CodeInvalidName:      http.StatusBadRequest,
+	CodeInvalidRequest:   http.StatusBadRequest,
+	CodeUnauthorized:     http.StatusUnauthorized,
+	CodeForbidden:        http.StatusForbidden,
+	CodeNotFound:         http.StatusNotFound,
+	CodeMethodNotAllowed: http.StatusMethodNotAllowed,
+	CodeNotAcceptable:    http.StatusNotAcceptable,
+	CodeReadOnly:         http.StatusMethodNotAllowed,
+	CodeUnparsableFile:   http.StatusUnprocessableEntity,
+	CodeUnavailable:      http.StatusServiceUnavailable,
+	CodeInternal:         http.StatusInternalServerError,
+}
+
+const (
+	// requestIDHeader is used to read and return request IDs:
+	requestIDHeader = "X-Request-Id"
+	// maxRequestIDLength limits the size of client provided request IDs:
+	maxRequestIDLength = 128
+)
+
+// ErrorResponse is the JSON envelope used by all error responses
+type ErrorResponse struct {
+	Code    ErrorCode   `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+	// RequestID matches the X-Request-Id response header:
+	RequestID string `json:"requestId"`
+}
+
+// requestIDKey is the context key used to store request IDs
+type requestIDKey struct{}
+
+// withRequestID is a helper that reuses a valid client provided request ID or generates a new one,
+// the ID is stored in the request context and returned in the X-Request-Id header.
+// Requests that already went through withRequestID are returned as they are.
+func withRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	if requestIDFromContext(r.Context()) != "" {
+		return r
+	}
+	requestID := r.Header.Get(requestIDHeader)
+	if !validRequestID(requestID) {
+		requestID = newRequestID()
+	}
+	w.Header().Set(requestIDHeader, requestID)
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey{}, requestID))
+}
+
+// requestIDFromContext returns the request ID stored by withRequestID
+func requestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// validRequestID only accepts short IDs made of printable ASCII characters
+func validRequestID(s string) bool {
+	if s == "" || len(s) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x21 || s[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID generates a random request ID
+func newRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		log.Printf("error: %s\n", err.Error())
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// serveErrorCode is a helper that writes the error envelope with the HTTP status of a given code
+func (h *Handler) serveErrorCode(w http.ResponseWriter, r *http.Request, code ErrorCode, message string, details interface{}) {
+	status, ok := ErrorCodes[code]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	response := ErrorResponse{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: requestIDFromContext(r.Context()),
+	}
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("error: %s\n", err.Error())
+		responseJSON = []byte(`{"code":"internal_error","message":"server error"}`)
+		status = http.StatusInternalServerError
+	}
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+	w.Write(responseJSON)
+}
+
+// serveNotFound is a helper that returns HTTP 404
+func (h *Handler) serveNotFound(w http.ResponseWriter, r *http.Request) {
+	h.serveErrorCode(w, r, CodeNotFound, "not found", nil)
+}
+
+// serveBadRequest is a helper that returns HTTP 400 with a message
+func (h *Handler) serveBadRequest(w http.ResponseWriter, r *http.Request, msg string) {
+	h.serveErrorCode(w, r, CodeInvalidRequest, msg, nil)
+}
+
+// serveMethodNotAllowed is a helper that returns HTTP 405
+func (h *Handler) serveMethodNotAllowed(w http.ResponseWriter, r *http.Request, allowed string) {
+	w.Header().Set("allow", allowed)
+	h.serveErrorCode(w, r, CodeMethodNotAllowed, "method not allowed", nil)
+}
+
+// serveError is a helper that returns HTTP 500, the actual error is only logged
+func (h *Handler) serveError(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("error: %s: %s\n", requestIDFromContext(r.Context()), err.Error())
+	h.serveErrorCode(w, r, CodeInternal, "server error", nil)
+}
+
+// serveServiceError is a helper that maps errors returned by the payments service to error responses
+func (h *Handler) serveServiceError(w http.ResponseWriter, r *http.Request, err error) {
+	var parseErr *payment.ParseError
+	switch {
+	case errors.Is(err, payment.ErrInvalidName):
+		h.serveErrorCode(w, r, CodeInvalidName, err.Error(), nil)
+	case errors.Is(err, payment.ErrOutsideBaseDir):
+		// Escapes are reported as missing files so they don't reveal anything about the filesystem:
+		log.Printf("error: %s: %s\n", requestIDFromContext(r.Context()), err.Error())
+		h.serveNotFound(w, r)
+	case errors.Is(err, fs.ErrNotExist):
+		h.serveNotFound(w, r)
+	case errors.As(err, &parseErr):
+		h.serveErrorCode(w, r, CodeUnparsableFile, "invalid payments file", parseErr.Rows)
+	case errors.Is(err, payment.ErrReadOnlyStore):
+		h.serveErrorCode(w, r, CodeReadOnly, err.Error(), nil)
+	default:
+		h.serveError(w, r, err)
+	}
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/matiasinsaurralde/product-services/payment"
+)
+
+// TestErrorResponses covers the error envelope and the mapping of service errors to status codes
+func TestErrorResponses(t *testing.T) {
+	store := payment.NewMemoryStore()
+	store.AddFile("20220717", "090000.payments", []byte(testRawData["20220717/090000.payments"]))
+	store.AddFile("20220717", "100000.payments", []byte("date,time\n20220717,100000"))
+	h := NewHandlerWithService(payment.NewWithStore(readOnlyStore{store}))
+	defer h.Close()
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	testCases := []struct {
+		method, path string
+		code         ErrorCode
+	}{
+		{http.MethodGet, "/xyz/", CodeInvalidName},
+		{http.MethodGet, "/20220717/xyz.payments", CodeInvalidName},
+		{http.MethodGet, "/20220101/", CodeNotFound},
+		{http.MethodGet, "/20220717/235959.payments", CodeNotFound},
+		{http.MethodGet, "/20220717/090000.payments/xyz", CodeNotFound},
+		{http.MethodGet, "/20220717/100000.payments", CodeUnparsableFile},
+		{http.MethodGet, "/payments?from=xyz", CodeInvalidRequest},
+		{http.MethodPost, "/20220717/090000.payments", CodeMethodNotAllowed},
+		{http.MethodPut, "/20220717/110000.payments", CodeReadOnly},
+	}
+	for _, tc := range testCases {
+		req, err := http.NewRequest(tc.method, ts.URL+tc.path, strings.NewReader(testRawData["20220717/090000.payments"]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var response ErrorResponse
+		err = json.NewDecoder(res.Body).Decode(&response)
+		res.Body.Close()
+		if err != nil {
+			t.Fatalf("invalid error response for %s %s: %s", tc.method, tc.path, err.Error())
+		}
+		if res.StatusCode != ErrorCodes[tc.code] || response.Code != tc.code {
+			t.Fatalf("unexpected error response for %s %s: %d %+v, expected %d %s", tc.method, tc.path, res.StatusCode, response, ErrorCodes[tc.code], tc.code)
+		}
+		if response.Message == "" {
+			t.Fatalf("missing error message for %s %s", tc.method, tc.path)
+		}
+		if response.RequestID == "" || response.RequestID != res.Header.Get(requestIDHeader) {
+			t.Fatalf("invalid request ID for %s %s: '%s'", tc.method, tc.path, response.RequestID)
+		}
+		if tc.code == CodeUnparsableFile {
+			rows, ok := response.Details.([]interface{})
+			if !ok || len(rows) != 2 {
+				t.Fatalf("unexpected error details %v", response.Details)
+			}
+		}
+	}
+
+	t.Run("client provided request ID", func(t *testing.T) {
+		for requestID, valid := range map[string]bool{"abc-123": true, "with spaces": false, strings.Repeat("a", 200): false} {
+			req, err := http.NewRequest(http.MethodGet, ts.URL+"/xyz/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set(requestIDHeader, requestID)
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if (res.Header.Get(requestIDHeader) == requestID) != valid {
+				t.Fatalf("unexpected request ID '%s' for '%s'", res.Header.Get(requestIDHeader), requestID)
+			}
+		}
+	})
+}
+
+// readOnlyStore hides the WriteFile method of a payment.Store
+type readOnlyStore struct {
+	payment.Store
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// defaultWatchInterval is used to poll the data directory when inotify isn't available:
+	defaultWatchInterval = 2 * time.Second
+	// eventsKeepAlive is the interval between comments sent to keep idle event streams open:
+	eventsKeepAlive = 15 * time.Second
+	// eventsRetry tells clients how long to wait before reconnecting, in milliseconds:
+	eventsRetry = 2000
+)
+
+// serveEvents streams new directories and payments files using Server-Sent Events,
+// every event carries the JSON representation of a payment.Event
+func (h *Handler) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		h.serveError(w, r, errors.New("streaming isn't supported by the response writer"))
+		return
+	}
+	// Streams stay open until the client goes away:
+	disableWriteTimeout(w)
+	h.watcherStart.Do(h.watcher.Start)
+	events, cancel := h.watcher.Subscribe()
+	defer cancel()
+	permissions := h.permissions(r)
+
+	w.Header().Set("content-type", "text/event-stream")
+	w.Header().Set("cache-control", "no-cache")
+	w.WriteHeader(200)
+	fmt.Fprintf(w, "retry: %d\n\n", eventsRetry)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-h.closed:
+			// Clients reconnect after eventsRetry, possibly to another instance:
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case event := <-events:
+			// Authenticated clients only receive events for the directories they can access:
+			if permissions != nil && len(permissions.filterDirectories([]string{event.Path})) == 0 {
+				continue
+			}
+			eventJSON, err := json.Marshal(event)
+			if err != nil {
+				log.Printf("error: %s\n", err.Error())
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, eventJSON); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matiasinsaurralde/product-services/payment"
+)
+
+// TestEvents covers the Server-Sent Events stream
+func TestEvents(t *testing.T) {
+	store := payment.NewMemoryStore()
+	store.AddFile("20220717", "090000.payments", []byte(testRawData["20220717/090000.payments"]))
+	paymentsService := payment.NewWithStore(store)
+	h := NewHandlerWithService(paymentsService)
+	h.watcher = payment.NewWatcher(paymentsService, 10*time.Millisecond)
+	defer h.Close()
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		t.Fatalf("invalid status code, got %d, expected %d", res.StatusCode, 200)
+	}
+	if contentType := res.Header.Get("content-type"); contentType != "text/event-stream" {
+		t.Fatalf("invalid content type, got '%s'", contentType)
+	}
+	// The watcher is started by the request, so new files are added once the stream is open:
+	store.AddFile("20220718", "010101.payments", []byte(testRawData["20220718/010101.payments"]))
+
+	// Read events until the payments event shows up:
+	lines := make(chan string)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-done:
+				return
+			}
+		}
+	}()
+	expectedEvents := []payment.EventType{payment.EventDirectory, payment.EventPayments}
+	var eventType string
+	for len(expectedEvents) > 0 {
+		var line string
+		select {
+		case l, ok := <-lines:
+			if !ok {
+				t.Fatal("event stream closed")
+			}
+			line = l
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for events")
+		}
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			eventType = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			var event payment.Event
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+				t.Fatal(err)
+			}
+			if eventType != string(expectedEvents[0]) || event.Type != expectedEvents[0] {
+				t.Fatalf("unexpected %s event %+v, expected %s", eventType, event, expectedEvents[0])
+			}
+			if event.Type == payment.EventPayments {
+				if event.Path != "20220718/010101.payments" || len(event.Payments) != 2 {
+					t.Fatalf("unexpected payments event %+v", event)
+				}
+			}
+			expectedEvents = expectedEvents[1:]
+		}
+	}
+}
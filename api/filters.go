@@ -0,0 +1,89 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/matiasinsaurralde/product-services/payment"
+)
+
+// Query parameters used to filter and sort payments:
+const (
+	minAmountParam    = "minAmount"
+	maxAmountParam    = "maxAmount"
+	sequenceFromParam = "sequenceFrom"
+	sequenceToParam   = "sequenceTo"
+	// commentParam is written as ?comment~=text, values like /pattern/ are regular expressions:
+	commentParam = "comment~"
+	sortParam    = "sort"
+)
+
+// parsePaymentQuery is a helper that reads the filter and sort parameters of a request,
+// the filter is nil when no filter parameter is set.
+func parsePaymentQuery(r *http.Request) (*payment.Filter, []payment.SortKey, error) {
+	query := r.URL.Query()
+	filter := &payment.Filter{}
+	filtered := false
+	for _, bound := range []struct {
+		param string
+		dst   **payment.DecimalAmount
+	}{
+		{minAmountParam, &filter.MinAmount},
+		{maxAmountParam, &filter.MaxAmount},
+	} {
+		s := query.Get(bound.param)
+		if s == "" {
+			continue
+		}
+		amount, err := payment.ParseDecimalAmount(s)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid %s: %s", bound.param, err.Error())
+		}
+		*bound.dst = &amount
+		filtered = true
+	}
+	for _, bound := range []struct {
+		param string
+		dst   **int
+	}{
+		{sequenceFromParam, &filter.SequenceFrom},
+		{sequenceToParam, &filter.SequenceTo},
+	} {
+		s := query.Get(bound.param)
+		if s == "" {
+			continue
+		}
+		sequence, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid %s '%s'", bound.param, s)
+		}
+		*bound.dst = &sequence
+		filtered = true
+	}
+	if s := query.Get(commentParam); s != "" {
+		if len(s) >= 2 && strings.HasPrefix(s, "/") && strings.HasSuffix(s, "/") {
+			pattern, err := regexp.Compile(s[1 : len(s)-1])
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid %s pattern: %s", commentParam, err.Error())
+			}
+			filter.CommentPattern = pattern
+		} else {
+			filter.Comment = s
+		}
+		filtered = true
+	}
+	var sortKeys []payment.SortKey
+	if s := query.Get(sortParam); s != "" {
+		var err error
+		if sortKeys, err = payment.ParseSort(s); err != nil {
+			return nil, nil, err
+		}
+	}
+	if !filtered {
+		filter = nil
+	}
+	return filter, sortKeys, nil
+}
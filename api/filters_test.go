@@ -0,0 +1,115 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/matiasinsaurralde/product-services/payment"
+)
+
+func TestPaymentFilters(t *testing.T) {
+	store := payment.NewMemoryStore()
+	store.AddFile("20220717", "090000.payments", []byte(`date,time,sequence,amount,currency,comment
+20220717,090000,1,10.50,USD,rent
+20220717,090000,2,10.500,KWD,groceries
+20220717,090000,3,11,JPY,rent deposit
+20220717,090000,4,-2,EUR,refund`))
+	store.AddFile("20220718", "090000.payments", []byte(`date,time,sequence,amount,comment
+20220718,090000,5,100,rent
+20220718,090000,6,1,coffee`))
+	ts := httptest.NewServer(NewHandlerWithService(payment.NewWithStore(store)))
+	defer ts.Close()
+
+	// sequences is a helper that returns the sequence numbers of a response:
+	sequences := func(t *testing.T, path string, params url.Values) []int {
+		res, err := http.Get(ts.URL + path + "?" + params.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != 200 {
+			t.Fatalf("invalid status code for %s, got %d, expected 200", params.Encode(), res.StatusCode)
+		}
+		var payments []payment.Payment
+		if err := json.NewDecoder(res.Body).Decode(&payments); err != nil {
+			t.Fatal(err)
+		}
+		var s []int
+		for _, p := range payments {
+			s = append(s, p.Sequence)
+		}
+		return s
+	}
+	expectSequences := func(t *testing.T, got []int, expected ...int) {
+		if len(got) != len(expected) {
+			t.Fatalf("invalid sequences, got %v, expected %v", got, expected)
+		}
+		for i := range got {
+			if got[i] != expected[i] {
+				t.Fatalf("invalid sequences, got %v, expected %v", got, expected)
+			}
+		}
+	}
+	const filePath = "/20220717/090000.payments"
+
+	t.Run("amount", func(t *testing.T) {
+		got := sequences(t, filePath, url.Values{"minAmount": {"10.5"}, "maxAmount": {"10.999"}})
+		expectSequences(t, got, 1, 2)
+	})
+	t.Run("sequence", func(t *testing.T) {
+		got := sequences(t, filePath, url.Values{"sequenceFrom": {"2"}, "sequenceTo": {"3"}})
+		expectSequences(t, got, 2, 3)
+	})
+	t.Run("comment", func(t *testing.T) {
+		expectSequences(t, sequences(t, filePath, url.Values{"comment~": {"rent"}}), 1, 3)
+		expectSequences(t, sequences(t, filePath, url.Values{"comment~": {"/^r.*t$/"}}), 1, 3)
+		expectSequences(t, sequences(t, filePath, url.Values{"comment~": {"nothing"}}))
+	})
+	t.Run("sort", func(t *testing.T) {
+		expectSequences(t, sequences(t, filePath, url.Values{"sort": {"amount,-sequence"}}), 4, 2, 1, 3)
+	})
+	t.Run("sorted pages", func(t *testing.T) {
+		pages, total := fetchPages(t, ts.URL, filePath+"?sort=-amount&limit=3", func() interface{} { return &[]payment.Payment{} })
+		if len(pages) != 2 || total != "4" {
+			t.Fatalf("invalid pagination, got %d pages and total '%s'", len(pages), total)
+		}
+		var got []int
+		for _, page := range pages {
+			for _, p := range *page.(*[]payment.Payment) {
+				got = append(got, p.Sequence)
+			}
+		}
+		expectSequences(t, got, 3, 1, 2, 4)
+	})
+	t.Run("range query", func(t *testing.T) {
+		params := url.Values{
+			"from":      {"20220717000000"},
+			"to":        {"20220718235959"},
+			"comment~":  {"rent"},
+			"minAmount": {"10"},
+			"sort":      {"-amount"},
+		}
+		expectSequences(t, sequences(t, "/payments", params), 5, 3, 1)
+	})
+	t.Run("invalid parameters", func(t *testing.T) {
+		for _, params := range []url.Values{
+			{"minAmount": {"abc"}},
+			{"maxAmount": {"1.2345"}},
+			{"sequenceFrom": {"x"}},
+			{"comment~": {"/(/"}},
+			{"sort": {"date"}},
+		} {
+			res, err := http.Get(ts.URL + filePath + "?" + params.Encode())
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != 400 {
+				t.Fatalf("invalid status code for %s, got %d, expected 400", params.Encode(), res.StatusCode)
+			}
+		}
+	})
+}
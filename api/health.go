@@ -0,0 +1,28 @@
+package api
+
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	// healthPath reports whether the process is alive:
+	healthPath = "healthz"
+	// readyPath reports whether the data directory can be served, see payment.CheckReadiness:
+	readyPath = "readyz"
+)
+
+// serveHealth handles /healthz, it only reports that the process is able to answer requests
+func (h *Handler) serveHealth(w http.ResponseWriter, r *http.Request) {
+	h.serveJSON(w, r, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// serveReady handles /readyz, it returns HTTP 503 when the data directory can't be read or its data is stale
+func (h *Handler) serveReady(w http.ResponseWriter, r *http.Request) {
+	readiness := h.paymentsService.CheckReadiness(time.Now(), h.StaleAfter)
+	status := http.StatusOK
+	if !readiness.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	h.serveJSON(w, r, status, readiness)
+}
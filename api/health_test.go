@@ -0,0 +1,71 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/matiasinsaurralde/product-services/payment"
+)
+
+func TestHealth(t *testing.T) {
+	paymentsService := testPaymentsService()
+	// Readiness depends on modification times, the newest file arrived two hours ago:
+	store := paymentsService.Store.(*payment.MemoryStore)
+	for fullPath := range testRawData {
+		modTime := time.Now().Add(-3 * time.Hour)
+		if fullPath == "20220718/010101.payments" {
+			modTime = time.Now().Add(-2 * time.Hour)
+		}
+		if err := store.SetModTime(filepath.Dir(fullPath), filepath.Base(fullPath), modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler := NewHandlerWithService(paymentsService)
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	t.Run("healthz", func(t *testing.T) {
+		res, err := http.Get(ts.URL + "/healthz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != 200 {
+			t.Fatalf("invalid status code, got %d, expected %d", res.StatusCode, 200)
+		}
+	})
+
+	cases := []struct {
+		name       string
+		staleAfter time.Duration
+		statusCode int
+	}{
+		{"without staleness window", 0, 200},
+		{"fresh data", 3 * time.Hour, 200},
+		{"stale data", time.Hour, 503},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			handler.StaleAfter = c.staleAfter
+			res, err := http.Get(ts.URL + "/readyz")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			if res.StatusCode != c.statusCode {
+				t.Fatalf("invalid status code, got %d, expected %d", res.StatusCode, c.statusCode)
+			}
+			var readiness payment.Readiness
+			if err := json.NewDecoder(res.Body).Decode(&readiness); err != nil {
+				t.Fatal(err)
+			}
+			if readiness.NewestFile != "20220718/010101.payments" {
+				t.Fatalf("invalid newest file, got '%s', expected '%s'", readiness.NewestFile, "20220718/010101.payments")
+			}
+		})
+	}
+}
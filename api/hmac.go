@@ -0,0 +1,358 @@
+package api
+
+import (
+	"bytes"
+	"container/heap"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// AuthHMAC is the authentication method of signed requests:
+	AuthHMAC = "hmac"
+	// hmacScheme is the authorization scheme and challenge of signed requests:
+	hmacScheme = "HMAC"
+	// DefaultMaxSkew is the clock difference tolerated between clients and the server:
+	DefaultMaxSkew = 5 * time.Minute
+	// maxNonceLength limits the size of nonces kept by the replay cache:
+	maxNonceLength = 128
+	// DefaultMaxNonces bounds the number of nonces kept by the replay cache:
+	DefaultMaxNonces = 100000
+	// contentHashHeader carries the hex encoded SHA-256 hash of the body of signed uploads:
+	contentHashHeader = "X-Content-Sha256"
+)
+
+// emptyBodyHash is the hash signed for requests without a body
+var emptyBodyHash = func() string {
+	sum := sha256.Sum256(nil)
+	return hex.EncodeToString(sum[:])
+}()
+
+// errBodyHash is returned while reading the body of a signed upload that doesn't match the signed hash
+var errBodyHash = errors.New("the request body doesn't match the signed hash")
+
+// HMACKey is an entry of the HMAC keys file, the secret is shared with the client
+type HMACKey struct {
+	// ID is sent by clients in the keyId parameter:
+	ID          string `json:"id" yaml:"id"`
+	Secret      string `json:"secret" yaml:"secret"`
+	Permissions `yaml:",inline"`
+}
+
+// hmacKeysFile is the layout of the HMAC keys file
+type hmacKeysFile struct {
+	Keys []HMACKey `json:"keys" yaml:"keys"`
+}
+
+// HMACAuth authenticates requests signed with the secrets of an HMAC keys file.
+// Clients send "Authorization: HMAC keyId=<id>,timestamp=<unix seconds>,nonce=<random>,signature=<hex>",
+// see SignRequest for the signed string.
+type HMACAuth struct {
+	// MaxSkew is the clock difference tolerated between clients and the server, DefaultMaxSkew when zero:
+	MaxSkew time.Duration
+	// MaxNonces bounds the replay cache, signed requests are rejected with HTTP 503 while it's full,
+	// DefaultMaxNonces when zero:
+	MaxNonces int
+	keys      map[string]*HMACKey
+	// now is replaced by tests:
+	now func() time.Time
+
+	mu sync.Mutex
+	// nonces maps the nonces of accepted requests to the time they can be forgotten,
+	// expiries holds the same nonces ordered by that time:
+	nonces   map[string]time.Time
+	expiries nonceHeap
+}
+
+// nonceEntry is a nonce of the replay cache with the time it can be forgotten
+type nonceEntry struct {
+	nonce  string
+	expiry time.Time
+}
+
+// nonceHeap orders nonces by expiry, it satisfies heap.Interface
+type nonceHeap []nonceEntry
+
+func (h nonceHeap) Len() int            { return len(h) }
+func (h nonceHeap) Less(i, j int) bool  { return h[i].expiry.Before(h[j].expiry) }
+func (h nonceHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *nonceHeap) Push(x interface{}) { *h = append(*h, x.(nonceEntry)) }
+func (h *nonceHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
+
+// LoadHMACKeys reads an HMAC keys file, files ending with .json are read as JSON and everything else as YAML
+func LoadHMACKeys(path string) (*HMACAuth, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read HMAC keys file: %w", err)
+	}
+	var file hmacKeysFile
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&file)
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(&file)
+		if err == io.EOF {
+			err = nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid HMAC keys file '%s': %s", path, err.Error())
+	}
+	auth, err := NewHMACAuth(file.Keys)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HMAC keys file '%s': %s", path, err.Error())
+	}
+	return auth, nil
+}
+
+// NewHMACAuth validates a list of keys and returns their authenticator
+func NewHMACAuth(keys []HMACKey) (*HMACAuth, error) {
+	a := &HMACAuth{
+		keys:   make(map[string]*HMACKey, len(keys)),
+		now:    time.Now,
+		nonces: make(map[string]time.Time),
+	}
+	for i := range keys {
+		key := &keys[i]
+		if key.ID == "" {
+			return nil, fmt.Errorf("key %d: missing id", i+1)
+		}
+		if _, ok := a.keys[key.ID]; ok {
+			return nil, fmt.Errorf("key '%s': duplicate id", key.ID)
+		}
+		if len(key.Secret) < 16 {
+			return nil, fmt.Errorf("key '%s': the secret must have at least 16 characters", key.ID)
+		}
+		if err := key.Permissions.Validate(); err != nil {
+			return nil, fmt.Errorf("key '%s': %s", key.ID, err.Error())
+		}
+		a.keys[key.ID] = key
+	}
+	return a, nil
+}
+
+// hmacParams holds the parameters of an HMAC authorization header
+type hmacParams struct {
+	keyID, nonce, signature string
+	timestamp               int64
+}
+
+// parseHMACAuthorization is a helper that reads the parameters of an HMAC authorization header,
+// ErrNoCredentials is returned for other schemes
+func parseHMACAuthorization(header string) (*hmacParams, error) {
+	fields := strings.SplitN(strings.TrimSpace(header), " ", 2)
+	if len(fields) != 2 || !strings.EqualFold(fields[0], hmacScheme) {
+		return nil, ErrNoCredentials
+	}
+	params := &hmacParams{}
+	var timestamp string
+	for _, param := range strings.Split(fields[1], ",") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid HMAC parameter '%s'", param)
+		}
+		value := strings.Trim(kv[1], `"`)
+		switch kv[0] {
+		case "keyId":
+			params.keyID = value
+		case "timestamp":
+			timestamp = value
+		case "nonce":
+			params.nonce = value
+		case "signature":
+			params.signature = value
+		default:
+			return nil, fmt.Errorf("unknown HMAC parameter '%s'", kv[0])
+		}
+	}
+	if params.keyID == "" || timestamp == "" || params.nonce == "" || params.signature == "" {
+		return nil, errors.New("HMAC authorization requires keyId, timestamp, nonce and signature")
+	}
+	if len(params.nonce) > maxNonceLength {
+		return nil, errors.New("HMAC nonce is too long")
+	}
+	var err error
+	if params.timestamp, err = strconv.ParseInt(timestamp, 10, 64); err != nil {
+		return nil, fmt.Errorf("invalid HMAC timestamp '%s', expected Unix seconds", timestamp)
+	}
+	return params, nil
+}
+
+// Authenticate satisfies Authenticator. The signature covers the body hash sent in the X-Content-Sha256 header,
+// so it's checked without reading the body. The body is checked against the hash while the handler reads it,
+// and only uploads can have a body.
+func (a *HMACAuth) Authenticate(r *http.Request) (*Identity, error) {
+	params, err := parseHMACAuthorization(r.Header.Get("authorization"))
+	if err != nil {
+		return nil, err
+	}
+	key, ok := a.keys[params.keyID]
+	if !ok {
+		return nil, errors.New("invalid HMAC signature")
+	}
+	maxSkew := a.MaxSkew
+	if maxSkew <= 0 {
+		maxSkew = DefaultMaxSkew
+	}
+	now := a.now()
+	signedAt := time.Unix(params.timestamp, 0)
+	if signedAt.Before(now.Add(-maxSkew)) || signedAt.After(now.Add(maxSkew)) {
+		return nil, fmt.Errorf("HMAC timestamp is outside the allowed clock skew of %s", maxSkew)
+	}
+	bodyHash, err := signedBodyHash(r)
+	if err != nil {
+		return nil, err
+	}
+	expected := signature(key.Secret, stringToSign(r, params.timestamp, params.nonce, bodyHash))
+	provided, err := hex.DecodeString(params.signature)
+	if err != nil || !hmac.Equal(provided, expected) {
+		return nil, errors.New("invalid HMAC signature")
+	}
+	// Nonces are only remembered for valid signatures, and only as long as their timestamp is accepted:
+	if err := a.useNonce(key.ID+" "+params.nonce, signedAt.Add(maxSkew), now); err != nil {
+		return nil, err
+	}
+	// Uploads signed without a body are checked too, so they can't carry one:
+	if r.Method == http.MethodPut {
+		expectedHash, _ := hex.DecodeString(bodyHash)
+		r.Body = &hashVerifier{ReadCloser: r.Body, hash: sha256.New(), expected: expectedHash}
+	}
+	permissions := key.Permissions
+	return &Identity{Name: key.ID, Method: AuthHMAC, Permissions: &permissions}, nil
+}
+
+// signedBodyHash is a helper that returns the body hash of a signed request, without reading the body
+func signedBodyHash(r *http.Request) (string, error) {
+	bodyHash := strings.ToLower(r.Header.Get(contentHashHeader))
+	if r.Method != http.MethodPut {
+		if r.ContentLength != 0 || (bodyHash != "" && bodyHash != emptyBodyHash) {
+			return "", errors.New("only uploads can have a request body")
+		}
+		return emptyBodyHash, nil
+	}
+	if b, err := hex.DecodeString(bodyHash); err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("signed uploads require the hex encoded SHA-256 hash of the body in the %s header", contentHashHeader)
+	}
+	return bodyHash, nil
+}
+
+// hashVerifier hashes a request body while it's read and fails at the end of the body when the hash doesn't match
+type hashVerifier struct {
+	io.ReadCloser
+	hash     hash.Hash
+	expected []byte
+}
+
+func (v *hashVerifier) Read(p []byte) (int, error) {
+	n, err := v.ReadCloser.Read(p)
+	v.hash.Write(p[:n])
+	if err == io.EOF && !hmac.Equal(v.hash.Sum(nil), v.expected) {
+		return n, errBodyHash
+	}
+	return n, err
+}
+
+// Challenge satisfies Authenticator
+func (a *HMACAuth) Challenge() string {
+	return hmacScheme
+}
+
+// useNonce is a helper that records a nonce until a given time, expired nonces are forgotten first.
+// An error is returned when the nonce was already used, or wrapping ErrAuthUnavailable when the cache is full.
+func (a *HMACAuth) useNonce(nonce string, until, now time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for len(a.expiries) > 0 && !a.expiries[0].expiry.After(now) {
+		entry := heap.Pop(&a.expiries).(nonceEntry)
+		delete(a.nonces, entry.nonce)
+	}
+	if _, ok := a.nonces[nonce]; ok {
+		return errors.New("HMAC nonce was already used")
+	}
+	maxNonces := a.MaxNonces
+	if maxNonces <= 0 {
+		maxNonces = DefaultMaxNonces
+	}
+	if len(a.nonces) >= maxNonces {
+		return fmt.Errorf("%w: too many signed requests, the replay cache is full", ErrAuthUnavailable)
+	}
+	a.nonces[nonce] = until
+	heap.Push(&a.expiries, nonceEntry{nonce: nonce, expiry: until})
+	return nil
+}
+
+// hashBody is a helper that returns the hex encoded SHA-256 hash of the body of a client request,
+// the body is replaced so it can be sent
+func hashBody(r *http.Request) (string, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return emptyBodyHash, nil
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return "", fmt.Errorf("couldn't read request body: %w", err)
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(data))
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// stringToSign is a helper that builds the string signed by clients: the method, escaped path, sorted query,
+// timestamp, nonce and body hash, separated by newlines
+func stringToSign(r *http.Request, timestamp int64, nonce, bodyHash string) string {
+	return strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		r.URL.Query().Encode(),
+		strconv.FormatInt(timestamp, 10),
+		nonce,
+		bodyHash,
+	}, "\n")
+}
+
+// signature is a helper that computes the HMAC-SHA256 of a string
+func signature(secret, s string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(s))
+	return mac.Sum(nil)
+}
+
+// SignRequest sets the HMAC authorization and X-Content-Sha256 headers of a client request, the body is read and replaced.
+// The nonce must be unique for the key while the timestamp is accepted, like a random hex string.
+func SignRequest(r *http.Request, keyID, secret string, timestamp time.Time, nonce string) error {
+	bodyHash, err := hashBody(r)
+	if err != nil {
+		return err
+	}
+	if r.Method == http.MethodPut {
+		r.Header.Set(contentHashHeader, bodyHash)
+	}
+	unix := timestamp.Unix()
+	sig := signature(secret, stringToSign(r, unix, nonce, bodyHash))
+	r.Header.Set("authorization", fmt.Sprintf("%s keyId=%s,timestamp=%d,nonce=%s,signature=%s",
+		hmacScheme, keyID, unix, nonce, hex.EncodeToString(sig)))
+	return nil
+}
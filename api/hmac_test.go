@@ -0,0 +1,186 @@
+package api
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHMACAuth covers signed requests, clock skew and replays
+func TestHMACAuth(t *testing.T) {
+	const secret = "0123456789abcdef0123456789abcdef"
+	auth, err := NewHMACAuth([]HMACKey{
+		{ID: "batch", Secret: secret, Permissions: Permissions{Read: true, Write: true}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2022, 7, 18, 12, 0, 0, 0, time.UTC)
+	auth.now = func() time.Time { return now }
+	h := NewHandlerWithService(testPaymentsService())
+	defer h.Close()
+	handler := h.RequireAuth(auth)
+	body := testRawData["20220717/090000.payments"]
+
+	newRequest := func(method, target string) *http.Request {
+		if method == http.MethodGet {
+			return httptest.NewRequest(method, target, nil)
+		}
+		return httptest.NewRequest(method, target, strings.NewReader(body))
+	}
+	sign := func(t *testing.T, r *http.Request, keyID, secret string, timestamp time.Time, nonce string) *http.Request {
+		if err := SignRequest(r, keyID, secret, timestamp, nonce); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	cases := []struct {
+		name       string
+		request    func(t *testing.T) *http.Request
+		statusCode int
+	}{
+		{"signed query", func(t *testing.T) *http.Request {
+			return sign(t, newRequest(http.MethodGet, "/payments?to=20220718235959&from=20220717000000"), "batch", secret, now, "n1")
+		}, 200},
+		{"signed upload", func(t *testing.T) *http.Request {
+			return sign(t, newRequest(http.MethodPut, "/20220719/090000.payments"), "batch", secret, now.Add(-time.Minute), "n2")
+		}, 201},
+		{"unknown key", func(t *testing.T) *http.Request {
+			return sign(t, newRequest(http.MethodGet, "/"), "other", secret, now, "n3")
+		}, 401},
+		{"wrong secret", func(t *testing.T) *http.Request {
+			return sign(t, newRequest(http.MethodGet, "/"), "batch", strings.Repeat("x", 32), now, "n4")
+		}, 401},
+		{"tampered query", func(t *testing.T) *http.Request {
+			r := sign(t, newRequest(http.MethodGet, "/payments?from=20220717000000"), "batch", secret, now, "n5")
+			r.URL.RawQuery = "from=20220718000000"
+			return r
+		}, 401},
+		{"tampered body", func(t *testing.T) *http.Request {
+			r := sign(t, newRequest(http.MethodPut, "/20220719/100000.payments"), "batch", secret, now, "n6")
+			r.Body = httptest.NewRequest(http.MethodPut, "/", strings.NewReader(body+"\n20220719,100000,1,1,x")).Body
+			return r
+		}, 401},
+		{"expired timestamp", func(t *testing.T) *http.Request {
+			return sign(t, newRequest(http.MethodGet, "/"), "batch", secret, now.Add(-6*time.Minute), "n7")
+		}, 401},
+		{"future timestamp", func(t *testing.T) *http.Request {
+			return sign(t, newRequest(http.MethodGet, "/"), "batch", secret, now.Add(6*time.Minute), "n8")
+		}, 401},
+		{"body on a read route", func(t *testing.T) *http.Request {
+			return sign(t, httptest.NewRequest(http.MethodGet, "/", strings.NewReader(body)), "batch", secret, now, "n9")
+		}, 401},
+		{"body on an upload signed without a body", func(t *testing.T) *http.Request {
+			r := sign(t, httptest.NewRequest(http.MethodPut, "/20220719/103000.payments", strings.NewReader("")), "batch", secret, now, "n12")
+			r.Body = ioutil.NopCloser(strings.NewReader(body))
+			return r
+		}, 401},
+		{"upload without content hash", func(t *testing.T) *http.Request {
+			r := sign(t, newRequest(http.MethodPut, "/20220719/110000.payments"), "batch", secret, now, "n10")
+			r.Header.Del(contentHashHeader)
+			return r
+		}, 401},
+		{"missing parameters", func(t *testing.T) *http.Request {
+			r := newRequest(http.MethodGet, "/")
+			r.Header.Set("authorization", "HMAC keyId=batch")
+			return r
+		}, 401},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			w := serve(c.request(t))
+			if w.Code != c.statusCode {
+				t.Fatalf("invalid status code, got %d, expected %d: %s", w.Code, c.statusCode, w.Body.String())
+			}
+		})
+	}
+
+	t.Run("body isn't read before the signature is checked", func(t *testing.T) {
+		r := sign(t, newRequest(http.MethodPut, "/20220719/120000.payments"), "batch", strings.Repeat("x", 32), now, "n11")
+		reader := &countingReader{r: strings.NewReader(body)}
+		r.Body = ioutil.NopCloser(reader)
+		if w := serve(r); w.Code != 401 {
+			t.Fatalf("invalid status code, got %d, expected %d", w.Code, 401)
+		}
+		if reader.n != 0 {
+			t.Fatalf("invalid number of bytes read, got %d, expected %d", reader.n, 0)
+		}
+	})
+
+	t.Run("replay", func(t *testing.T) {
+		r := sign(t, newRequest(http.MethodGet, "/"), "batch", secret, now, "replayed")
+		replay := r.Clone(r.Context())
+		if w := serve(r); w.Code != 200 {
+			t.Fatalf("invalid status code, got %d, expected %d", w.Code, 200)
+		}
+		if w := serve(replay); w.Code != 401 || !strings.Contains(w.Body.String(), "already used") {
+			t.Fatalf("expected replay to be rejected, got %d %s", w.Code, w.Body.String())
+		}
+		// Nonces are forgotten once their timestamp is outside the allowed skew:
+		now = now.Add(DefaultMaxSkew + time.Second)
+		serve(sign(t, newRequest(http.MethodGet, "/"), "batch", secret, now, "other"))
+		auth.mu.Lock()
+		_, ok := auth.nonces["batch replayed"]
+		auth.mu.Unlock()
+		if ok {
+			t.Fatal("expected expired nonce to be removed")
+		}
+	})
+
+	t.Run("full replay cache", func(t *testing.T) {
+		auth.MaxNonces = 2
+		defer func() { auth.MaxNonces = 0 }()
+		statusCodes := []int{}
+		for _, nonce := range []string{"full1", "full2", "full3"} {
+			statusCodes = append(statusCodes, serve(sign(t, newRequest(http.MethodGet, "/"), "batch", secret, now, nonce)).Code)
+		}
+		// The cache already holds the nonce of the previous test:
+		if statusCodes[0] != 200 || statusCodes[1] != 503 || statusCodes[2] != 503 {
+			t.Fatalf("invalid status codes, got %v, expected %v", statusCodes, []int{200, 503, 503})
+		}
+		// Expired nonces make room for new ones:
+		now = now.Add(DefaultMaxSkew + time.Second)
+		if w := serve(sign(t, newRequest(http.MethodGet, "/"), "batch", secret, now, "full4")); w.Code != 200 {
+			t.Fatalf("invalid status code, got %d, expected %d", w.Code, 200)
+		}
+		if len(auth.nonces) != len(auth.expiries) {
+			t.Fatalf("invalid replay cache, got %d nonces and %d expiries", len(auth.nonces), len(auth.expiries))
+		}
+	})
+
+	t.Run("invalid keys", func(t *testing.T) {
+		invalid := [][]HMACKey{
+			{{Secret: secret}},
+			{{ID: "a", Secret: "short"}},
+			{{ID: "a", Secret: secret}, {ID: "a", Secret: secret}},
+			{{ID: "a", Secret: secret, Permissions: Permissions{From: "xyz"}}},
+		}
+		for _, keys := range invalid {
+			if _, err := NewHMACAuth(keys); err == nil {
+				t.Fatalf("expected error for %+v", keys)
+			}
+		}
+	})
+}
+
+// countingReader counts the bytes read from a reader
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
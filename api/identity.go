@@ -0,0 +1,78 @@
+package api
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+)
+
+// Authentication methods used by Identity:
+const (
+	AuthClientCertificate = "client-certificate"
+)
+
+// Identity describes the authenticated client of a request
+type Identity struct {
+	// Name identifies the client, like the common name of its certificate:
+	Name string
+	// Method is the authentication method, like AuthClientCertificate:
+	Method string
+	// Permissions are enforced by RequireAuth, identities without permissions can't access anything:
+	Permissions *Permissions
+}
+
+// identityKey is the context key of the request identity
+type identityKey struct{}
+
+// WithIdentity returns a copy of ctx carrying a given identity
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFromContext returns the identity of the request, if any
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(*Identity)
+	return identity, ok
+}
+
+// ClientCertificateIdentity is a middleware that maps the verified client certificate of TLS requests to an Identity.
+// The identity name is the common name of the certificate subject, or the whole subject when it has no common name.
+// Requests without a verified certificate are passed on as they are.
+func ClientCertificateIdentity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cert := verifiedCertificate(r); cert != nil {
+			identity := &Identity{Name: certificateName(cert), Method: AuthClientCertificate}
+			r = r.WithContext(WithIdentity(r.Context(), identity))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// verifiedCertificate is a helper that returns the client certificate of a request, nil when it wasn't verified
+func verifiedCertificate(r *http.Request) *x509.Certificate {
+	// VerifiedChains is only set when the certificate was verified against the client CA bundle:
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// certificateName is a helper that returns the common name of a certificate, or its whole subject when it has no common name
+func certificateName(cert *x509.Certificate) string {
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	return cert.Subject.String()
+}
+
+// certificateNames is a helper that lists the names a certificate can be matched by:
+// its common name, its whole subject, and its DNS, email and URI subject alternative names
+func certificateNames(cert *x509.Certificate) []string {
+	names := []string{certificateName(cert), cert.Subject.String()}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	return names
+}
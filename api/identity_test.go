@@ -0,0 +1,48 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientCertificateIdentity(t *testing.T) {
+	var identity *Identity
+	handler := ClientCertificateIdentity(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, _ = IdentityFromContext(r.Context())
+	}))
+	verified := func(subject pkix.Name) *tls.ConnectionState {
+		return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: subject}}}}
+	}
+	cases := []struct {
+		name     string
+		state    *tls.ConnectionState
+		expected string
+	}{
+		{"plaintext", nil, ""},
+		{"unverified certificate", &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "x"}}}}, ""},
+		{"common name", verified(pkix.Name{CommonName: "batch-job", Organization: []string{"Payments"}}), "batch-job"},
+		{"subject without common name", verified(pkix.Name{Organization: []string{"Payments"}}), "O=Payments"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			identity = nil
+			r := httptest.NewRequest("GET", "/", nil)
+			r.TLS = c.state
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+			name := ""
+			if identity != nil {
+				name = identity.Name
+				if identity.Method != AuthClientCertificate {
+					t.Fatalf("invalid method, got '%s', expected '%s'", identity.Method, AuthClientCertificate)
+				}
+			}
+			if name != c.expected {
+				t.Fatalf("invalid identity, got '%s', expected '%s'", name, c.expected)
+			}
+		})
+	}
+}
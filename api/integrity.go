@@ -0,0 +1,15 @@
+package api
+
+import (
+	"net/http"
+)
+
+// serveIntegrity returns the sequence number findings of a directory, like /YYYYMMDD/integrity
+func (h *Handler) serveIntegrity(w http.ResponseWriter, r *http.Request, dir string) {
+	report, err := h.paymentsService.CheckDirectory(dir)
+	if err != nil {
+		h.serveServiceError(w, r, err)
+		return
+	}
+	h.serveJSON(w, r, http.StatusOK, report)
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/matiasinsaurralde/product-services/payment"
+)
+
+func TestIntegrity(t *testing.T) {
+	store := payment.NewMemoryStore()
+	store.AddFile("20220717", "063000.payments", []byte("date,time,sequence,amount\n20220717,063000,1,10\n20220717,063000,1,10\n"))
+	store.AddFile("20220717", "090000.payments", []byte("date,time,sequence,amount\n20220717,090000,5,10\n"))
+	ts := httptest.NewServer(NewHandlerWithService(payment.NewWithStore(store)))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/20220717/integrity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		t.Fatalf("invalid status code, got %d, expected 200", res.StatusCode)
+	}
+	var report payment.IntegrityReport
+	if err := json.NewDecoder(res.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Findings) != 2 || report.Findings[0].Type != payment.FindingDuplicate || report.Findings[1].Type != payment.FindingGap {
+		t.Fatalf("unexpected findings %+v", report.Findings)
+	}
+
+	res, err = http.Get(ts.URL + "/2022/integrity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != 400 {
+		t.Fatalf("invalid status code, got %d, expected 400", res.StatusCode)
+	}
+}
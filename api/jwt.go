@@ -0,0 +1,341 @@
+package api
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// AuthJWT is the authentication method of bearer tokens:
+	AuthJWT = "jwt"
+	// bearerScheme is the authorization scheme and challenge of bearer tokens:
+	bearerScheme = "Bearer"
+	// DefaultLeeway is the clock difference tolerated when checking the expiry of tokens:
+	DefaultLeeway = time.Minute
+)
+
+// Scopes mapped to Permissions.Read and Permissions.Write:
+const (
+	ScopeRead  = "payments:read"
+	ScopeWrite = "payments:write"
+)
+
+// Signature algorithms accepted in tokens:
+const (
+	algRS256 = "RS256"
+	algES256 = "ES256"
+)
+
+// jwk is a key of a JWKS file, only RSA and P-256 keys are supported
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA keys:
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC keys:
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// jwtKey is a verification key with its algorithm
+type jwtKey struct {
+	kid string
+	alg string
+	key crypto.PublicKey
+}
+
+// JWTAuth authenticates requests with JWT bearer tokens signed by the keys of a JWKS file.
+// Tokens must be signed with RS256 or ES256 and carry the configured issuer and audience and an expiry.
+// The scope claim grants payments:read and payments:write, and the payments_directories, payments_from and
+// payments_to claims limit the directories like the fields of Permissions.
+type JWTAuth struct {
+	Issuer   string
+	Audience string
+	// Leeway is the clock difference tolerated for the exp and nbf claims, DefaultLeeway when zero:
+	Leeway time.Duration
+	keys   []jwtKey
+	// now is replaced by tests:
+	now func() time.Time
+}
+
+// jwtHeader holds the fields of the token header used to pick a key
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// jwtClaims holds the registered and payments claims of a token
+type jwtClaims struct {
+	Issuer    string          `json:"iss"`
+	Subject   string          `json:"sub"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt *float64        `json:"exp"`
+	NotBefore *float64        `json:"nbf"`
+	// Scope is a space separated list of scopes, some issuers use an array:
+	Scope       json.RawMessage `json:"scope"`
+	Directories []string        `json:"payments_directories"`
+	From        string          `json:"payments_from"`
+	To          string          `json:"payments_to"`
+}
+
+// LoadJWTAuth reads a JWKS file and returns an authenticator accepting tokens for a given issuer and audience
+func LoadJWTAuth(jwksPath, issuer, audience string) (*JWTAuth, error) {
+	data, err := ioutil.ReadFile(jwksPath)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read JWKS file: %w", err)
+	}
+	auth, err := NewJWTAuth(data, issuer, audience)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWKS file '%s': %s", jwksPath, err.Error())
+	}
+	return auth, nil
+}
+
+// NewJWTAuth parses a JWKS document and returns an authenticator accepting tokens for a given issuer and audience
+func NewJWTAuth(jwks []byte, issuer, audience string) (*JWTAuth, error) {
+	if issuer == "" || audience == "" {
+		return nil, errors.New("issuer and audience must be set")
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(jwks, &set); err != nil {
+		return nil, err
+	}
+	a := &JWTAuth{Issuer: issuer, Audience: audience, now: time.Now}
+	for i, k := range set.Keys {
+		// Encryption keys are ignored:
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %s", i+1, err.Error())
+		}
+		a.keys = append(a.keys, *key)
+	}
+	if len(a.keys) == 0 {
+		return nil, errors.New("no RS256 or ES256 signing keys")
+	}
+	return a, nil
+}
+
+// publicKey is a helper that decodes an RSA or P-256 key
+func (k *jwk) publicKey() (*jwtKey, error) {
+	key := &jwtKey{kid: k.Kid}
+	switch k.Kty {
+	case "RSA":
+		key.alg = algRS256
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %s", err.Error())
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid exponent")
+		}
+		if n.BitLen() < 2048 {
+			return nil, errors.New("RSA keys must have at least 2048 bits")
+		}
+		key.key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+	case "EC":
+		key.alg = algES256
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve '%s'", k.Crv)
+		}
+		x, errX := decodeBigInt(k.X)
+		y, errY := decodeBigInt(k.Y)
+		if errX != nil || errY != nil || !elliptic.P256().IsOnCurve(x, y) {
+			return nil, errors.New("invalid P-256 point")
+		}
+		key.key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	default:
+		return nil, fmt.Errorf("unsupported key type '%s'", k.Kty)
+	}
+	if k.Alg != "" && k.Alg != key.alg {
+		return nil, fmt.Errorf("unsupported algorithm '%s'", k.Alg)
+	}
+	return key, nil
+}
+
+// decodeBigInt is a helper that decodes a base64url encoded big-endian integer
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// Authenticate satisfies Authenticator, tokens are read from the Authorization header
+func (a *JWTAuth) Authenticate(r *http.Request) (*Identity, error) {
+	fields := strings.Fields(r.Header.Get("authorization"))
+	if len(fields) != 2 || !strings.EqualFold(fields[0], bearerScheme) {
+		return nil, ErrNoCredentials
+	}
+	claims, err := a.verify(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid bearer token: %s", err.Error())
+	}
+	permissions, err := claims.permissions()
+	if err != nil {
+		return nil, fmt.Errorf("invalid bearer token: %s", err.Error())
+	}
+	return &Identity{Name: claims.Subject, Method: AuthJWT, Permissions: permissions}, nil
+}
+
+// Challenge satisfies Authenticator
+func (a *JWTAuth) Challenge() string {
+	return bearerScheme
+}
+
+// verify is a helper that checks the signature, issuer, audience and lifetime of a token and returns its claims
+func (a *JWTAuth) verify(token string) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("invalid header: %s", err.Error())
+	}
+	if header.Alg != algRS256 && header.Alg != algES256 {
+		return nil, fmt.Errorf("unsupported algorithm '%s'", header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("invalid signature encoding")
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if !a.verifySignature(header, digest[:], sig) {
+		return nil, errors.New("invalid signature")
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid claims: %s", err.Error())
+	}
+	if claims.Issuer != a.Issuer {
+		return nil, fmt.Errorf("unexpected issuer '%s'", claims.Issuer)
+	}
+	if !claims.hasAudience(a.Audience) {
+		return nil, errors.New("unexpected audience")
+	}
+	leeway := a.Leeway
+	if leeway <= 0 {
+		leeway = DefaultLeeway
+	}
+	now := a.now()
+	if claims.ExpiresAt == nil {
+		return nil, errors.New("missing expiry")
+	}
+	if now.Add(-leeway).After(time.Unix(int64(*claims.ExpiresAt), 0)) {
+		return nil, errors.New("token expired")
+	}
+	if claims.NotBefore != nil && now.Add(leeway).Before(time.Unix(int64(*claims.NotBefore), 0)) {
+		return nil, errors.New("token not valid yet")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("missing subject")
+	}
+	return &claims, nil
+}
+
+// verifySignature is a helper that checks a signature with the keys matching the algorithm and key ID of a token,
+// every key of the algorithm is tried when the token doesn't have a key ID
+func (a *JWTAuth) verifySignature(header jwtHeader, digest, sig []byte) bool {
+	for _, key := range a.keys {
+		if key.alg != header.Alg || (header.Kid != "" && key.kid != header.Kid) {
+			continue
+		}
+		switch pub := key.key.(type) {
+		case *rsa.PublicKey:
+			if rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest, sig) == nil {
+				return true
+			}
+		case *ecdsa.PublicKey:
+			// ES256 signatures are the 32 byte r and s values:
+			if len(sig) != 64 {
+				continue
+			}
+			r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+			if ecdsa.Verify(pub, digest, r, s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// decodeSegment is a helper that decodes a base64url encoded JSON segment of a token
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// hasAudience reports whether the aud claim, a string or an array, contains a given audience
+func (c *jwtClaims) hasAudience(audience string) bool {
+	for _, aud := range stringOrArray(c.Audience) {
+		if aud == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// permissions is a helper that maps the scopes and payments claims of a token to Permissions
+func (c *jwtClaims) permissions() (*Permissions, error) {
+	permissions := &Permissions{Directories: c.Directories, From: c.From, To: c.To}
+	for _, claim := range stringOrArray(c.Scope) {
+		for _, scope := range strings.Fields(claim) {
+			switch scope {
+			case ScopeRead:
+				permissions.Read = true
+			case ScopeWrite:
+				permissions.Write = true
+			}
+		}
+	}
+	if err := permissions.Validate(); err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+// stringOrArray is a helper that decodes claims that can be a string or an array of strings
+func stringOrArray(raw json.RawMessage) []string {
+	if len(raw) == 0 {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return []string{s}
+	}
+	var a []string
+	if err := json.Unmarshal(raw, &a); err == nil {
+		return a
+	}
+	return nil
+}
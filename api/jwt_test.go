@@ -0,0 +1,155 @@
+package api
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signTestToken is a helper that builds a JWT signed with an RSA or P-256 key
+func signTestToken(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	segment := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signingInput := segment(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + segment(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// TestJWTAuth covers signature, issuer, audience and expiry checks and the mapping of claims to permissions
+func TestJWTAuth(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec", "alg": "ES256", "crv": "P-256", "x": b64(ecKey.X.Bytes()), "y": b64(ecKey.Y.Bytes())},
+		{"kty": "oct", "use": "enc", "k": "c2VjcmV0"},
+	}})
+	auth, err := NewJWTAuth(jwks, "https://login.example.com", "payments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2022, 7, 18, 12, 0, 0, 0, time.UTC)
+	auth.now = func() time.Time { return now }
+	h := NewHandlerWithService(testPaymentsService())
+	defer h.Close()
+	handler := h.RequireAuth(auth)
+
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss":   "https://login.example.com",
+			"aud":   []string{"other", "payments"},
+			"sub":   "reports",
+			"exp":   now.Add(time.Hour).Unix(),
+			"scope": "openid payments:read",
+		}
+		for k, v := range changes {
+			if v == nil {
+				delete(c, k)
+				continue
+			}
+			c[k] = v
+		}
+		return c
+	}
+	cases := []struct {
+		name       string
+		method     string
+		path       string
+		token      string
+		statusCode int
+	}{
+		{"RS256", http.MethodGet, "/", signTestToken(t, "RS256", "rsa", rsaKey, claims(nil)), 200},
+		{"ES256", http.MethodGet, "/", signTestToken(t, "ES256", "ec", ecKey, claims(nil)), 200},
+		{"without key ID", http.MethodGet, "/", signTestToken(t, "ES256", "", ecKey, claims(nil)), 200},
+		{"unknown signer", http.MethodGet, "/", signTestToken(t, "ES256", "ec", otherKey, claims(nil)), 401},
+		{"algorithm mismatch", http.MethodGet, "/", signTestToken(t, "RS256", "ec", rsaKey, claims(nil)), 401},
+		{"wrong issuer", http.MethodGet, "/", signTestToken(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"iss": "https://other.example.com"})), 401},
+		{"wrong audience", http.MethodGet, "/", signTestToken(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"aud": "other"})), 401},
+		{"expired", http.MethodGet, "/", signTestToken(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"exp": now.Add(-2 * time.Minute).Unix()})), 401},
+		{"expired within leeway", http.MethodGet, "/", signTestToken(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"exp": now.Add(-30 * time.Second).Unix()})), 200},
+		{"missing expiry", http.MethodGet, "/", signTestToken(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"exp": nil})), 401},
+		{"not valid yet", http.MethodGet, "/", signTestToken(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"nbf": now.Add(time.Hour).Unix()})), 401},
+		{"without scopes", http.MethodGet, "/", signTestToken(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"scope": nil})), 403},
+		{"upload without write scope", http.MethodPut, "/20220719/090000.payments", signTestToken(t, "RS256", "rsa", rsaKey, claims(nil)), 403},
+		{"upload", http.MethodPut, "/20220719/090000.payments", signTestToken(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"scope": []string{"payments:write"}})), 201},
+		{"allowed dates", http.MethodGet, "/20220717/090000.payments", signTestToken(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"payments_from": "20220717", "payments_to": "20220717"})), 200},
+		{"outside allowed dates", http.MethodGet, "/20220718/010101.payments", signTestToken(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"payments_from": "20220717", "payments_to": "20220717"})), 403},
+		{"invalid allowed dates", http.MethodGet, "/", signTestToken(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"payments_directories": []string{"yesterday"}})), 401},
+		{"malformed token", http.MethodGet, "/", "xyz", 401},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest(c.method, c.path, nil)
+			if c.method == http.MethodPut {
+				r = httptest.NewRequest(c.method, c.path, strings.NewReader(testRawData["20220717/090000.payments"]))
+			}
+			r.Header.Set("authorization", "Bearer "+c.token)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != c.statusCode {
+				t.Fatalf("invalid status code, got %d, expected %d: %s", w.Code, c.statusCode, w.Body.String())
+			}
+			if w.Code == 401 && w.Header().Get("www-authenticate") != bearerScheme {
+				t.Fatalf("invalid challenge, got '%s', expected '%s'", w.Header().Get("www-authenticate"), bearerScheme)
+			}
+		})
+	}
+
+	t.Run("invalid key sets", func(t *testing.T) {
+		invalid := []string{
+			`{"keys": []}`,
+			`{"keys": [{"kty": "RSA", "n": "AQAB", "e": "AQAB"}]}`,
+			`{"keys": [{"kty": "EC", "crv": "P-384", "x": "AQAB", "y": "AQAB"}]}`,
+			`{"keys": [{"kty": "EC", "crv": "P-256", "x": "AQAB", "y": "AQAB"}]}`,
+			`{"keys": [{"kty": "oct", "k": "c2VjcmV0"}]}`,
+		}
+		for _, jwks := range invalid {
+			if _, err := NewJWTAuth([]byte(jwks), "issuer", "audience"); err == nil {
+				t.Fatalf("expected error for %s", jwks)
+			}
+		}
+	})
+}
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/matiasinsaurralde/product-services/metrics"
+	"github.com/matiasinsaurralde/product-services/payment"
+)
+
+const (
+	// metricsPath is the route of the Prometheus metrics endpoint:
+	metricsPath = "metrics"
+	// dataCountsTTL is how long the directory and file counts are reused between scrapes:
+	dataCountsTTL = 10 * time.Second
+)
+
+// routeNames are used as the route label of the request metrics
+var routeNames = map[PathType]string{
+	PATH_ROOT:      "root",
+	PATH_DIR:       "directory",
+	PATH_PAYMENT:   "payment",
+	PATH_QUERY:     "query",
+	PATH_EVENTS:    "events",
+	PATH_SUMMARY:   "summary",
+	PATH_INTEGRITY: "integrity",
+	PATH_METRICS:   "metrics",
+	PATH_HEALTH:    "health",
+	PATH_READY:     "ready",
+	PATH_ERROR:     "unknown",
+}
+
+// methodNames are used as the method label of the request metrics, other methods are labeled "other"
+// so made-up methods don't create new series:
+var methodNames = map[string]string{
+	http.MethodGet:     http.MethodGet,
+	http.MethodHead:    http.MethodHead,
+	http.MethodPut:     http.MethodPut,
+	http.MethodPost:    http.MethodPost,
+	http.MethodDelete:  http.MethodDelete,
+	http.MethodOptions: http.MethodOptions,
+}
+
+// handlerMetrics holds the metrics exposed by the /metrics route
+type handlerMetrics struct {
+	registry        *metrics.Registry
+	requests        *metrics.Counter
+	requestDuration *metrics.Histogram
+	parseDuration   *metrics.Histogram
+	parsedFiles     *metrics.Counter
+	parsedRows      *metrics.Counter
+	rejectedRows    *metrics.Counter
+	dataCounts      *dataCounts
+}
+
+// dataCounts keeps the directory and file counts of the data directory for dataCountsTTL,
+// so both gauges are computed from a single pass over the store
+type dataCounts struct {
+	service *payment.PaymentsService
+	// now is replaced by tests:
+	now func() time.Time
+
+	mu      sync.Mutex
+	updated time.Time
+	dirs    int
+	files   int
+}
+
+// get returns the counts, counting again when they're older than dataCountsTTL.
+// Errors are reported as zero counts until the next update.
+func (c *dataCounts) get() (dirs, files int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if c.updated.IsZero() || now.Sub(c.updated) >= dataCountsTTL {
+		c.dirs, c.files, _ = c.service.CountPayments()
+		c.updated = now
+	}
+	return c.dirs, c.files
+}
+
+// newHandlerMetrics initializes the metrics of a handler, directory and file counts are read from the service
+// when they're older than dataCountsTTL
+func newHandlerMetrics(paymentsService *payment.PaymentsService) *handlerMetrics {
+	registry := metrics.NewRegistry()
+	m := &handlerMetrics{
+		registry: registry,
+		requests: registry.NewCounter("payments_http_requests_total",
+			"Number of HTTP requests by route, method and status code.", "route", "method", "code"),
+		requestDuration: registry.NewHistogram("payments_http_request_duration_seconds",
+			"Latency of HTTP requests by route and method.", metrics.DefaultBuckets, "route", "method"),
+		parseDuration: registry.NewHistogram("payments_file_parse_duration_seconds",
+			"Time spent parsing payments files.", metrics.DefaultBuckets),
+		parsedFiles: registry.NewCounter("payments_files_parsed_total",
+			"Number of parsed payments files by result.", "result"),
+		parsedRows: registry.NewCounter("payments_rows_parsed_total",
+			"Number of valid rows read from payments files."),
+		rejectedRows: registry.NewCounter("payments_rows_rejected_total",
+			"Number of rows rejected when parsing payments files."),
+	}
+	m.dataCounts = &dataCounts{service: paymentsService, now: time.Now}
+	registry.NewGaugeFunc("payments_directories", "Number of date directories in the data directory.", func() float64 {
+		dirs, _ := m.dataCounts.get()
+		return float64(dirs)
+	})
+	registry.NewGaugeFunc("payments_files", "Number of payments files in the data directory.", func() float64 {
+		_, files := m.dataCounts.get()
+		return float64(files)
+	})
+	return m
+}
+
+// ObserveParse satisfies payment.ParseObserver
+func (m *handlerMetrics) ObserveParse(stats payment.ParseStats) {
+	m.parseDuration.Observe(stats.Duration.Seconds())
+	result := "ok"
+	if stats.Err != nil {
+		result = "error"
+	}
+	m.parsedFiles.Inc(result)
+	m.parsedRows.Add(float64(stats.Rows))
+	m.rejectedRows.Add(float64(stats.Rejected))
+}
+
+// observeRequest is a helper that records the status code and latency of a request
+func (m *handlerMetrics) observeRequest(pathType PathType, method string, status int, duration time.Duration) {
+	route := routeNames[pathType]
+	method, ok := methodNames[method]
+	if !ok {
+		method = "other"
+	}
+	m.requests.Inc(route, method, strconv.Itoa(status))
+	m.requestDuration.Observe(duration.Seconds(), route, method)
+}
+
+// statusRecorder is a helper that keeps the status code written by a handler,
+// it forwards Flush and Hijack and can be unwrapped so streaming responses keep working
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(p)
+}
+
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		if s.status == 0 {
+			s.status = http.StatusOK
+		}
+		flusher.Flush()
+	}
+}
+
+// Unwrap is used by http.ResponseController to reach the features of the underlying writer, like write deadlines
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := s.ResponseWriter.(http.Hijacker); ok {
+		return hijacker.Hijack()
+	}
+	return nil, nil, errors.New("hijacking isn't supported")
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matiasinsaurralde/product-services/payment"
+)
+
+func TestMetrics(t *testing.T) {
+	paymentsService := testPaymentsService()
+	paymentsService.Store.(*payment.MemoryStore).AddFile("20220719", "090000.payments", []byte(`date,time,sequence,amount
+20220719,090000,1,10
+20220719,090000,2,abc`))
+	ts := httptest.NewServer(NewHandlerWithService(paymentsService))
+	defer ts.Close()
+
+	for _, path := range []string{"/", "/20220717/090000.payments", "/20220719/090000.payments?format=json", "/20220717/100000.payments", "/a/b/c/d"} {
+		res, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+	}
+
+	// Unknown methods share a single series:
+	for _, method := range []string{"FOO0", "FOO1", "FOO2"} {
+		req, err := http.NewRequest(method, ts.URL+"/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+
+	res, err := http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		t.Fatalf("invalid status code, got %d, expected %d", res.StatusCode, 200)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`payments_http_requests_total{route="root",method="GET",code="200"} 1`,
+		`payments_http_requests_total{route="payment",method="GET",code="200"} 2`,
+		`payments_http_requests_total{route="payment",method="GET",code="404"} 1`,
+		`payments_http_requests_total{route="unknown",method="GET",code="404"} 1`,
+		`payments_http_requests_total{route="root",method="other",code="405"} 3`,
+		`payments_http_request_duration_seconds_count{route="payment",method="GET"} 3`,
+		`payments_files_parsed_total{result="ok"} 2`,
+		`payments_rows_parsed_total 3`,
+		`payments_rows_rejected_total 1`,
+		`payments_file_parse_duration_seconds_count 2`,
+		`payments_directories 3`,
+		`payments_files 3`,
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Fatalf("expected '%s' in metrics output:\n%s", line, body)
+		}
+	}
+	if series := strings.Count(string(body), "payments_http_requests_total{route=\"root\""); series != 2 {
+		t.Fatalf("invalid number of root request series, got %d, expected %d", series, 2)
+	}
+}
+
+// partitionCountingStore is a MemoryStore that counts how many times the partitions are listed
+type partitionCountingStore struct {
+	*payment.MemoryStore
+	mu    sync.Mutex
+	count int
+}
+
+func (s *partitionCountingStore) ListPartitions() ([]string, error) {
+	s.mu.Lock()
+	s.count++
+	s.mu.Unlock()
+	return s.MemoryStore.ListPartitions()
+}
+
+// TestMetricsDataCounts checks that the directory and file gauges share a cached pass over the store
+func TestMetricsDataCounts(t *testing.T) {
+	store := &partitionCountingStore{MemoryStore: payment.NewMemoryStore()}
+	store.AddFile("20220717", "090000.payments", []byte(testRawData["20220717/090000.payments"]))
+	// Invalid names aren't counted nor logged:
+	store.AddFile("20220717", "notes.txt", nil)
+	store.AddFile("xyz", "090000.payments", nil)
+	h := NewHandlerWithService(payment.NewWithStore(store))
+	defer h.Close()
+	now := time.Now()
+	h.metrics.dataCounts.now = func() time.Time { return now }
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	scrape := func() string {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		return w.Body.String()
+	}
+	expectCounts := func(body string, dirs, files int) {
+		t.Helper()
+		for _, line := range []string{fmt.Sprintf("payments_directories %d\n", dirs), fmt.Sprintf("payments_files %d\n", files)} {
+			if !strings.Contains(body, line) {
+				t.Fatalf("expected '%s' in metrics output:\n%s", strings.TrimSpace(line), body)
+			}
+		}
+	}
+
+	expectCounts(scrape(), 1, 1)
+	store.AddFile("20220718", "090000.payments", nil)
+	// Counts are reused until they expire:
+	expectCounts(scrape(), 1, 1)
+	now = now.Add(dataCountsTTL)
+	expectCounts(scrape(), 2, 2)
+	store.mu.Lock()
+	count := store.count
+	store.mu.Unlock()
+	if count != 2 {
+		t.Fatalf("invalid number of partition listings, got %d, expected %d", count, 2)
+	}
+	if logs.Len() > 0 {
+		t.Fatalf("unexpected log output: %s", logs.String())
+	}
+}
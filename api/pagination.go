@@ -0,0 +1,194 @@
+package api
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/matiasinsaurralde/product-services/payment"
+)
+
+const (
+	// limitParam and cursorParam enable pagination:
+	limitParam  = "limit"
+	cursorParam = "cursor"
+	// maxPageSize limits the number of items per page:
+	maxPageSize = 10000
+	// totalCountHeader reports the number of items across all pages:
+	totalCountHeader = "X-Total-Count"
+)
+
+// Cursor kinds, a cursor can only be used on the route that returned it:
+const (
+	cursorDirectory = "directory"
+	cursorFile      = "file"
+	cursorSequence  = "sequence"
+	cursorOffset    = "offset"
+)
+
+// pageCursor points to the last item of a page.
+// Pages are keyed by name or sequence number, so files added between requests don't shift the following pages.
+// Skip counts the items with the same key that were already returned, sequence numbers aren't always unique.
+type pageCursor struct {
+	kind string
+	key  string
+	skip int
+}
+
+// encode returns the opaque representation of the cursor
+func (c pageCursor) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.kind + "|" + c.key + "|" + strconv.Itoa(c.skip)))
+}
+
+// decodeCursor is a helper that parses a cursor of a given kind
+func decodeCursor(kind, s string) (*pageCursor, error) {
+	errInvalid := fmt.Errorf("invalid cursor '%s'", s)
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errInvalid
+	}
+	parts := strings.Split(string(raw), "|")
+	if len(parts) != 3 || parts[0] != kind {
+		return nil, errInvalid
+	}
+	skip, err := strconv.Atoi(parts[2])
+	if err != nil || skip < 0 {
+		return nil, errInvalid
+	}
+	if kind == cursorSequence || kind == cursorOffset {
+		if _, err := strconv.Atoi(parts[1]); err != nil {
+			return nil, errInvalid
+		}
+	}
+	return &pageCursor{kind: kind, key: parts[1], skip: skip}, nil
+}
+
+// pageRequest holds the pagination parameters of a request
+type pageRequest struct {
+	// paginated is false when neither limit or cursor are set, everything is returned in that case:
+	paginated bool
+	limit     int
+	cursor    *pageCursor
+}
+
+// parsePageRequest is a helper that reads the limit and cursor parameters for a given cursor kind
+func parsePageRequest(r *http.Request, kind string) (pageRequest, error) {
+	query := r.URL.Query()
+	page := pageRequest{limit: maxPageSize}
+	if s := query.Get(limitParam); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return page, fmt.Errorf("invalid limit '%s', expected a number between 1 and %d", s, maxPageSize)
+		}
+		page.paginated = true
+		page.limit = limit
+	}
+	if s := query.Get(cursorParam); s != "" {
+		cursor, err := decodeCursor(kind, s)
+		if err != nil {
+			return page, err
+		}
+		page.paginated = true
+		page.cursor = cursor
+	}
+	return page, nil
+}
+
+// paginateNames returns a page of sorted names and the cursor of the next page, if any
+func paginateNames(page pageRequest, kind string, names []string) ([]string, *pageCursor) {
+	sort.Strings(names)
+	start := 0
+	if page.cursor != nil {
+		start = sort.SearchStrings(names, page.cursor.key)
+		if start < len(names) && names[start] == page.cursor.key {
+			start++
+		}
+	}
+	end := start + page.limit
+	if end >= len(names) {
+		return names[start:], nil
+	}
+	return names[start:end], &pageCursor{kind: kind, key: names[end-1]}
+}
+
+// paginatePayments returns a page of payments ordered by sequence and the cursor of the next page, if any
+func paginatePayments(page pageRequest, payments []payment.Payment) ([]payment.Payment, *pageCursor) {
+	sort.SliceStable(payments, func(i, j int) bool {
+		return payments[i].Sequence < payments[j].Sequence
+	})
+	// firstIndex returns the position of the first payment with a sequence >= sequence:
+	firstIndex := func(sequence int) int {
+		return sort.Search(len(payments), func(i int) bool {
+			return payments[i].Sequence >= sequence
+		})
+	}
+	start := 0
+	if page.cursor != nil {
+		// decodeCursor already validated the key:
+		sequence, _ := strconv.Atoi(page.cursor.key)
+		start = firstIndex(sequence)
+		for skipped := 0; skipped < page.cursor.skip && start < len(payments) && payments[start].Sequence == sequence; skipped++ {
+			start++
+		}
+	}
+	end := start + page.limit
+	if end >= len(payments) {
+		return payments[start:], nil
+	}
+	last := payments[end-1].Sequence
+	return payments[start:end], &pageCursor{
+		kind: cursorSequence,
+		key:  strconv.Itoa(last),
+		skip: end - firstIndex(last),
+	}
+}
+
+// paginateOffset returns a page of payments keyed by position, it's used for sorted payments
+func paginateOffset(page pageRequest, payments []payment.Payment) ([]payment.Payment, *pageCursor) {
+	start := 0
+	if page.cursor != nil {
+		// decodeCursor already validated the key:
+		start, _ = strconv.Atoi(page.cursor.key)
+		if start > len(payments) || start < 0 {
+			start = len(payments)
+		}
+	}
+	end := start + page.limit
+	if end >= len(payments) {
+		return payments[start:], nil
+	}
+	return payments[start:end], &pageCursor{kind: cursorOffset, key: strconv.Itoa(end)}
+}
+
+// serveNamesPage is a helper that returns a page of directory or file names, kind is also used as the element name
+func (h *Handler) serveNamesPage(w http.ResponseWriter, r *http.Request, enc Encoder, kind string, names []string) {
+	page, err := parsePageRequest(r, kind)
+	if err != nil {
+		h.serveBadRequest(w, r, err.Error())
+		return
+	}
+	if page.paginated {
+		var next *pageCursor
+		total := len(names)
+		names, next = paginateNames(page, kind, names)
+		setPageHeaders(w, r, page, total, next)
+	}
+	h.serveNames(w, r, enc, kind, names)
+}
+
+// setPageHeaders is a helper that sets the total count and the Link header pointing to the next page
+func setPageHeaders(w http.ResponseWriter, r *http.Request, page pageRequest, total int, next *pageCursor) {
+	w.Header().Set(totalCountHeader, strconv.Itoa(total))
+	if next == nil {
+		return
+	}
+	query := r.URL.Query()
+	query.Set(cursorParam, next.encode())
+	query.Set(limitParam, strconv.Itoa(page.limit))
+	nextURL := *r.URL
+	nextURL.RawQuery = query.Encode()
+	w.Header().Add("link", fmt.Sprintf(`<%s>; rel="next"`, nextURL.RequestURI()))
+}
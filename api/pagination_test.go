@@ -0,0 +1,133 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/matiasinsaurralde/product-services/payment"
+)
+
+var linkNextPattern = regexp.MustCompile(`^<([^>]+)>; rel="next"$`)
+
+// fetchPages is a helper that follows the Link headers starting at a given path,
+// decoding every page into a new value returned by newPage
+func fetchPages(t *testing.T, baseURL, path string, newPage func() interface{}) (pages []interface{}, total string) {
+	for path != "" {
+		res, err := http.Get(baseURL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != 200 {
+			t.Fatalf("invalid status code for %s, got %d, expected 200", path, res.StatusCode)
+		}
+		page := newPage()
+		if err := json.NewDecoder(res.Body).Decode(page); err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		pages = append(pages, page)
+		total = res.Header.Get(totalCountHeader)
+		path = ""
+		if link := res.Header.Get("link"); link != "" {
+			match := linkNextPattern.FindStringSubmatch(link)
+			if match == nil {
+				t.Fatalf("invalid link header '%s'", link)
+			}
+			path = match[1]
+		}
+		if len(pages) > 100 {
+			t.Fatal("too many pages")
+		}
+	}
+	return pages, total
+}
+
+func TestPagination(t *testing.T) {
+	store := payment.NewMemoryStore()
+	for _, dir := range []string{"20220719", "20220717", "20220718"} {
+		store.AddFile(dir, "090000.payments", []byte("date,time,sequence,amount\n"))
+	}
+	store.AddFile("20220717", "100000.payments", []byte(`date,time,sequence,amount
+20220717,100000,5,1
+20220717,100000,3,1
+20220717,100000,3,2
+20220717,100000,3,3
+20220717,100000,1,1
+20220717,100000,4,1`))
+	ts := httptest.NewServer(NewHandlerWithService(payment.NewWithStore(store)))
+	defer ts.Close()
+
+	t.Run("directories", func(t *testing.T) {
+		pages, total := fetchPages(t, ts.URL, "/?limit=2", func() interface{} { return &[]string{} })
+		if len(pages) != 2 {
+			t.Fatalf("invalid number of pages, got %d, expected 2", len(pages))
+		}
+		var dirs []string
+		for _, page := range pages {
+			dirs = append(dirs, *page.(*[]string)...)
+		}
+		if strings.Join(dirs, ",") != "20220717,20220718,20220719" {
+			t.Fatalf("invalid directories, got %v", dirs)
+		}
+		if total != "3" {
+			t.Fatalf("invalid total count, got '%s', expected '3'", total)
+		}
+	})
+
+	t.Run("files", func(t *testing.T) {
+		pages, _ := fetchPages(t, ts.URL, "/20220717?limit=1", func() interface{} { return &[]string{} })
+		if len(pages) != 2 {
+			t.Fatalf("invalid number of pages, got %d, expected 2", len(pages))
+		}
+		if files := *pages[1].(*[]string); len(files) != 1 || files[0] != "100000.payments" {
+			t.Fatalf("invalid second page, got %v", files)
+		}
+	})
+
+	t.Run("payments", func(t *testing.T) {
+		for _, limit := range []string{"1", "2", "4", "10"} {
+			pages, total := fetchPages(t, ts.URL, "/20220717/100000.payments?limit="+limit, func() interface{} { return &[]payment.Payment{} })
+			var payments []payment.Payment
+			for _, page := range pages {
+				payments = append(payments, *page.(*[]payment.Payment)...)
+			}
+			if total != "6" {
+				t.Fatalf("invalid total count, got '%s', expected '6'", total)
+			}
+			// Payments are ordered by sequence, duplicated sequences keep the file order:
+			expected := []struct{ sequence, minor int64 }{{1, 100}, {3, 100}, {3, 200}, {3, 300}, {4, 100}, {5, 100}}
+			if len(payments) != len(expected) {
+				t.Fatalf("invalid number of payments with limit %s, got %d, expected %d", limit, len(payments), len(expected))
+			}
+			for i, p := range payments {
+				if int64(p.Sequence) != expected[i].sequence || p.Amount.Minor != expected[i].minor {
+					t.Fatalf("invalid payment %d with limit %s, got %+v", i, limit, p)
+				}
+			}
+		}
+	})
+
+	t.Run("invalid parameters", func(t *testing.T) {
+		dirCursor := pageCursor{kind: cursorDirectory, key: "20220717"}.encode()
+		for _, path := range []string{
+			"/?limit=0",
+			"/?limit=abc",
+			"/?limit=100000",
+			"/?cursor=abc",
+			"/20220717/100000.payments?cursor=" + dirCursor,
+		} {
+			res, err := http.Get(ts.URL + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != 400 {
+				t.Fatalf("invalid status code for %s, got %d, expected 400", path, res.StatusCode)
+			}
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/matiasinsaurralde/product-services/payment"
+)
+
+// streamPayments writes the payments of a file row by row without loading the whole file.
+// The response starts with the first payment, errors found before that still get an error response.
+// In strict mode the service checks the whole file first, so invalid files get the same 422 as buffered responses.
+// The number of rejected rows is only known at the end, so it's sent as a trailer. Payments that don't match filter are skipped.
+func (h *Handler) streamPayments(w http.ResponseWriter, r *http.Request, enc StreamEncoder, path string, filter *payment.Filter) {
+	// Big files can take longer than the server write timeout:
+	disableWriteTimeout(w)
+	ctx := r.Context()
+	var stream PaymentsStream
+	start := func() {
+		w.Header().Set("content-type", enc.ContentType())
+		w.Header().Set("trailer", rejectedRowsHeader)
+		w.WriteHeader(http.StatusOK)
+		stream = enc.NewPaymentsStream(w)
+	}
+	rejected := 0
+	err := h.paymentsService.StreamPaymentsReport(path, func(p payment.Payment) error {
+		// Stop reading the file as soon as the client goes away:
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !filter.Match(p) {
+			return nil
+		}
+		if stream == nil {
+			start()
+		}
+		return stream.Write(p)
+	}, func(payment.RowError) error {
+		rejected++
+		return nil
+	})
+	if err == nil && stream == nil {
+		// Files without matching payments are written as a regular response:
+		if rejected > 0 {
+			w.Header().Set(rejectedRowsHeader, strconv.Itoa(rejected))
+		}
+		h.servePayments(w, r, enc, nil)
+		return
+	}
+	if err != nil {
+		if stream == nil {
+			h.serveServiceError(w, r, err)
+			return
+		}
+		if ctx.Err() != nil {
+			// The client is gone, there's nobody to report the error to:
+			return
+		}
+		// The status was already sent, abort the response so the client doesn't take a truncated document as complete:
+		log.Printf("error: %s: %s\n", requestIDFromContext(ctx), err.Error())
+		panic(http.ErrAbortHandler)
+	}
+	if err := stream.Close(); err != nil {
+		log.Printf("error: %s: %s\n", requestIDFromContext(ctx), err.Error())
+		return
+	}
+	if rejected > 0 {
+		w.Header().Set(rejectedRowsHeader, strconv.Itoa(rejected))
+	}
+}
+
+// disableWriteTimeout is a helper that lifts the server write timeout for long-lived responses,
+// like streamed downloads and event streams, the timeout still applies to the next requests of the connection
+func disableWriteTimeout(w http.ResponseWriter) {
+	err := http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Printf("error: %s\n", err.Error())
+	}
+}
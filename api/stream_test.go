@@ -0,0 +1,150 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/matiasinsaurralde/product-services/payment"
+)
+
+// endlessStore serves a payments file that never ends
+type endlessStore struct {
+	payment.Store
+	rows int64
+}
+
+func (s *endlessStore) Open(partition, name string) (io.ReadCloser, error) {
+	r := &endlessReader{store: s}
+	r.buf.WriteString("date,time,sequence,amount\n")
+	return ioutil.NopCloser(r), nil
+}
+
+// endlessReader generates rows as they are read
+type endlessReader struct {
+	store *endlessStore
+	buf   bytes.Buffer
+}
+
+func (r *endlessReader) Read(p []byte) (int, error) {
+	for r.buf.Len() < len(p) {
+		sequence := atomic.AddInt64(&r.store.rows, 1)
+		fmt.Fprintf(&r.buf, "20220717,090000,%d,10.00\n", sequence)
+	}
+	return r.buf.Read(p)
+}
+
+func TestStreamPayments(t *testing.T) {
+	t.Run("large file", func(t *testing.T) {
+		var buf bytes.Buffer
+		buf.WriteString("date,time,sequence,amount\n")
+		for i := 0; i < 20000; i++ {
+			fmt.Fprintf(&buf, "20220717,090000,%d,10.00\n", i)
+		}
+		buf.WriteString("20220717,090000,20000,abc\n")
+		store := payment.NewMemoryStore()
+		store.AddFile("20220717", "090000.payments", buf.Bytes())
+		ts := httptest.NewServer(NewHandlerWithService(payment.NewWithStore(store)))
+		defer ts.Close()
+
+		for _, format := range []string{"json", "ndjson"} {
+			res, err := http.Get(ts.URL + "/20220717/090000.payments?format=" + format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			count := 0
+			dec := json.NewDecoder(res.Body)
+			if format == "json" {
+				var payments []payment.Payment
+				if err := dec.Decode(&payments); err != nil {
+					t.Fatal(err)
+				}
+				count = len(payments)
+			} else {
+				for {
+					var p payment.Payment
+					if err := dec.Decode(&p); err == io.EOF {
+						break
+					} else if err != nil {
+						t.Fatal(err)
+					}
+					count++
+				}
+			}
+			// Trailers are available once the body was read:
+			io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+			if count != 20000 {
+				t.Fatalf("invalid payments count for %s, got %d, expected %d", format, count, 20000)
+			}
+			if rejected := res.Trailer.Get(rejectedRowsHeader); rejected != "1" {
+				t.Fatalf("invalid %s trailer, got '%s', expected '1'", rejectedRowsHeader, rejected)
+			}
+		}
+	})
+
+	t.Run("empty file", func(t *testing.T) {
+		store := payment.NewMemoryStore()
+		store.AddFile("20220717", "090000.payments", []byte("date,time,sequence,amount\n20220717,090000,1,abc\n"))
+		ts := httptest.NewServer(NewHandlerWithService(payment.NewWithStore(store)))
+		defer ts.Close()
+		res, err := http.Get(ts.URL + "/20220717/090000.payments")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		if string(body) != "[]\n" {
+			t.Fatalf("invalid body, got '%s', expected '[]'", body)
+		}
+		if rejected := res.Header.Get(rejectedRowsHeader); rejected != "1" {
+			t.Fatalf("invalid %s header, got '%s', expected '1'", rejectedRowsHeader, rejected)
+		}
+	})
+
+	t.Run("client disconnect", func(t *testing.T) {
+		memoryStore := payment.NewMemoryStore()
+		memoryStore.AddPartition("20220717")
+		store := &endlessStore{Store: memoryStore}
+		handler := NewHandlerWithService(payment.NewWithStore(store))
+		done := make(chan struct{})
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer close(done)
+			handler.ServeHTTP(w, r)
+		}))
+		defer ts.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		req, err := http.NewRequestWithContext(ctx, "GET", ts.URL+"/20220717/090000.payments?format=ndjson", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadFull(res.Body, make([]byte, 4096)); err != nil {
+			t.Fatal(err)
+		}
+		cancel()
+		res.Body.Close()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("handler didn't stop after the client disconnected")
+		}
+		rows := atomic.LoadInt64(&store.rows)
+		time.Sleep(50 * time.Millisecond)
+		if atomic.LoadInt64(&store.rows) != rows {
+			t.Fatal("file is still being read")
+		}
+	})
+}
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/matiasinsaurralde/product-services/payment"
+)
+
+// serveSummary returns the aggregates of a payments file, like /YYYYMMDD/HHMMSS.payments/summary,
+// a directory, like /YYYYMMDD/summary, or a range query, like /payments/summary?from=...&to=...
+func (h *Handler) serveSummary(w http.ResponseWriter, r *http.Request, params []string) {
+	var summary *payment.Summary
+	var err error
+	switch {
+	case len(params) == 2:
+		summary, err = h.paymentsService.SummarizeFile(params[0] + "/" + params[1])
+	case params[0] == queryPath:
+		from, to, rangeErr := h.parseRange(r)
+		if rangeErr != nil {
+			h.serveBadRequest(w, r, rangeErr.Error())
+			return
+		}
+		summary, err = h.paymentsService.SummarizeRange(from, to)
+	default:
+		summary, err = h.paymentsService.SummarizeDirectory(params[0])
+	}
+	if err != nil {
+		h.serveServiceError(w, r, err)
+		return
+	}
+	h.serveJSON(w, r, http.StatusOK, summary)
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/matiasinsaurralde/product-services/payment"
+)
+
+func TestSummary(t *testing.T) {
+	ts := httptest.NewServer(NewHandlerWithService(testPaymentsService()))
+	defer ts.Close()
+
+	cases := []struct {
+		path       string
+		statusCode int
+		count      int
+		sum        int64
+	}{
+		{"/20220717/090000.payments/summary", 200, 2, 110000},
+		{"/20220718/summary", 200, 2, 450000},
+		{"/payments/summary?from=20220717000000&to=20220718235959", 200, 4, 560000},
+		{"/payments/summary?from=20220718000000&to=20220717000000", 400, 0, 0},
+		{"/20220717/100000.payments/summary", 404, 0, 0},
+		{"/xyz/summary", 400, 0, 0},
+		{"/20220717/090000.payments/summary/x", 404, 0, 0},
+	}
+	for _, c := range cases {
+		res, err := http.Get(ts.URL + c.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != c.statusCode {
+			t.Fatalf("invalid status code for %s, got %d, expected %d", c.path, res.StatusCode, c.statusCode)
+		}
+		if c.statusCode != 200 {
+			res.Body.Close()
+			continue
+		}
+		var summary payment.Summary
+		err = json.NewDecoder(res.Body).Decode(&summary)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if summary.Count != c.count {
+			t.Fatalf("invalid count for %s, got %d, expected %d", c.path, summary.Count, c.count)
+		}
+		if sum := summary.Currencies["USD"].Sum.Minor; sum != c.sum {
+			t.Fatalf("invalid sum for %s, got %d, expected %d", c.path, sum, c.sum)
+		}
+	}
+}
@@ -0,0 +1,446 @@
+// Package config loads the settings of the server binary from defaults, a YAML or JSON file,
+// environment variables and command line flags, in increasing order of precedence.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/matiasinsaurralde/product-services/payment"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// envPrefix is prepended to the environment variable of every setting:
+	envPrefix = "PAYMENTS_"
+	// configFileSetting selects the config file, it can't be set from the file itself:
+	configFileSetting = "config"
+)
+
+// Log levels accepted by LogLevel:
+const (
+	LogDebug = "debug"
+	LogInfo  = "info"
+	LogError = "error"
+)
+
+// Parse modes accepted by ParseMode:
+const (
+	ParseLenient = "lenient"
+	ParseStrict  = "strict"
+)
+
+// Config holds the settings of the server binary
+type Config struct {
+	// ListenAddr is the TCP address of the HTTP server, like :9999
+	ListenAddr string `json:"listenAddr" yaml:"listenAddr"`
+	// DataDir is the directory containing the YYYYMMDD directories:
+	DataDir string `json:"dataDir" yaml:"dataDir"`
+	// TimeZone is the IANA name of the time zone of the data directory, UTC when empty:
+	TimeZone string `json:"timeZone" yaml:"timeZone"`
+	// ParseMode is either lenient or strict, see payment.ParseMode:
+	ParseMode string `json:"parseMode" yaml:"parseMode"`
+	// LocationPolicy is either warn, reject or override, see payment.LocationPolicy:
+	LocationPolicy string `json:"locationPolicy" yaml:"locationPolicy"`
+	// DefaultCurrency is the ISO 4217 code used for rows without a currency column:
+	DefaultCurrency string `json:"defaultCurrency" yaml:"defaultCurrency"`
+	// CacheSize bounds the memory used by parsed payments files in bytes, zero disables the cache:
+	CacheSize int64 `json:"cacheSize" yaml:"cacheSize"`
+	// StaleAfter makes /readyz fail when there are no newer payments files, zero disables the check:
+	StaleAfter Duration `json:"staleAfter" yaml:"staleAfter"`
+	// LogLevel is debug, info or error:
+	LogLevel string `json:"logLevel" yaml:"logLevel"`
+	// Timeouts configure the HTTP server:
+	Timeouts Timeouts `json:"timeouts" yaml:"timeouts"`
+	// TLS enables HTTPS when a certificate is set:
+	TLS TLS `json:"tls" yaml:"tls"`
+	// Auth enables authentication when a credentials file is set:
+	Auth Auth `json:"auth" yaml:"auth"`
+}
+
+// Timeouts holds the timeouts of the HTTP server, zero disables a timeout
+type Timeouts struct {
+	ReadHeader Duration `json:"readHeader" yaml:"readHeader"`
+	Read       Duration `json:"read" yaml:"read"`
+	Write      Duration `json:"write" yaml:"write"`
+	Idle       Duration `json:"idle" yaml:"idle"`
+	// Shutdown bounds the time spent waiting for active requests when the server stops:
+	Shutdown Duration `json:"shutdown" yaml:"shutdown"`
+}
+
+// TLS holds the certificate used to serve HTTPS, the files are reloaded when they change
+type TLS struct {
+	CertFile string `json:"certFile" yaml:"certFile"`
+	KeyFile  string `json:"keyFile" yaml:"keyFile"`
+	// ClientCAFile is a PEM bundle used to verify client certificates, they aren't requested when empty:
+	ClientCAFile string `json:"clientCAFile" yaml:"clientCAFile"`
+	// RequireClientCert rejects connections without a valid client certificate:
+	RequireClientCert bool `json:"requireClientCert" yaml:"requireClientCert"`
+}
+
+// Enabled reports whether HTTPS is configured
+func (t TLS) Enabled() bool {
+	return t.CertFile != ""
+}
+
+// Auth holds the credentials accepted by the API, every request is allowed when none is set
+type Auth struct {
+	// APIKeysFile is a YAML or JSON file listing hashed API keys, client certificates and their permissions:
+	APIKeysFile string `json:"apiKeysFile" yaml:"apiKeysFile"`
+	// HMACKeysFile is a YAML or JSON file listing the secrets used to sign requests and their permissions:
+	HMACKeysFile string `json:"hmacKeysFile" yaml:"hmacKeysFile"`
+	// HMACMaxSkew is the clock difference tolerated between clients signing requests and the server:
+	HMACMaxSkew Duration `json:"hmacMaxSkew" yaml:"hmacMaxSkew"`
+	// JWKSFile is a JSON Web Key Set used to verify JWT bearer tokens, they also need JWTIssuer and JWTAudience:
+	JWKSFile    string `json:"jwksFile" yaml:"jwksFile"`
+	JWTIssuer   string `json:"jwtIssuer" yaml:"jwtIssuer"`
+	JWTAudience string `json:"jwtAudience" yaml:"jwtAudience"`
+}
+
+// AuthEnabled reports whether authentication is configured, client certificates are authenticated too
+// so they are also checked against the permissions of the API keys file
+func (c *Config) AuthEnabled() bool {
+	return c.Auth.APIKeysFile != "" || c.Auth.HMACKeysFile != "" || c.Auth.JWKSFile != "" || c.TLS.ClientCAFile != ""
+}
+
+// Duration is a time.Duration written like 30s or 1h30m in config files
+type Duration time.Duration
+
+// MarshalText satisfies encoding.TextMarshaler
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText satisfies encoding.TextUnmarshaler
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Default returns the settings used when nothing else is configured,
+// the data directory is the "data" subdirectory of the working directory
+func Default() *Config {
+	dataDir := "data"
+	if cwd, err := os.Getwd(); err == nil {
+		dataDir = filepath.Join(cwd, dataDir)
+	}
+	return &Config{
+		ListenAddr:      ":9999",
+		DataDir:         dataDir,
+		ParseMode:       ParseLenient,
+		LocationPolicy:  payment.LocationWarn.String(),
+		DefaultCurrency: payment.DefaultCurrency,
+		CacheSize:       64 << 20,
+		LogLevel:        LogInfo,
+		Timeouts: Timeouts{
+			ReadHeader: Duration(10 * time.Second),
+			Read:       Duration(time.Minute),
+			Write:      Duration(10 * time.Minute),
+			Idle:       Duration(2 * time.Minute),
+			Shutdown:   Duration(30 * time.Second),
+		},
+		Auth: Auth{
+			HMACMaxSkew: Duration(5 * time.Minute),
+		},
+	}
+}
+
+// setting describes a value that can be set with a flag and an environment variable
+type setting struct {
+	// name is the flag name, the environment variable is derived from it: listen-addr is PAYMENTS_LISTEN_ADDR
+	name  string
+	usage string
+	set   func(c *Config, value string) error
+}
+
+// envName returns the environment variable of a setting
+func (s setting) envName() string {
+	return envPrefix + strings.ToUpper(strings.Replace(s.name, "-", "_", -1))
+}
+
+// settings lists everything that can be set with flags and environment variables
+var settings = []setting{
+	{"listen-addr", "TCP address of the HTTP server", stringSetting(func(c *Config) *string { return &c.ListenAddr })},
+	{"data-dir", "directory containing the YYYYMMDD directories", stringSetting(func(c *Config) *string { return &c.DataDir })},
+	{"tz", "time zone of the data directory, like America/Asuncion", stringSetting(func(c *Config) *string { return &c.TimeZone })},
+	{"parse-mode", "handling of invalid rows: lenient or strict", stringSetting(func(c *Config) *string { return &c.ParseMode })},
+	{"location-policy", "handling of rows outside their file location: warn, reject or override", stringSetting(func(c *Config) *string { return &c.LocationPolicy })},
+	{"default-currency", "ISO 4217 code used for rows without a currency column", stringSetting(func(c *Config) *string { return &c.DefaultCurrency })},
+	{"cache-size", "memory used by parsed payments files in bytes, 0 disables the cache", func(c *Config, value string) error {
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		c.CacheSize = v
+		return nil
+	}},
+	{"stale-after", "readiness fails when there are no newer payments files, 0 disables the check", durationSetting(func(c *Config) *Duration { return &c.StaleAfter })},
+	{"log-level", "log level: debug, info or error", stringSetting(func(c *Config) *string { return &c.LogLevel })},
+	{"read-header-timeout", "time allowed to read request headers", durationSetting(func(c *Config) *Duration { return &c.Timeouts.ReadHeader })},
+	{"read-timeout", "time allowed to read requests, including the body", durationSetting(func(c *Config) *Duration { return &c.Timeouts.Read })},
+	{"write-timeout", "time allowed to write responses", durationSetting(func(c *Config) *Duration { return &c.Timeouts.Write })},
+	{"idle-timeout", "time keep-alive connections are kept open between requests", durationSetting(func(c *Config) *Duration { return &c.Timeouts.Idle })},
+	{"shutdown-timeout", "time allowed for active requests when the server stops", durationSetting(func(c *Config) *Duration { return &c.Timeouts.Shutdown })},
+	{"tls-cert", "TLS certificate file, enables HTTPS", stringSetting(func(c *Config) *string { return &c.TLS.CertFile })},
+	{"tls-key", "TLS private key file", stringSetting(func(c *Config) *string { return &c.TLS.KeyFile })},
+	{"tls-client-ca", "PEM bundle used to verify client certificates", stringSetting(func(c *Config) *string { return &c.TLS.ClientCAFile })},
+	{"tls-require-client-cert", "reject connections without a valid client certificate", boolSetting(func(c *Config) *bool { return &c.TLS.RequireClientCert })},
+	{"api-keys-file", "YAML or JSON file of hashed API keys, enables authentication", stringSetting(func(c *Config) *string { return &c.Auth.APIKeysFile })},
+	{"hmac-keys-file", "YAML or JSON file of request signing secrets, enables authentication", stringSetting(func(c *Config) *string { return &c.Auth.HMACKeysFile })},
+	{"hmac-max-skew", "clock difference tolerated for signed requests", durationSetting(func(c *Config) *Duration { return &c.Auth.HMACMaxSkew })},
+	{"jwks-file", "JSON Web Key Set used to verify JWT bearer tokens, enables authentication", stringSetting(func(c *Config) *string { return &c.Auth.JWKSFile })},
+	{"jwt-issuer", "issuer expected in JWT bearer tokens", stringSetting(func(c *Config) *string { return &c.Auth.JWTIssuer })},
+	{"jwt-audience", "audience expected in JWT bearer tokens", stringSetting(func(c *Config) *string { return &c.Auth.JWTAudience })},
+}
+
+// stringSetting is a helper that builds the setter of a string setting
+func stringSetting(field func(c *Config) *string) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}
+}
+
+// boolSetting is a helper that builds the setter of a boolean setting
+func boolSetting(field func(c *Config) *bool) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = v
+		return nil
+	}
+}
+
+// durationSetting is a helper that builds the setter of a duration setting
+func durationSetting(field func(c *Config) *Duration) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		return field(c).UnmarshalText([]byte(value))
+	}
+}
+
+// Options are the command line options that aren't settings
+type Options struct {
+	// ConfigFile is the path of the YAML or JSON config file, if any:
+	ConfigFile string
+	// PrintConfig asks to print the resulting config and exit:
+	PrintConfig bool
+}
+
+// Load builds the config from defaults, the config file, environment variables and args, in increasing order of precedence.
+// The config file is set with -config or PAYMENTS_CONFIG, lookupEnv is usually os.LookupEnv.
+// flag.ErrHelp is returned when -h is passed, the config is validated, see Validate.
+func Load(name string, args []string, lookupEnv func(string) (string, bool)) (*Config, *Options, error) {
+	options := &Options{}
+	flagSet := flag.NewFlagSet(name, flag.ContinueOnError)
+	flagSet.StringVar(&options.ConfigFile, configFileSetting, "", "YAML or JSON config file (env "+envPrefix+"CONFIG)")
+	flagSet.BoolVar(&options.PrintConfig, "print-config", false, "print the resulting config and exit")
+	// Flags are stored as strings and applied after the config file and the environment variables:
+	flagValues := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		flagValues[s.name] = flagSet.String(s.name, "", fmt.Sprintf("%s (env %s)", s.usage, s.envName()))
+	}
+	if err := flagSet.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	if flagSet.NArg() > 0 {
+		return nil, nil, fmt.Errorf("unexpected arguments: %s", strings.Join(flagSet.Args(), " "))
+	}
+	if options.ConfigFile == "" {
+		options.ConfigFile, _ = lookupEnv(envPrefix + "CONFIG")
+	}
+
+	c := Default()
+	if options.ConfigFile != "" {
+		if err := c.loadFile(options.ConfigFile); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, s := range settings {
+		value, ok := lookupEnv(s.envName())
+		if !ok {
+			continue
+		}
+		if err := s.set(c, value); err != nil {
+			return nil, nil, fmt.Errorf("invalid %s: %s", s.envName(), err.Error())
+		}
+	}
+	var flagErr error
+	flagSet.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.name != f.Name || flagErr != nil {
+				continue
+			}
+			if err := s.set(c, *flagValues[s.name]); err != nil {
+				flagErr = fmt.Errorf("invalid -%s: %s", s.name, err.Error())
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, nil, flagErr
+	}
+	if err := c.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return c, options, nil
+}
+
+// loadFile is a helper that reads a config file on top of the current settings,
+// files ending with .json are read as JSON, everything else as YAML. Unknown keys are rejected.
+func (c *Config) loadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("couldn't read config file: %w", err)
+	}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(c)
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(c)
+		// Empty files are valid:
+		if err == io.EOF {
+			err = nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("invalid config file '%s': %s", path, err.Error())
+	}
+	return nil
+}
+
+// ValidationError lists every invalid setting
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid config:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+// Validate checks every setting and returns a *ValidationError listing all the problems
+func (c *Config) Validate() error {
+	var problems []string
+	addProblem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	if _, _, err := net.SplitHostPort(c.ListenAddr); err != nil {
+		addProblem("listenAddr: %s", err.Error())
+	}
+	if c.DataDir == "" {
+		addProblem("dataDir: must be set")
+	} else if fi, err := os.Stat(c.DataDir); err != nil {
+		addProblem("dataDir: %s", err.Error())
+	} else if !fi.IsDir() {
+		addProblem("dataDir: '%s' isn't a directory", c.DataDir)
+	}
+	if _, err := time.LoadLocation(c.TimeZone); err != nil {
+		addProblem("timeZone: %s", err.Error())
+	}
+	if c.ParseMode != ParseLenient && c.ParseMode != ParseStrict {
+		addProblem("parseMode: expected %s or %s, got '%s'", ParseLenient, ParseStrict, c.ParseMode)
+	}
+	if _, err := payment.ParseLocationPolicy(c.LocationPolicy); err != nil {
+		addProblem("locationPolicy: %s", err.Error())
+	}
+	if _, err := payment.CurrencyExponent(c.DefaultCurrency); err != nil {
+		addProblem("defaultCurrency: %s", err.Error())
+	}
+	if c.CacheSize < 0 {
+		addProblem("cacheSize: can't be negative")
+	}
+	switch c.LogLevel {
+	case LogDebug, LogInfo, LogError:
+	default:
+		addProblem("logLevel: expected %s, %s or %s, got '%s'", LogDebug, LogInfo, LogError, c.LogLevel)
+	}
+	for _, d := range []struct {
+		name  string
+		value Duration
+	}{
+		{"staleAfter", c.StaleAfter},
+		{"timeouts.readHeader", c.Timeouts.ReadHeader},
+		{"timeouts.read", c.Timeouts.Read},
+		{"timeouts.write", c.Timeouts.Write},
+		{"timeouts.idle", c.Timeouts.Idle},
+		{"timeouts.shutdown", c.Timeouts.Shutdown},
+		{"auth.hmacMaxSkew", c.Auth.HMACMaxSkew},
+	} {
+		if d.value < 0 {
+			addProblem("%s: can't be negative", d.name)
+		}
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		addProblem("tls: certFile and keyFile must be set together")
+	}
+	if c.TLS.ClientCAFile != "" && !c.TLS.Enabled() {
+		addProblem("tls: clientCAFile requires certFile and keyFile")
+	}
+	if c.TLS.RequireClientCert && c.TLS.ClientCAFile == "" {
+		addProblem("tls: requireClientCert requires clientCAFile")
+	}
+	if c.Auth.JWKSFile != "" && (c.Auth.JWTIssuer == "" || c.Auth.JWTAudience == "") {
+		addProblem("auth: jwksFile requires jwtIssuer and jwtAudience")
+	}
+	for _, f := range []struct{ name, path string }{
+		{"tls.certFile", c.TLS.CertFile},
+		{"tls.keyFile", c.TLS.KeyFile},
+		{"tls.clientCAFile", c.TLS.ClientCAFile},
+		{"auth.apiKeysFile", c.Auth.APIKeysFile},
+		{"auth.hmacKeysFile", c.Auth.HMACKeysFile},
+		{"auth.jwksFile", c.Auth.JWKSFile},
+	} {
+		if f.path == "" {
+			continue
+		}
+		if _, err := os.Stat(f.path); err != nil {
+			addProblem("%s: %s", f.name, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// Location returns the time zone of the data directory, the config must be valid
+func (c *Config) Location() *time.Location {
+	loc, _ := time.LoadLocation(c.TimeZone)
+	return loc
+}
+
+// PaymentsParseMode returns the parse mode of the payments service, the config must be valid
+func (c *Config) PaymentsParseMode() payment.ParseMode {
+	if c.ParseMode == ParseStrict {
+		return payment.ParseStrict
+	}
+	return payment.ParseLenient
+}
+
+// PaymentsLocationPolicy returns the location policy of the payments service, the config must be valid
+func (c *Config) PaymentsLocationPolicy() payment.LocationPolicy {
+	policy, _ := payment.ParseLocationPolicy(c.LocationPolicy)
+	return policy
+}
+
+// YAML renders the config as YAML, it's used by -print-config
+func (c *Config) YAML() ([]byte, error) {
+	return yaml.Marshal(c)
+}
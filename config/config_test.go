@@ -0,0 +1,178 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testEnv is a helper that builds a lookupEnv function from a map
+func testEnv(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+}
+
+// writeTestFile is a helper that writes a file in a temporary directory and returns its path
+func writeTestFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestLoad covers the precedence of defaults, config files, environment variables and flags
+func TestLoad(t *testing.T) {
+	dataDir := t.TempDir()
+	yamlFile := writeTestFile(t, "config.yaml", `
+listenAddr: ":8000"
+dataDir: "`+dataDir+`"
+cacheSize: 1024
+timeouts:
+  read: 5s
+`)
+	jsonFile := writeTestFile(t, "config.json", `{"listenAddr": ":8001", "dataDir": "`+dataDir+`", "logLevel": "debug"}`)
+
+	t.Run("defaults", func(t *testing.T) {
+		c, options, err := Load("test", []string{"-data-dir", dataDir}, testEnv(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.ListenAddr != ":9999" || c.CacheSize != 64<<20 || c.DefaultCurrency != "USD" || options.PrintConfig {
+			t.Fatalf("invalid defaults %+v", c)
+		}
+		if time.Duration(c.Timeouts.Shutdown) != 30*time.Second {
+			t.Fatalf("invalid shutdown timeout, got %s", time.Duration(c.Timeouts.Shutdown))
+		}
+	})
+
+	t.Run("yaml file", func(t *testing.T) {
+		c, _, err := Load("test", []string{"-config", yamlFile}, testEnv(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.ListenAddr != ":8000" || c.CacheSize != 1024 || time.Duration(c.Timeouts.Read) != 5*time.Second {
+			t.Fatalf("invalid config %+v", c)
+		}
+		// Settings missing from the file keep their defaults:
+		if time.Duration(c.Timeouts.Write) != 10*time.Minute {
+			t.Fatalf("invalid write timeout, got %s", time.Duration(c.Timeouts.Write))
+		}
+	})
+
+	t.Run("json file from environment", func(t *testing.T) {
+		c, options, err := Load("test", nil, testEnv(map[string]string{"PAYMENTS_CONFIG": jsonFile}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.ListenAddr != ":8001" || c.LogLevel != LogDebug || options.ConfigFile != jsonFile {
+			t.Fatalf("invalid config %+v", c)
+		}
+	})
+
+	t.Run("precedence", func(t *testing.T) {
+		env := testEnv(map[string]string{
+			"PAYMENTS_LISTEN_ADDR":      ":8002",
+			"PAYMENTS_CACHE_SIZE":       "2048",
+			"PAYMENTS_READ_TIMEOUT":     "7s",
+			"PAYMENTS_DEFAULT_CURRENCY": "EUR",
+		})
+		c, options, err := Load("test", []string{"-config", yamlFile, "--listen-addr", ":8003", "--print-config"}, env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.ListenAddr != ":8003" {
+			t.Fatalf("flags should override the environment, got '%s'", c.ListenAddr)
+		}
+		if c.CacheSize != 2048 || time.Duration(c.Timeouts.Read) != 7*time.Second || c.DefaultCurrency != "EUR" {
+			t.Fatalf("the environment should override the file, got %+v", c)
+		}
+		if !options.PrintConfig {
+			t.Fatal("expected print config option")
+		}
+	})
+
+	t.Run("authentication", func(t *testing.T) {
+		c := Default()
+		if c.AuthEnabled() {
+			t.Fatal("authentication should be disabled by default")
+		}
+		// Client certificates are checked against the permissions of the API keys file:
+		c.TLS.ClientCAFile = "clients.pem"
+		if !c.AuthEnabled() {
+			t.Fatal("authentication should be enabled with a client CA")
+		}
+	})
+
+	t.Run("help", func(t *testing.T) {
+		_, _, err := Load("test", []string{"-h"}, testEnv(nil))
+		if !errors.Is(err, flag.ErrHelp) {
+			t.Fatalf("expected flag.ErrHelp, got %v", err)
+		}
+	})
+}
+
+// TestLoadErrors covers invalid files, values and settings
+func TestLoadErrors(t *testing.T) {
+	dataDir := t.TempDir()
+	cases := []struct {
+		name     string
+		args     []string
+		env      map[string]string
+		expected string
+	}{
+		{"unknown yaml key", []string{"-config", writeTestFile(t, "c.yaml", "listen: :80")}, nil, "field listen not found"},
+		{"unknown json key", []string{"-config", writeTestFile(t, "c.json", `{"listen": ":80"}`)}, nil, "unknown field"},
+		{"missing file", []string{"-config", filepath.Join(dataDir, "missing.yaml")}, nil, "couldn't read config file"},
+		{"invalid duration", []string{"-data-dir", dataDir, "-read-timeout", "soon"}, nil, "invalid -read-timeout"},
+		{"invalid environment", []string{"-data-dir", dataDir}, map[string]string{"PAYMENTS_CACHE_SIZE": "big"}, "invalid PAYMENTS_CACHE_SIZE"},
+		{"invalid currency", []string{"-data-dir", dataDir, "-default-currency", "XYZ"}, nil, "defaultCurrency: unsupported currency 'XYZ'"},
+		{"extra arguments", []string{"-data-dir", dataDir, "serve"}, nil, "unexpected arguments"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, _, err := Load("test", c.args, testEnv(c.env))
+			if err == nil || !strings.Contains(err.Error(), c.expected) {
+				t.Fatalf("expected error containing '%s', got %v", c.expected, err)
+			}
+		})
+	}
+}
+
+// TestValidate covers the problems reported by Validate
+func TestValidate(t *testing.T) {
+	c := Default()
+	c.ListenAddr = "9999"
+	c.DataDir = writeTestFile(t, "file", "")
+	c.TimeZone = "Nowhere/City"
+	c.ParseMode = "loose"
+	c.LocationPolicy = "ignore"
+	c.DefaultCurrency = "usd"
+	c.CacheSize = -1
+	c.LogLevel = "verbose"
+	c.Timeouts.Idle = Duration(-time.Second)
+	c.TLS.CertFile = "cert.pem"
+	c.TLS.RequireClientCert = true
+	c.Auth.APIKeysFile = "keys.yaml"
+	c.Auth.HMACKeysFile = "hmac.yaml"
+	c.Auth.JWKSFile = "jwks.json"
+	err := c.Validate()
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected *ValidationError, got %v", err)
+	}
+	for i, prefix := range []string{"listenAddr", "dataDir", "timeZone", "parseMode", "locationPolicy", "defaultCurrency", "cacheSize", "logLevel", "timeouts.idle", "tls: certFile and keyFile", "tls: requireClientCert", "auth: jwksFile", "tls.certFile", "auth.apiKeysFile", "auth.hmacKeysFile", "auth.jwksFile"} {
+		if i >= len(validationErr.Problems) || !strings.HasPrefix(validationErr.Problems[i], prefix) {
+			t.Fatalf("expected problem %d to start with '%s', got %v", i, prefix, validationErr.Problems)
+		}
+	}
+	if err := Default().Validate(); err != nil && strings.Contains(err.Error(), "listenAddr") {
+		t.Fatalf("default listen address should be valid, got %v", err)
+	}
+}
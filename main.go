@@ -1,35 +1,149 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/matiasinsaurralde/product-services/api"
-)
-
-const (
-	defaultListenAddr = ":9999"
+	"github.com/matiasinsaurralde/product-services/config"
+	"github.com/matiasinsaurralde/product-services/payment"
+	"github.com/matiasinsaurralde/product-services/server"
 )
 
 func main() {
+	// Settings come from defaults, the config file, PAYMENTS_* environment variables and flags:
+	cfg, options, err := config.Load(os.Args[0], os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if options.PrintConfig {
+		output, err := cfg.YAML()
+		if err != nil {
+			log.Fatalf("error: %s\n", err)
+		}
+		os.Stdout.Write(output)
+		return
+	}
+	setLogLevel(cfg.LogLevel)
+
 	log.Println("Initializing payments service")
-	// By default grab the current working directory
-	// and use the "data" subdirectory as payment service base path:
-	cwd, err := os.Getwd()
+	log.Printf("Setting data directory to '%s'\n", cfg.DataDir)
+	paymentsService, err := payment.NewWithBaseDir(cfg.DataDir)
 	if err != nil {
-		panic(err)
+		log.Fatalf("error: %s\n", err)
+	}
+	paymentsService.ParseMode = cfg.PaymentsParseMode()
+	paymentsService.LocationPolicy = cfg.PaymentsLocationPolicy()
+	paymentsService.DefaultCurrency = cfg.DefaultCurrency
+	paymentsService.TimeZone = cfg.Location()
+	if cfg.CacheSize > 0 {
+		paymentsService.Cache = payment.NewCache(cfg.CacheSize)
 	}
-	dataPath := filepath.Join(cwd, "data")
-	log.Printf("Setting data directory to '%s'\n", dataPath)
 
 	// Initialize the API and start the HTTP server:
-	apiHandler, err := api.NewHandler(dataPath)
-	if err != nil {
-		log.Fatal(err)
+	apiHandler := api.NewHandlerWithService(paymentsService)
+	apiHandler.StaleAfter = time.Duration(cfg.StaleAfter)
+	var handler http.Handler = apiHandler
+	if cfg.AuthEnabled() {
+		handler = requireAuth(apiHandler, cfg)
+	}
+	if cfg.LogLevel == config.LogDebug {
+		handler = logRequests(handler)
+	}
+	// Verified client certificates become the identity of their requests:
+	handler = api.ClientCertificateIdentity(handler)
+	// SIGINT and SIGTERM start a graceful shutdown, a second signal stops the process right away:
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	srv := server.New(cfg, handler)
+	// Event streams and the events watcher are stopped as soon as the shutdown starts:
+	srv.RegisterOnShutdown(apiHandler)
+	log.Printf("Listening on '%s'\n", cfg.ListenAddr)
+	if err := srv.ListenAndServe(ctx); err != nil {
+		log.Fatalf("error: %s\n", err)
+	}
+	log.Println("Server stopped")
+}
+
+// requireAuth is a helper that loads the configured credentials and wraps the API handler with their authentication
+func requireAuth(apiHandler *api.Handler, c *config.Config) http.Handler {
+	cfg := c.Auth
+	var authenticators []api.Authenticator
+	if c.TLS.ClientCAFile != "" && cfg.APIKeysFile == "" {
+		log.Println("Client certificates don't grant any permission without an API keys file listing them")
+	}
+	if cfg.APIKeysFile != "" {
+		keys, err := api.LoadAPIKeys(cfg.APIKeysFile)
+		if err != nil {
+			log.Fatalf("error: %s\n", err)
+		}
+		authenticators = append(authenticators, keys)
 	}
-	if err := http.ListenAndServe(defaultListenAddr, apiHandler); err != nil {
-		log.Fatal(err)
+	if cfg.HMACKeysFile != "" {
+		hmacAuth, err := api.LoadHMACKeys(cfg.HMACKeysFile)
+		if err != nil {
+			log.Fatalf("error: %s\n", err)
+		}
+		hmacAuth.MaxSkew = time.Duration(cfg.HMACMaxSkew)
+		authenticators = append(authenticators, hmacAuth)
 	}
+	if cfg.JWKSFile != "" {
+		jwtAuth, err := api.LoadJWTAuth(cfg.JWKSFile, cfg.JWTIssuer, cfg.JWTAudience)
+		if err != nil {
+			log.Fatalf("error: %s\n", err)
+		}
+		authenticators = append(authenticators, jwtAuth)
+	}
+	log.Println("Authentication is enabled")
+	return apiHandler.RequireAuth(authenticators...)
+}
+
+// setLogLevel is a helper that filters the standard logger,
+// the error level only keeps messages logged with an "error: " prefix
+func setLogLevel(level string) {
+	if level == config.LogError {
+		log.SetOutput(&errorFilter{w: os.Stderr})
+	}
+}
+
+// errorFilter only writes log lines containing error messages
+type errorFilter struct {
+	w io.Writer
+}
+
+func (f *errorFilter) Write(p []byte) (int, error) {
+	if !bytes.Contains(p, []byte("error: ")) {
+		return len(p), nil
+	}
+	return f.w.Write(p)
+}
+
+// logRequests is a helper that logs every request, it's used by the debug level
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		client := "-"
+		if identity, ok := api.IdentityFromContext(r.Context()); ok {
+			client = identity.Name
+		}
+		log.Printf("%s %s %s %s\n", client, r.Method, r.URL.RequestURI(), time.Since(start))
+	})
 }
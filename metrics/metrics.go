@@ -0,0 +1,301 @@
+// Package metrics implements counters, gauges and histograms
+// exposed in the Prometheus text format, without external dependencies.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// contentType is the media type of the Prometheus text format
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are the upper bounds used for latencies, in seconds:
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// metric is implemented by every metric type that can be registered
+type metric interface {
+	// write renders the samples of the metric, without the HELP and TYPE lines:
+	write(w *bufio.Writer)
+}
+
+// family is a registered metric along with its metadata
+type family struct {
+	name, help, kind string
+	metric           metric
+}
+
+// Registry holds the registered metrics and renders them, it implements http.Handler
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+}
+
+// NewRegistry initializes an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// register is a helper that adds a metric to the registry, names must be unique
+func (r *Registry) register(name, help, kind string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, f := range r.families {
+		if f.name == name {
+			panic(fmt.Sprintf("metrics: duplicate metric '%s'", name))
+		}
+	}
+	r.families = append(r.families, family{name: name, help: help, kind: kind, metric: m})
+}
+
+// NewCounter registers a counter with the given label names
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{vec: newVec(name, labels)}
+	r.register(name, help, "counter", c)
+	return c
+}
+
+// NewGaugeFunc registers a gauge whose value is computed by fn every time the registry is rendered
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(name, help, "gauge", gaugeFunc{name: name, fn: fn})
+}
+
+// NewHistogram registers a histogram with the given bucket upper bounds and label names
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &Histogram{vec: newVec(name, labels), buckets: buckets}
+	r.register(name, help, "histogram", h)
+	return h
+}
+
+// WriteTo renders all the registered metrics in the Prometheus text format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := append([]family(nil), r.families...)
+	r.mu.Unlock()
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, f := range families {
+		fmt.Fprintf(bw, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", f.name, f.kind)
+		f.metric.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP renders all the registered metrics
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("content-type", contentType)
+	r.WriteTo(w)
+}
+
+// vec keeps one series per combination of label values
+type vec struct {
+	name   string
+	labels []string
+	mu     sync.Mutex
+	series map[string]interface{}
+}
+
+// newVec is a helper that initializes a vec
+func newVec(name string, labels []string) vec {
+	return vec{name: name, labels: labels, series: make(map[string]interface{})}
+}
+
+// get is a helper that returns the series for the given label values, creating it with init when needed.
+// The caller must hold v.mu.
+func (v *vec) get(values []string, init func() interface{}) interface{} {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := formatLabels(v.labels, values)
+	s, ok := v.series[key]
+	if !ok {
+		s = init()
+		v.series[key] = s
+	}
+	return s
+}
+
+// keys is a helper that returns the label sets of all series in a stable order.
+// The caller must hold v.mu.
+func (v *vec) keys() []string {
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Counter is a monotonically increasing value, partitioned by labels
+type Counter struct {
+	vec
+}
+
+// Inc increments the series identified by the label values by one
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add increments the series identified by the label values, negative deltas are ignored
+func (c *Counter) Add(delta float64, values ...string) {
+	if delta < 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value := c.get(values, func() interface{} { return new(float64) }).(*float64)
+	*value += delta
+}
+
+// Value returns the current value of the series identified by the label values
+func (c *Counter) Value(values ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if value, ok := c.series[formatLabels(c.labels, values)]; ok {
+		return *value.(*float64)
+	}
+	return 0
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range c.keys() {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, key, formatFloat(*c.series[key].(*float64)))
+	}
+}
+
+// gaugeFunc is a gauge computed at render time
+type gaugeFunc struct {
+	name string
+	fn   func() float64
+}
+
+func (g gaugeFunc) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.fn()))
+}
+
+// Histogram counts observations in cumulative buckets, partitioned by labels
+type Histogram struct {
+	vec
+	buckets []float64
+}
+
+// histogramSeries holds the state of a single histogram series
+type histogramSeries struct {
+	// counts has one entry per bucket, the +Inf bucket is count:
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Observe adds a value to the series identified by the label values
+func (h *Histogram) Observe(value float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.get(values, func() interface{} {
+		return &histogramSeries{counts: make([]uint64, len(h.buckets))}
+	}).(*histogramSeries)
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+// Count returns the number of observations of the series identified by the label values
+func (h *Histogram) Count(values ...string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.series[formatLabels(h.labels, values)]; ok {
+		return s.(*histogramSeries).count
+	}
+	return 0
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range h.keys() {
+		s := h.series[key].(*histogramSeries)
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, withLabel(key, "le", formatFloat(bound)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, withLabel(key, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, key, formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, key, s.count)
+	}
+}
+
+// formatLabels is a helper that renders a label set like {route="payment",code="200"}
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = name + `="` + escapeLabel(value) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// withLabel is a helper that appends a label to a rendered label set
+func withLabel(labels, name, value string) string {
+	pair := name + `="` + escapeLabel(value) + `"`
+	if labels == "" {
+		return "{" + pair + "}"
+	}
+	return labels[:len(labels)-1] + "," + pair + "}"
+}
+
+// labelEscaper and helpEscaper implement the escaping rules of the text format
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+func escapeHelp(s string) string { return helpEscaper.Replace(s) }
+
+// formatFloat is a helper that renders sample values the way Prometheus does
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// countingWriter is a helper that counts the bytes written by WriteTo
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
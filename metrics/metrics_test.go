@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestRegistry covers the text format rendered by Registry
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+	requests := registry.NewCounter("requests_total", "Number of requests.", "route", "code")
+	latency := registry.NewHistogram("latency_seconds", "Request latency.", []float64{1, 0.1}, "route")
+	registry.NewGaugeFunc("files", "Number of files.", func() float64 { return 3 })
+
+	requests.Inc("payment", "200")
+	requests.Inc("payment", "200")
+	requests.Add(5, "dir", "404")
+	requests.Add(-1, "dir", "404")
+	requests.Inc(`a"b`, "500")
+	latency.Observe(0.05, "payment")
+	latency.Observe(0.5, "payment")
+	latency.Observe(2, "payment")
+
+	t.Run("values", func(t *testing.T) {
+		if v := requests.Value("payment", "200"); v != 2 {
+			t.Fatalf("invalid counter value, got %v, expected %v", v, 2)
+		}
+		if v := requests.Value("dir", "404"); v != 5 {
+			t.Fatalf("invalid counter value, got %v, expected %v", v, 5)
+		}
+		if v := requests.Value("root", "200"); v != 0 {
+			t.Fatalf("invalid counter value, got %v, expected %v", v, 0)
+		}
+		if c := latency.Count("payment"); c != 3 {
+			t.Fatalf("invalid histogram count, got %d, expected %d", c, 3)
+		}
+	})
+
+	t.Run("render", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		registry.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		if ct := rec.Header().Get("content-type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+			t.Fatalf("invalid content type '%s'", ct)
+		}
+		expected := `# HELP requests_total Number of requests.
+# TYPE requests_total counter
+requests_total{route="a\"b",code="500"} 1
+requests_total{route="dir",code="404"} 5
+requests_total{route="payment",code="200"} 2
+# HELP latency_seconds Request latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="payment",le="0.1"} 1
+latency_seconds_bucket{route="payment",le="1"} 2
+latency_seconds_bucket{route="payment",le="+Inf"} 3
+latency_seconds_sum{route="payment"} 2.55
+latency_seconds_count{route="payment"} 3
+# HELP files Number of files.
+# TYPE files gauge
+files 3
+`
+		if rec.Body.String() != expected {
+			t.Fatalf("invalid output, got:\n%s\nexpected:\n%s", rec.Body.String(), expected)
+		}
+	})
+
+	t.Run("duplicate", func(t *testing.T) {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expected panic for duplicate metric")
+			}
+		}()
+		registry.NewCounter("requests_total", "Duplicate.")
+	})
+}
@@ -0,0 +1,164 @@
+package payment
+
+import (
+	"container/list"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// Cache is an in-process LRU cache of parsed payments files.
+// Entries are keyed by path and invalidated when the modification time or size of the file changes,
+// the total estimated memory used by the entries is bounded by MaxBytes.
+type Cache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	bytes    int64
+	entries  map[string]*list.Element
+	// lru keeps the most recently used entries at the front:
+	lru *list.List
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+// CacheStats holds the counters of a Cache
+type CacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Entries   int    `json:"entries"`
+	Bytes     int64  `json:"bytes"`
+	MaxBytes  int64  `json:"maxBytes"`
+}
+
+// cacheEntry is a single parsed file stored in the Cache
+type cacheEntry struct {
+	key     string
+	modTime time.Time
+	size    int64
+	cost    int64
+	result  *ParseResult
+}
+
+// NewCache initializes a Cache bounded to approximately maxBytes of parsed data
+func NewCache(maxBytes int64) *Cache {
+	return &Cache{
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// Get returns the cached result for a given key if the file modification time and size still match,
+// stale entries are dropped.
+func (c *Cache) Get(key string, modTime time.Time, size int64) (*ParseResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if !entry.modTime.Equal(modTime) || entry.size != size {
+		c.remove(elem)
+		c.misses++
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	c.hits++
+	return entry.result.copy(), true
+}
+
+// Put stores the result for a given key, evicting the least recently used entries when needed.
+// Results bigger than the whole cache aren't stored.
+func (c *Cache) Put(key string, modTime time.Time, size int64, result *ParseResult) {
+	cost := result.estimateSize()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	if cost > c.maxBytes {
+		return
+	}
+	for c.bytes+cost > c.maxBytes {
+		c.remove(c.lru.Back())
+		c.evictions++
+	}
+	entry := &cacheEntry{
+		key:     key,
+		modTime: modTime,
+		size:    size,
+		cost:    cost,
+		result:  result.copy(),
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	c.bytes += cost
+}
+
+// Purge drops all cached entries, counters are kept
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+	c.bytes = 0
+}
+
+// Stats returns a snapshot of the cache counters
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+		Entries:   len(c.entries),
+		Bytes:     c.bytes,
+		MaxBytes:  c.maxBytes,
+	}
+}
+
+// remove is a helper that drops an entry, the caller must hold the lock.
+func (c *Cache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.bytes -= entry.cost
+}
+
+// copy returns a copy of the result so callers can't modify cached slices,
+// payments are copied by value and their Extra maps are shared.
+func (r *ParseResult) copy() *ParseResult {
+	result := &ParseResult{
+		Payments:   append(make([]Payment, 0, len(r.Payments)), r.Payments...),
+		Rejected:   append(make([]RowError, 0, len(r.Rejected)), r.Rejected...),
+		Consistent: r.Consistent,
+	}
+	if r.Warnings != nil {
+		result.Warnings = append(make([]RowError, 0, len(r.Warnings)), r.Warnings...)
+	}
+	return result
+}
+
+// estimateSize returns an approximation of the memory used by a result
+func (r *ParseResult) estimateSize() int64 {
+	size := int64(unsafe.Sizeof(*r))
+	size += int64(cap(r.Payments)) * int64(unsafe.Sizeof(Payment{}))
+	for _, payment := range r.Payments {
+		size += int64(len(payment.Comment) + len(payment.Amount.Currency))
+		for k, v := range payment.Extra {
+			// Map entries have an overhead besides the strings themselves:
+			size += int64(len(k)+len(v)) + 2*int64(unsafe.Sizeof("")) + 16
+		}
+	}
+	for _, rowErrors := range [][]RowError{r.Rejected, r.Warnings} {
+		size += int64(cap(rowErrors)) * int64(unsafe.Sizeof(RowError{}))
+		for _, rowError := range rowErrors {
+			size += int64(len(rowError.Column) + len(rowError.Value) + len(rowError.Reason))
+		}
+	}
+	return size
+}
@@ -0,0 +1,87 @@
+package payment
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCache covers Cache hits, misses, invalidation and eviction
+func TestCache(t *testing.T) {
+	paymentsService, store := serviceWithMemoryStore()
+	store.AddFile("20220717", "090000.payments", []byte(testRawCSV))
+	paymentsService.Cache = NewCache(1 << 20)
+
+	getPayments := func(path string) []Payment {
+		payments, err := paymentsService.GetPayments(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return payments
+	}
+	assertStats := func(hits, misses uint64, entries int) {
+		stats := paymentsService.Cache.Stats()
+		if stats.Hits != hits || stats.Misses != misses || stats.Entries != entries {
+			t.Fatalf("unexpected cache stats %+v, expected %d hits, %d misses and %d entries", stats, hits, misses, entries)
+		}
+	}
+
+	getPayments("20220717/090000.payments")
+	assertStats(0, 1, 1)
+	payments := getPayments("20220717/090000.payments")
+	assertStats(1, 1, 1)
+	if err := testValidatePayment(&payments[0]); err != nil {
+		t.Fatal(err)
+	}
+	// Modifying returned payments shouldn't affect the cache:
+	payments[0].Sequence = 0
+	payments = getPayments("20220717/090000.payments")
+	if err := testValidatePayment(&payments[0]); err != nil {
+		t.Fatal(err)
+	}
+	assertStats(2, 1, 1)
+
+	t.Run("invalidation", func(t *testing.T) {
+		store.AddFile("20220717", "090000.payments", []byte(testRawCSV+"\n20220717,090000,212,600,payment3"))
+		payments := getPayments("20220717/090000.payments")
+		if len(payments) != 2 {
+			t.Fatalf("invalid payments length, got %d, expected %d", len(payments), 2)
+		}
+		assertStats(2, 2, 1)
+	})
+	t.Run("eviction", func(t *testing.T) {
+		result, err := paymentsService.parsePayments(strings.NewReader(testRawCSV))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cost := result.estimateSize()
+		// Only two entries fit in the cache:
+		cache := NewCache(cost*2 + cost/2)
+		modTime := time.Now()
+		cache.Put("a", modTime, 1, result)
+		cache.Put("b", modTime, 1, result)
+		// "a" becomes the most recently used entry, so "b" is evicted:
+		if _, ok := cache.Get("a", modTime, 1); !ok {
+			t.Fatal("expected cache hit")
+		}
+		cache.Put("c", modTime, 1, result)
+		if _, ok := cache.Get("b", modTime, 1); ok {
+			t.Fatal("expected cache miss")
+		}
+		for _, key := range []string{"a", "c"} {
+			if _, ok := cache.Get(key, modTime, 1); !ok {
+				t.Fatalf("expected cache hit for '%s'", key)
+			}
+		}
+		stats := cache.Stats()
+		if stats.Evictions != 1 || stats.Entries != 2 || stats.Bytes != cost*2 {
+			t.Fatalf("unexpected cache stats %+v", stats)
+		}
+		// Entries bigger than the cache are never stored:
+		tinyCache := NewCache(cost - 1)
+		tinyCache.Put("a", modTime, 1, result)
+		if tinyCache.Stats().Entries != 0 {
+			t.Fatal("oversized entry shouldn't be cached")
+		}
+	})
+}
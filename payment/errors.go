@@ -0,0 +1,75 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrInvalidName is matched by errors.Is when a directory or file name doesn't follow the expected layouts:
+	ErrInvalidName = errors.New("invalid name")
+
+	// ErrOutsideBaseDir is matched by errors.Is when a path resolves outside of the data directory:
+	ErrOutsideBaseDir = errors.New("path is outside of the base directory")
+
+	// errMissingHeader is returned when a payments file doesn't even contain the CSV header:
+	errMissingHeader = errors.New("missing CSV header")
+)
+
+// NameError is returned when a directory or file name is invalid
+type NameError struct {
+	// Kind is either "directory" or "file":
+	Kind string
+	Name string
+	Err  error
+}
+
+// Error satisfies the error interface
+func (e *NameError) Error() string {
+	return fmt.Sprintf("invalid %s name '%s': %s", e.Kind, e.Name, e.Err.Error())
+}
+
+// Is makes NameError match ErrInvalidName
+func (e *NameError) Is(target error) bool {
+	return target == ErrInvalidName
+}
+
+// Unwrap returns the underlying parsing error
+func (e *NameError) Unwrap() error {
+	return e.Err
+}
+
+// RowError describes a single CSV row (or field) that couldn't be parsed
+type RowError struct {
+	// Line is the line number of the row in the payments file, the header is line 1:
+	Line int `json:"line"`
+	// Column and Value are only set when the error is caused by a specific field:
+	Column string `json:"column,omitempty"`
+	Value  string `json:"value,omitempty"`
+	Reason string `json:"reason"`
+	// mismatch is set when the date or time doesn't match the file location:
+	mismatch bool
+}
+
+// Error satisfies the error interface
+func (e RowError) Error() string {
+	if e.Column == "" {
+		return fmt.Sprintf("invalid row at line %d: %s", e.Line, e.Reason)
+	}
+	return fmt.Sprintf("invalid %s field '%s' at line %d: %s", e.Column, e.Value, e.Line, e.Reason)
+}
+
+// ParseError is returned when a payments file contains invalid rows
+type ParseError struct {
+	Rows []RowError
+}
+
+// Error satisfies the error interface
+func (e *ParseError) Error() string {
+	reasons := make([]string, 0, len(e.Rows))
+	for _, row := range e.Rows {
+		reasons = append(reasons, row.Error())
+	}
+	return fmt.Sprintf("%d invalid rows: %s", len(e.Rows), strings.Join(reasons, "; "))
+}
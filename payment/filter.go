@@ -0,0 +1,215 @@
+package payment
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// maxExponent is the biggest number of minor unit digits in currencyExponents:
+	maxExponent = 3
+)
+
+// DecimalAmount is a decimal value without a currency, it's used to compare amounts across currencies.
+type DecimalAmount struct {
+	// scaled is the value multiplied by 10^maxExponent:
+	scaled int64
+}
+
+// ParseDecimalAmount parses a decimal string like "10.50", with up to 3 fractional digits
+func ParseDecimalAmount(s string) (DecimalAmount, error) {
+	scaled, err := parseDecimal(s, maxExponent)
+	if err != nil {
+		return DecimalAmount{}, err
+	}
+	return DecimalAmount{scaled: scaled}, nil
+}
+
+// Cmp compares the decimal value of the amount with d and returns -1, 0 or +1
+func (m Money) Cmp(d DecimalAmount) int {
+	exponent, err := CurrencyExponent(m.Currency)
+	if err != nil {
+		exponent = 0
+	}
+	// Compare m.Minor * 10^(maxExponent-exponent) with d.scaled without overflowing:
+	factor := int64(1)
+	for i := exponent; i < maxExponent; i++ {
+		factor *= 10
+	}
+	quotient, remainder := d.scaled/factor, d.scaled%factor
+	switch {
+	case m.Minor > quotient:
+		return 1
+	case m.Minor < quotient:
+		return -1
+	case remainder > 0:
+		return -1
+	case remainder < 0:
+		return 1
+	}
+	return 0
+}
+
+// compareMoney is a helper that compares the decimal values of two amounts, regardless of their currencies
+func compareMoney(a, b Money) int {
+	if a.Currency == b.Currency {
+		switch {
+		case a.Minor < b.Minor:
+			return -1
+		case a.Minor > b.Minor:
+			return 1
+		}
+		return 0
+	}
+	scaled := func(m Money) *big.Int {
+		exponent, err := CurrencyExponent(m.Currency)
+		if err != nil {
+			exponent = 0
+		}
+		factor := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(maxExponent-exponent)), nil)
+		return factor.Mul(factor, big.NewInt(m.Minor))
+	}
+	return scaled(a).Cmp(scaled(b))
+}
+
+// Filter selects payments, every condition that is set must match:
+type Filter struct {
+	// MinAmount and MaxAmount are inclusive bounds on the decimal value of the amount:
+	MinAmount *DecimalAmount
+	MaxAmount *DecimalAmount
+	// SequenceFrom and SequenceTo are inclusive bounds on the sequence number:
+	SequenceFrom *int
+	SequenceTo   *int
+	// Comment matches comments containing a substring:
+	Comment string
+	// CommentPattern matches comments with a regular expression:
+	CommentPattern *regexp.Regexp
+}
+
+// Match reports whether a payment satisfies all the conditions of the filter, a nil Filter matches every payment
+func (f *Filter) Match(p Payment) bool {
+	if f == nil {
+		return true
+	}
+	if f.MinAmount != nil && p.Amount.Cmp(*f.MinAmount) < 0 {
+		return false
+	}
+	if f.MaxAmount != nil && p.Amount.Cmp(*f.MaxAmount) > 0 {
+		return false
+	}
+	if f.SequenceFrom != nil && p.Sequence < *f.SequenceFrom {
+		return false
+	}
+	if f.SequenceTo != nil && p.Sequence > *f.SequenceTo {
+		return false
+	}
+	if f.Comment != "" && !strings.Contains(p.Comment, f.Comment) {
+		return false
+	}
+	if f.CommentPattern != nil && !f.CommentPattern.MatchString(p.Comment) {
+		return false
+	}
+	return true
+}
+
+// Apply returns the payments that match the filter, the input slice is reused
+func (f *Filter) Apply(payments []Payment) []Payment {
+	if f == nil {
+		return payments
+	}
+	matches := payments[:0]
+	for _, p := range payments {
+		if f.Match(p) {
+			matches = append(matches, p)
+		}
+	}
+	return matches
+}
+
+// Fields that payments can be sorted by:
+const (
+	SortAsOf     = "asOf"
+	SortSequence = "sequence"
+	SortAmount   = "amount"
+	SortCurrency = "currency"
+	SortComment  = "comment"
+)
+
+// SortKey is a field used to sort payments
+type SortKey struct {
+	Field      string
+	Descending bool
+}
+
+// ParseSort parses a comma separated list of fields like "amount,-sequence", a leading "-" sorts in descending order
+func ParseSort(s string) ([]SortKey, error) {
+	var keys []SortKey
+	for _, field := range strings.Split(s, ",") {
+		key := SortKey{Field: field}
+		if strings.HasPrefix(field, "-") {
+			key = SortKey{Field: field[1:], Descending: true}
+		}
+		switch key.Field {
+		case SortAsOf, SortSequence, SortAmount, SortCurrency, SortComment:
+		default:
+			return nil, fmt.Errorf("invalid sort field '%s'", field)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// SortPayments sorts payments by the given keys, payments that are equal for all keys keep their order
+func SortPayments(payments []Payment, keys []SortKey) {
+	sort.SliceStable(payments, func(i, j int) bool {
+		a, b := payments[i], payments[j]
+		for _, key := range keys {
+			var cmp int
+			switch key.Field {
+			case SortAsOf:
+				cmp = compareTimes(a.AsOf, b.AsOf)
+			case SortSequence:
+				cmp = compareInts(a.Sequence, b.Sequence)
+			case SortAmount:
+				cmp = compareMoney(a.Amount, b.Amount)
+			case SortCurrency:
+				cmp = strings.Compare(a.Amount.Currency, b.Amount.Currency)
+			case SortComment:
+				cmp = strings.Compare(a.Comment, b.Comment)
+			}
+			if key.Descending {
+				cmp = -cmp
+			}
+			if cmp != 0 {
+				return cmp < 0
+			}
+		}
+		return false
+	})
+}
+
+// compareTimes is a helper that returns -1, 0 or +1
+func compareTimes(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+	return 0
+}
+
+// compareInts is a helper that returns -1, 0 or +1
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
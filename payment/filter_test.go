@@ -0,0 +1,111 @@
+package payment
+
+import (
+	"regexp"
+	"testing"
+)
+
+var testFilterPayments = []Payment{
+	{AsOf: testTimestamp("20220717090000"), Sequence: 1, Amount: Money{Minor: 1050, Currency: "USD"}, Comment: "rent"},
+	{AsOf: testTimestamp("20220717090000"), Sequence: 2, Amount: Money{Minor: 10500, Currency: "KWD"}, Comment: "Groceries"},
+	{AsOf: testTimestamp("20220717100000"), Sequence: 3, Amount: Money{Minor: 11, Currency: "JPY"}, Comment: "rent deposit"},
+	{AsOf: testTimestamp("20220717100000"), Sequence: 4, Amount: Money{Minor: -200, Currency: "EUR"}, Comment: "refund"},
+}
+
+func TestMoneyCmp(t *testing.T) {
+	cases := []struct {
+		money    Money
+		decimal  string
+		expected int
+	}{
+		{Money{Minor: 1050, Currency: "USD"}, "10.5", 0},
+		{Money{Minor: 1050, Currency: "USD"}, "10.501", -1},
+		{Money{Minor: 1050, Currency: "USD"}, "10.499", 1},
+		{Money{Minor: 11, Currency: "JPY"}, "10.999", 1},
+		{Money{Minor: 10500, Currency: "KWD"}, "10.5", 0},
+		{Money{Minor: -200, Currency: "EUR"}, "-2.001", 1},
+		{Money{Minor: -200, Currency: "EUR"}, "-1.999", -1},
+		{Money{Minor: 9223372036854775807, Currency: "USD"}, "9223372036854775.807", 1},
+	}
+	for _, c := range cases {
+		d, err := ParseDecimalAmount(c.decimal)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cmp := c.money.Cmp(d); cmp != c.expected {
+			t.Fatalf("invalid comparison of %s and %s, got %d, expected %d", c.money, c.decimal, cmp, c.expected)
+		}
+	}
+	if _, err := ParseDecimalAmount("1.2345"); err == nil {
+		t.Fatal("expected error for more than 3 fractional digits")
+	}
+}
+
+func TestFilter(t *testing.T) {
+	sequences := func(payments []Payment) []int {
+		var s []int
+		for _, p := range payments {
+			s = append(s, p.Sequence)
+		}
+		return s
+	}
+	min, _ := ParseDecimalAmount("10.5")
+	max, _ := ParseDecimalAmount("10.999")
+	from, to := 2, 3
+	cases := map[string]struct {
+		filter   *Filter
+		expected []int
+	}{
+		"nil":            {nil, []int{1, 2, 3, 4}},
+		"min amount":     {&Filter{MinAmount: &min}, []int{1, 2, 3}},
+		"amount range":   {&Filter{MinAmount: &min, MaxAmount: &max}, []int{1, 2}},
+		"sequence range": {&Filter{SequenceFrom: &from, SequenceTo: &to}, []int{2, 3}},
+		"comment":        {&Filter{Comment: "rent"}, []int{1, 3}},
+		"comment regexp": {&Filter{CommentPattern: regexp.MustCompile(`(?i)^g|fund`)}, []int{2, 4}},
+		"combined":       {&Filter{Comment: "rent", SequenceFrom: &from}, []int{3}},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			payments := append([]Payment(nil), testFilterPayments...)
+			got := sequences(c.filter.Apply(payments))
+			if len(got) != len(c.expected) {
+				t.Fatalf("invalid payments, got %v, expected %v", got, c.expected)
+			}
+			for i := range got {
+				if got[i] != c.expected[i] {
+					t.Fatalf("invalid payments, got %v, expected %v", got, c.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestSortPayments(t *testing.T) {
+	cases := map[string][]int{
+		"amount":         {4, 1, 2, 3},
+		"-amount":        {3, 1, 2, 4},
+		"-asOf,sequence": {3, 4, 1, 2},
+		"comment":        {2, 4, 1, 3},
+		"currency,-asOf": {4, 3, 2, 1},
+		// 10.50 USD and 10.500 KWD are equal, so the second key decides:
+		"amount,-sequence": {4, 2, 1, 3},
+	}
+	for sortParam, expected := range cases {
+		keys, err := ParseSort(sortParam)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payments := append([]Payment(nil), testFilterPayments...)
+		SortPayments(payments, keys)
+		for i, p := range payments {
+			if p.Sequence != expected[i] {
+				t.Fatalf("invalid order for '%s' at %d, got %d, expected %d", sortParam, i, p.Sequence, expected[i])
+			}
+		}
+	}
+	for _, invalid := range []string{"", "amount,", "-", "date"} {
+		if _, err := ParseSort(invalid); err == nil {
+			t.Fatalf("expected error for '%s'", invalid)
+		}
+	}
+}
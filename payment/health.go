@@ -0,0 +1,87 @@
+package payment
+
+import (
+	"errors"
+	"io/fs"
+	"time"
+)
+
+// Readiness describes the state of the data directory, see CheckReadiness
+type Readiness struct {
+	// Ready is false when the store can't be read or the data is stale:
+	Ready bool `json:"ready"`
+	// Error is set when the store can't be read:
+	Error string `json:"error,omitempty"`
+	// NewestFile is the path of the last modified payments file, like YYYYMMDD/HHMMSS.payments:
+	NewestFile string `json:"newestFile,omitempty"`
+	// NewestTimestamp is the timestamp encoded in the name of NewestFile:
+	NewestTimestamp *time.Time `json:"newestTimestamp,omitempty"`
+	// LastModified is the modification time of NewestFile, it's when the newest data arrived:
+	LastModified *time.Time `json:"lastModified,omitempty"`
+	// Stale is set when the newest file is older than the staleness window, or when there are no files:
+	Stale bool `json:"stale"`
+}
+
+// CheckReadiness verifies that the store can still be read and looks for the last modified payments file.
+// The data is considered stale when no file was modified within staleAfter, a zero staleAfter disables the check.
+// Modification times are used instead of the timestamps encoded in the names, so future-dated files don't
+// keep the data fresh and backfills of old files don't make it stale.
+func (p *PaymentsService) CheckReadiness(now time.Time, staleAfter time.Duration) *Readiness {
+	readiness := &Readiness{}
+	newest, modTime, err := p.lastModifiedFile()
+	if err != nil {
+		readiness.Error = err.Error()
+		return readiness
+	}
+	if newest != "" {
+		readiness.NewestFile = newest
+		readiness.LastModified = &modTime
+		dir, name, _ := p.splitPath(newest)
+		ts, _ := p.fileTimestamp(dir, name)
+		readiness.NewestTimestamp = &ts
+	}
+	if staleAfter > 0 {
+		readiness.Stale = readiness.LastModified == nil || now.Sub(*readiness.LastModified) > staleAfter
+	}
+	readiness.Ready = !readiness.Stale
+	return readiness
+}
+
+// lastModifiedFile is a helper that returns the path and modification time of the last modified payments file,
+// or an empty string when there are none. Every file is visited, files modified at the same time are
+// ordered by name. Invalid names are skipped without warnings since readiness is checked often.
+func (p *PaymentsService) lastModifiedFile() (string, time.Time, error) {
+	dirs, err := p.Store.ListPartitions()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	var newest string
+	var newestModTime time.Time
+	for _, dir := range dirs {
+		if err := p.validateDirName(dir); err != nil {
+			continue
+		}
+		names, err := p.Store.ListFiles(dir)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		for _, name := range names {
+			if err := p.validateFileName(name); err != nil {
+				continue
+			}
+			fi, err := p.Store.Stat(dir, name)
+			if errors.Is(err, fs.ErrNotExist) {
+				// The file was removed after listing the directory:
+				continue
+			}
+			if err != nil {
+				return "", time.Time{}, err
+			}
+			path := dir + "/" + name
+			if modTime := fi.ModTime(); newest == "" || modTime.After(newestModTime) || (modTime.Equal(newestModTime) && path > newest) {
+				newest, newestModTime = path, modTime
+			}
+		}
+	}
+	return newest, newestModTime, nil
+}
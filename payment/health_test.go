@@ -0,0 +1,105 @@
+package payment
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestCheckReadiness covers readiness with fresh, stale, empty and unreadable data directories
+func TestCheckReadiness(t *testing.T) {
+	paymentsService, store := serviceWithMemoryStore()
+	now := testTimestamp("20220717100000")
+	for _, f := range []struct {
+		dir, name string
+		modTime   time.Time
+	}{
+		{"20220717", "090000.payments", now.Add(-time.Hour)},
+		{"20220717", "063000.payments", now.Add(-4 * time.Hour)},
+		{"20220716", "230000.payments", now.Add(-11 * time.Hour)},
+		{"20220718", "invalid", now},
+	} {
+		store.AddFile(f.dir, f.name, []byte(testRawCSV))
+		if err := store.SetModTime(f.dir, f.name, f.modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("fresh", func(t *testing.T) {
+		readiness := paymentsService.CheckReadiness(now, 2*time.Hour)
+		if !readiness.Ready || readiness.Stale || readiness.Error != "" {
+			t.Fatalf("expected ready data directory, got %+v", readiness)
+		}
+		if readiness.NewestFile != "20220717/090000.payments" {
+			t.Fatalf("invalid newest file, got '%s', expected '%s'", readiness.NewestFile, "20220717/090000.payments")
+		}
+		if !readiness.NewestTimestamp.Equal(testTimestamp("20220717090000")) {
+			t.Fatalf("invalid newest timestamp, got %s", readiness.NewestTimestamp)
+		}
+		if !readiness.LastModified.Equal(now.Add(-time.Hour)) {
+			t.Fatalf("invalid last modified time, got %s", readiness.LastModified)
+		}
+	})
+
+	t.Run("stale", func(t *testing.T) {
+		readiness := paymentsService.CheckReadiness(now, 30*time.Minute)
+		if readiness.Ready || !readiness.Stale {
+			t.Fatalf("expected stale data directory, got %+v", readiness)
+		}
+		// Staleness isn't checked without a window:
+		if readiness := paymentsService.CheckReadiness(now, 0); !readiness.Ready {
+			t.Fatalf("expected ready data directory, got %+v", readiness)
+		}
+	})
+
+	t.Run("file names", func(t *testing.T) {
+		namesService, namesStore := serviceWithMemoryStore()
+		// A future-dated file that arrived long ago doesn't keep the data fresh:
+		namesStore.AddFile("20991231", "235959.payments", []byte(testRawCSV))
+		if err := namesStore.SetModTime("20991231", "235959.payments", now.Add(-3*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if readiness := namesService.CheckReadiness(now, time.Hour); readiness.Ready || !readiness.Stale {
+			t.Fatalf("expected stale data directory, got %+v", readiness)
+		}
+		// But a backfilled file that just arrived does:
+		namesStore.AddFile("20220101", "000000.payments", []byte(testRawCSV))
+		if err := namesStore.SetModTime("20220101", "000000.payments", now.Add(-time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		readiness := namesService.CheckReadiness(now, time.Hour)
+		if !readiness.Ready || readiness.NewestFile != "20220101/000000.payments" {
+			t.Fatalf("expected ready data directory with the backfilled file, got %+v", readiness)
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		emptyService, _ := serviceWithMemoryStore()
+		readiness := emptyService.CheckReadiness(now, time.Hour)
+		if readiness.Ready || !readiness.Stale || readiness.NewestTimestamp != nil {
+			t.Fatalf("expected stale data directory, got %+v", readiness)
+		}
+	})
+
+	t.Run("unreadable", func(t *testing.T) {
+		baseDir := filepath.Join(t.TempDir(), "data")
+		if err := os.Mkdir(baseDir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		fsService, err := NewWithBaseDir(baseDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if readiness := fsService.CheckReadiness(now, 0); !readiness.Ready {
+			t.Fatalf("expected ready data directory, got %+v", readiness)
+		}
+		if err := os.Remove(baseDir); err != nil {
+			t.Fatal(err)
+		}
+		readiness := fsService.CheckReadiness(now, 0)
+		if readiness.Ready || readiness.Error == "" {
+			t.Fatalf("expected unreadable data directory, got %+v", readiness)
+		}
+	})
+}
@@ -0,0 +1,148 @@
+package payment
+
+import (
+	"fmt"
+)
+
+// FindingType differentiates the problems reported by the integrity checker
+type FindingType string
+
+const (
+	// FindingDuplicate is reported when a sequence number was already used in the same directory:
+	FindingDuplicate FindingType = "duplicate_sequence"
+	// FindingNonMonotonic is reported when a sequence number is lower than the previous one,
+	// either in the same file or in the last row of the previous file:
+	FindingNonMonotonic FindingType = "non_monotonic_sequence"
+	// FindingGap is reported when sequence numbers are missing between two consecutive files:
+	FindingGap FindingType = "sequence_gap"
+)
+
+// Finding is a single integrity problem
+type Finding struct {
+	Type FindingType `json:"type"`
+	// Path is the file where the problem was found, like YYYYMMDD/HHMMSS.payments:
+	Path string `json:"path"`
+	// Index is the position of the payment in the file, starting at 0, rejected rows aren't counted:
+	Index    int `json:"index"`
+	Sequence int `json:"sequence"`
+	// Previous is the sequence number the payment was compared with, unset for duplicates:
+	Previous int `json:"previous,omitempty"`
+	// OtherPath is the file that contains the first occurrence of a duplicate or the previous file of a gap:
+	OtherPath string `json:"otherPath,omitempty"`
+	Message   string `json:"message"`
+}
+
+// IntegrityReport holds the findings of a file or directory check
+type IntegrityReport struct {
+	// Path is the checked file or directory:
+	Path     string    `json:"path"`
+	Files    int       `json:"files"`
+	Payments int       `json:"payments"`
+	Findings []Finding `json:"findings"`
+}
+
+// OK reports whether the check didn't find any problem
+func (r *IntegrityReport) OK() bool {
+	return len(r.Findings) == 0
+}
+
+// integrityChecker keeps the state needed to check consecutive files
+type integrityChecker struct {
+	report *IntegrityReport
+	// seen maps sequence numbers to the file of their first occurrence:
+	seen map[int]string
+	// lastPath and lastSequence belong to the last payment checked:
+	lastPath     string
+	lastSequence int
+	// maxSequence is the highest sequence number checked so far:
+	maxSequence int
+	started     bool
+}
+
+// CheckFile looks for duplicated and non monotonic sequence numbers in a payments file
+func (p *PaymentsService) CheckFile(path string) (*IntegrityReport, error) {
+	checker := newIntegrityChecker(path)
+	if err := p.checkFile(checker, path); err != nil {
+		return nil, err
+	}
+	return checker.report, nil
+}
+
+// CheckDirectory looks for duplicated and non monotonic sequence numbers in all the files of a date directory,
+// and for gaps between consecutive files. Files are checked in chronological order.
+func (p *PaymentsService) CheckDirectory(dir string) (*IntegrityReport, error) {
+	files, err := p.ListPayments(dir)
+	if err != nil {
+		return nil, err
+	}
+	checker := newIntegrityChecker(dir)
+	for _, name := range files {
+		if err := p.checkFile(checker, dir+"/"+name); err != nil {
+			return nil, err
+		}
+	}
+	return checker.report, nil
+}
+
+// newIntegrityChecker initializes an integrityChecker for a given file or directory
+func newIntegrityChecker(path string) *integrityChecker {
+	return &integrityChecker{
+		report: &IntegrityReport{Path: path, Findings: make([]Finding, 0)},
+		seen:   make(map[int]string),
+	}
+}
+
+// checkFile is a helper that reads a file row by row and adds its findings to the checker
+func (p *PaymentsService) checkFile(c *integrityChecker, path string) error {
+	index := 0
+	firstRow := true
+	err := p.StreamPayments(path, func(payment Payment) error {
+		c.check(path, index, payment.Sequence, firstRow)
+		index++
+		firstRow = false
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	c.report.Files++
+	c.report.Payments += index
+	return nil
+}
+
+// check is a helper that compares a sequence number with the previous ones,
+// firstRow is set for the first payment of every file so gaps between files can be detected,
+// gaps are measured from the highest sequence number of the previous files.
+func (c *integrityChecker) check(path string, index, sequence int, firstRow bool) {
+	finding := Finding{Path: path, Index: index, Sequence: sequence}
+	if otherPath, ok := c.seen[sequence]; ok {
+		finding.Type = FindingDuplicate
+		finding.OtherPath = otherPath
+		finding.Message = fmt.Sprintf("sequence %d was already used in %s", sequence, otherPath)
+		c.report.Findings = append(c.report.Findings, finding)
+	} else {
+		c.seen[sequence] = path
+		switch {
+		case c.started && sequence < c.lastSequence:
+			finding.Type = FindingNonMonotonic
+			finding.Previous = c.lastSequence
+			if firstRow {
+				finding.OtherPath = c.lastPath
+			}
+			finding.Message = fmt.Sprintf("sequence %d follows %d", sequence, c.lastSequence)
+			c.report.Findings = append(c.report.Findings, finding)
+		case c.started && firstRow && sequence > c.maxSequence+1:
+			finding.Type = FindingGap
+			finding.Previous = c.maxSequence
+			finding.OtherPath = c.lastPath
+			finding.Message = fmt.Sprintf("sequences %d to %d are missing between %s and %s", c.maxSequence+1, sequence-1, c.lastPath, path)
+			c.report.Findings = append(c.report.Findings, finding)
+		}
+	}
+	if !c.started || sequence > c.maxSequence {
+		c.maxSequence = sequence
+	}
+	c.started = true
+	c.lastPath = path
+	c.lastSequence = sequence
+}
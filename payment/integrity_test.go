@@ -0,0 +1,74 @@
+package payment
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestCheckIntegrity covers duplicate, non monotonic and gap findings
+func TestCheckIntegrity(t *testing.T) {
+	paymentsService, store := serviceWithMemoryStore()
+	store.AddFile("20220717", "063000.payments", []byte(`date,time,sequence,amount
+20220717,063000,1,10
+20220717,063000,2,10
+20220717,063000,4,10
+20220717,063000,3,10`))
+	store.AddFile("20220717", "090000.payments", []byte(`date,time,sequence,amount
+20220717,090000,7,10
+20220717,090000,8,10
+20220717,090000,8,10`))
+	store.AddFile("20220717", "100000.payments", []byte(`date,time,sequence,amount
+20220717,100000,6,10
+20220717,100000,2,10`))
+	store.AddFile("20220718", "090000.payments", []byte(`date,time,sequence,amount
+20220718,090000,1,10
+20220718,090000,2,10`))
+
+	t.Run("directory", func(t *testing.T) {
+		report, err := paymentsService.CheckDirectory("20220717")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Files != 3 || report.Payments != 9 {
+			t.Fatalf("invalid counts, got %d files and %d payments", report.Files, report.Payments)
+		}
+		expected := []Finding{
+			{Type: FindingNonMonotonic, Path: "20220717/063000.payments", Index: 3, Sequence: 3, Previous: 4},
+			{Type: FindingGap, Path: "20220717/090000.payments", Index: 0, Sequence: 7, Previous: 4, OtherPath: "20220717/063000.payments"},
+			{Type: FindingDuplicate, Path: "20220717/090000.payments", Index: 2, Sequence: 8, OtherPath: "20220717/090000.payments"},
+			{Type: FindingNonMonotonic, Path: "20220717/100000.payments", Index: 0, Sequence: 6, Previous: 8, OtherPath: "20220717/090000.payments"},
+			{Type: FindingDuplicate, Path: "20220717/100000.payments", Index: 1, Sequence: 2, OtherPath: "20220717/063000.payments"},
+		}
+		if len(report.Findings) != len(expected) {
+			t.Fatalf("invalid number of findings, got %d, expected %d: %+v", len(report.Findings), len(expected), report.Findings)
+		}
+		for i, finding := range report.Findings {
+			if finding.Message == "" {
+				t.Fatalf("finding %d has no message", i)
+			}
+			finding.Message = ""
+			if finding != expected[i] {
+				t.Fatalf("invalid finding %d, got %+v, expected %+v", i, finding, expected[i])
+			}
+		}
+		if report.OK() {
+			t.Fatal("report shouldn't be OK")
+		}
+	})
+
+	t.Run("file", func(t *testing.T) {
+		report, err := paymentsService.CheckFile("20220718/090000.payments")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !report.OK() || report.Payments != 2 {
+			t.Fatalf("unexpected report %+v", report)
+		}
+	})
+
+	t.Run("invalid directory", func(t *testing.T) {
+		if _, err := paymentsService.CheckDirectory("2022"); !errors.Is(err, ErrInvalidName) {
+			t.Fatalf("expected ErrInvalidName, got %v", err)
+		}
+	})
+}
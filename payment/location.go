@@ -0,0 +1,91 @@
+package payment
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// LocationPolicy controls how rows are handled when their date and time columns
+// don't match the directory and file name they're stored in
+type LocationPolicy int
+
+const (
+	// LocationWarn keeps the row as it is and reports a warning:
+	LocationWarn LocationPolicy = iota
+	// LocationReject rejects the row like any other invalid row:
+	LocationReject
+	// LocationOverride builds AsOf from the directory and file name and reports a warning:
+	LocationOverride
+)
+
+// ParseLocationPolicy parses a policy name: warn, reject or override
+func ParseLocationPolicy(s string) (LocationPolicy, error) {
+	switch s {
+	case "warn":
+		return LocationWarn, nil
+	case "reject":
+		return LocationReject, nil
+	case "override":
+		return LocationOverride, nil
+	}
+	return 0, fmt.Errorf("invalid location policy '%s'", s)
+}
+
+// String returns the policy name
+func (l LocationPolicy) String() string {
+	switch l {
+	case LocationWarn:
+		return "warn"
+	case LocationReject:
+		return "reject"
+	case LocationOverride:
+		return "override"
+	}
+	return "unknown"
+}
+
+// fileLocation holds the date and time encoded in the directory and file name of a payments file
+type fileLocation struct {
+	date string
+	time string
+}
+
+// newFileLocation is a helper that builds a fileLocation from a directory and file name,
+// like 20220717 and 063000.payments, both names must be valid.
+func newFileLocation(dir, name string) *fileLocation {
+	return &fileLocation{date: dir, time: strings.TrimSuffix(name, ".payments")}
+}
+
+// checkLocation is a helper that compares the date and time columns of a valid row with the file location.
+// Mismatches are returned as row errors, reject reports whether the row must be rejected.
+func (p *PaymentsService) checkLocation(loc *fileLocation, columns *columnMapping, line int, row []string, payment *Payment) (mismatches []RowError, reject bool) {
+	if loc == nil {
+		return nil, false
+	}
+	for _, c := range []struct{ column, expected, source string }{
+		{columnDate, loc.date, "directory"},
+		{columnTime, loc.time, "file name"},
+	} {
+		value := row[columns.index[c.column]]
+		if value == c.expected {
+			continue
+		}
+		reason := fmt.Sprintf("doesn't match the %s %s", c.source, c.expected)
+		if p.LocationPolicy == LocationOverride {
+			reason += ", replaced"
+		}
+		mismatches = append(mismatches, RowError{Line: line, Column: c.column, Value: value, Reason: reason, mismatch: true})
+	}
+	if len(mismatches) == 0 {
+		return nil, false
+	}
+	switch p.LocationPolicy {
+	case LocationReject:
+		return mismatches, true
+	case LocationOverride:
+		// Both names were validated, so the error is ignored:
+		payment.AsOf, _ = time.ParseInLocation(timestampLayout, loc.date+loc.time, p.timeZone())
+	}
+	return mismatches, false
+}
@@ -0,0 +1,101 @@
+package payment
+
+import (
+	"errors"
+	"testing"
+)
+
+const testMismatchedCSV = `date,time,sequence,amount
+20220717,090000,1,10
+20220801,090000,2,10
+20220717,101010,3,10`
+
+// TestLocationPolicy covers rows whose date and time don't match the file location
+func TestLocationPolicy(t *testing.T) {
+	t.Run("warn", func(t *testing.T) {
+		paymentsService, store := serviceWithMemoryStore()
+		store.AddFile("20220717", "090000.payments", []byte(testMismatchedCSV))
+		result, err := paymentsService.GetPaymentsReport("20220717/090000.payments")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Payments) != 3 || len(result.Rejected) != 0 {
+			t.Fatalf("invalid result, got %d payments and %d rejected rows", len(result.Payments), len(result.Rejected))
+		}
+		if len(result.Warnings) != 2 || result.Consistent {
+			t.Fatalf("invalid warnings %+v", result.Warnings)
+		}
+		warning := result.Warnings[0]
+		if warning.Line != 3 || warning.Column != "date" || warning.Value != "20220801" {
+			t.Fatalf("invalid warning %+v", warning)
+		}
+		if result.Payments[1].LegacyAsOf() != 20220801090000 {
+			t.Fatalf("invalid AsOf, got %d, expected %d", result.Payments[1].LegacyAsOf(), 20220801090000)
+		}
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		paymentsService, store := serviceWithMemoryStore()
+		paymentsService.LocationPolicy = LocationReject
+		store.AddFile("20220717", "090000.payments", []byte(testMismatchedCSV))
+		result, err := paymentsService.GetPaymentsReport("20220717/090000.payments")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Payments) != 1 || len(result.Rejected) != 2 || len(result.Warnings) != 0 || result.Consistent {
+			t.Fatalf("invalid result %+v", result)
+		}
+		if result.Rejected[1].Column != "time" || result.Rejected[1].Line != 4 {
+			t.Fatalf("invalid row error %+v", result.Rejected[1])
+		}
+		// Uploads are parsed strictly, so mismatched rows reject the whole file:
+		_, _, err = paymentsService.PutPayments("20220717", "090000.payments", []byte(testMismatchedCSV))
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) || len(parseErr.Rows) != 2 {
+			t.Fatalf("expected *ParseError with 2 rows, got %v", err)
+		}
+	})
+
+	t.Run("override", func(t *testing.T) {
+		paymentsService, store := serviceWithMemoryStore()
+		paymentsService.LocationPolicy = LocationOverride
+		store.AddFile("20220717", "090000.payments", []byte(testMismatchedCSV))
+		var payments []Payment
+		err := paymentsService.StreamPayments("20220717/090000.payments", func(payment Payment) error {
+			payments = append(payments, payment)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, payment := range payments {
+			if payment.LegacyAsOf() != 20220717090000 {
+				t.Fatalf("invalid AsOf for sequence %d, got %d, expected %d", payment.Sequence, payment.LegacyAsOf(), 20220717090000)
+			}
+		}
+	})
+
+	t.Run("consistent", func(t *testing.T) {
+		paymentsService, store := serviceWithMemoryStore()
+		store.AddFile("20220717", "090000.payments", []byte(testRawCSV))
+		result, err := paymentsService.GetPaymentsReport("20220717/090000.payments")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Consistent || len(result.Warnings) != 0 {
+			t.Fatalf("file should be consistent, got %+v", result)
+		}
+	})
+
+	t.Run("parse policy", func(t *testing.T) {
+		for _, policy := range []LocationPolicy{LocationWarn, LocationReject, LocationOverride} {
+			parsed, err := ParseLocationPolicy(policy.String())
+			if err != nil || parsed != policy {
+				t.Fatalf("invalid policy, got %v, expected %v", parsed, policy)
+			}
+		}
+		if _, err := ParseLocationPolicy("ignore"); err == nil {
+			t.Fatal("expected error for unknown policy")
+		}
+	})
+}
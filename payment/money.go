@@ -0,0 +1,158 @@
+package payment
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultCurrency is used for rows without a currency column when PaymentsService.DefaultCurrency isn't set:
+	DefaultCurrency = "USD"
+)
+
+// currencyExponents maps ISO 4217 currency codes to the number of digits of their minor unit,
+// e.g. 2 for USD (cents) and 0 for JPY:
+var currencyExponents = map[string]int{
+	"ARS": 2, "AUD": 2, "BHD": 3, "BOB": 2, "BRL": 2, "CAD": 2, "CHF": 2, "CLP": 0, "CNY": 2,
+	"COP": 2, "CZK": 2, "DKK": 2, "EUR": 2, "GBP": 2, "HKD": 2, "HUF": 2, "IDR": 2, "ILS": 2,
+	"INR": 2, "ISK": 0, "JOD": 3, "JPY": 0, "KRW": 0, "KWD": 3, "MXN": 2, "NOK": 2, "NZD": 2,
+	"OMR": 3, "PEN": 2, "PLN": 2, "PYG": 0, "SEK": 2, "SGD": 2, "TND": 3, "TRY": 2, "TWD": 2,
+	"USD": 2, "UYU": 2, "VND": 0, "ZAR": 2,
+}
+
+// CurrencyExponent returns the number of minor unit digits of a given ISO 4217 currency code:
+func CurrencyExponent(currency string) (int, error) {
+	exponent, ok := currencyExponents[currency]
+	if !ok {
+		return 0, fmt.Errorf("unsupported currency '%s'", currency)
+	}
+	return exponent, nil
+}
+
+// Money is a fixed-point amount expressed in the minor units of its currency,
+// e.g. Money{Minor: 1050, Currency: "USD"} is 10.50 USD.
+type Money struct {
+	Minor    int64  `json:"minorUnits"`
+	Currency string `json:"currency"`
+}
+
+// ParseMoney parses a decimal string like "10.50" in a given currency.
+// Values with more fractional digits than the currency supports are rejected instead of rounded.
+func ParseMoney(s, currency string) (Money, error) {
+	exponent, err := CurrencyExponent(currency)
+	if err != nil {
+		return Money{}, err
+	}
+	minor, err := parseDecimal(s, exponent)
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Minor: minor, Currency: currency}, nil
+}
+
+// parseDecimal is a helper that parses a decimal string and returns it scaled by 10^exponent,
+// it fails if the value doesn't fit in exactly exponent fractional digits or overflows an int64.
+func parseDecimal(s string, exponent int) (int64, error) {
+	invalid := func(reason string) (int64, error) {
+		return 0, fmt.Errorf("invalid decimal '%s': %s", s, reason)
+	}
+	digits := s
+	negative := false
+	if strings.HasPrefix(digits, "-") || strings.HasPrefix(digits, "+") {
+		negative = digits[0] == '-'
+		digits = digits[1:]
+	}
+	intPart, fracPart := digits, ""
+	if i := strings.IndexByte(digits, '.'); i >= 0 {
+		intPart, fracPart = digits[:i], digits[i+1:]
+		if fracPart == "" {
+			return invalid("missing fractional digits")
+		}
+	}
+	if intPart == "" {
+		return invalid("missing integer digits")
+	}
+	if len(fracPart) > exponent {
+		return invalid(fmt.Sprintf("more than %d fractional digits", exponent))
+	}
+	// Pad the fractional part so the result is expressed in minor units:
+	fracPart += strings.Repeat("0", exponent-len(fracPart))
+	for _, c := range intPart + fracPart {
+		if c < '0' || c > '9' {
+			return invalid("unexpected character")
+		}
+	}
+	value, err := strconv.ParseInt(intPart+fracPart, 10, 64)
+	if err != nil {
+		return invalid("out of range")
+	}
+	if negative {
+		value = -value
+	}
+	return value, nil
+}
+
+// Decimal formats the amount as a decimal string without the currency, e.g. "10.50":
+func (m Money) Decimal() string {
+	exponent, err := CurrencyExponent(m.Currency)
+	if err != nil {
+		// Unknown currencies are rendered in minor units:
+		exponent = 0
+	}
+	minor := m.Minor
+	sign := ""
+	if minor < 0 {
+		sign = "-"
+	}
+	// Use the unsigned absolute value so math.MinInt64 is formatted properly:
+	abs := uint64(minor)
+	if minor < 0 {
+		abs = uint64(-(minor + 1)) + 1
+	}
+	digits := strconv.FormatUint(abs, 10)
+	if exponent == 0 {
+		return sign + digits
+	}
+	if len(digits) <= exponent {
+		digits = strings.Repeat("0", exponent-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-exponent] + "." + digits[len(digits)-exponent:]
+}
+
+// Major returns the amount in major units, e.g. 10 for 10.00 USD, ok is false when it has a fractional part
+func (m Money) Major() (major int64, ok bool) {
+	exponent, err := CurrencyExponent(m.Currency)
+	if err != nil {
+		// Unknown currencies are in minor units, like in Decimal:
+		exponent = 0
+	}
+	scale := int64(1)
+	for i := 0; i < exponent; i++ {
+		scale *= 10
+	}
+	if m.Minor%scale != 0 {
+		return 0, false
+	}
+	return m.Minor / scale, true
+}
+
+// String formats the amount with its currency, e.g. "10.50 USD":
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+// MarshalJSON renders both the minor units and the formatted decimal value,
+// e.g. {"minorUnits":1050,"currency":"USD","value":"10.50"}
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Minor    int64  `json:"minorUnits"`
+		Currency string `json:"currency"`
+		Value    string `json:"value"`
+	}{
+		Minor:    m.Minor,
+		Currency: m.Currency,
+		Value:    m.Decimal(),
+	})
+}
@@ -0,0 +1,98 @@
+package payment
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// TestParseMoney covers ParseMoney using sample inputs
+func TestParseMoney(t *testing.T) {
+	validInputs := []struct {
+		s, currency string
+		minor       int64
+		decimal     string
+	}{
+		{"10.50", "USD", 1050, "10.50"},
+		{"10.5", "USD", 1050, "10.50"},
+		{"1000", "USD", 100000, "1000.00"},
+		{"0.01", "EUR", 1, "0.01"},
+		{"-0.01", "EUR", -1, "-0.01"},
+		{"+3", "EUR", 300, "3.00"},
+		{"150000", "PYG", 150000, "150000"},
+		{"1.234", "BHD", 1234, "1.234"},
+		{"0", "JPY", 0, "0"},
+		{"92233720368547758.07", "USD", 9223372036854775807, "92233720368547758.07"},
+	}
+	for _, in := range validInputs {
+		m, err := ParseMoney(in.s, in.currency)
+		if err != nil {
+			t.Fatalf("should accept '%s' %s: %s", in.s, in.currency, err.Error())
+		}
+		if m.Minor != in.minor || m.Currency != in.currency {
+			t.Fatalf("invalid value for '%s' %s, got %d %s, expected %d %s", in.s, in.currency, m.Minor, m.Currency, in.minor, in.currency)
+		}
+		if m.Decimal() != in.decimal {
+			t.Fatalf("invalid decimal for '%s' %s, got '%s', expected '%s'", in.s, in.currency, m.Decimal(), in.decimal)
+		}
+	}
+	invalidInputs := []struct {
+		s, currency string
+	}{
+		{"10.505", "USD"},
+		{"10.5", "JPY"},
+		{"", "USD"},
+		{".50", "USD"},
+		{"10.", "USD"},
+		{"1e3", "USD"},
+		{"10,50", "USD"},
+		{"--1", "USD"},
+		{"92233720368547758.08", "USD"},
+		{"10", "XYZ"},
+	}
+	for _, in := range invalidInputs {
+		if _, err := ParseMoney(in.s, in.currency); err == nil {
+			t.Fatalf("should error with '%s' %s", in.s, in.currency)
+		}
+	}
+}
+
+// TestMoneyJSON covers the JSON representation of Money
+func TestMoneyJSON(t *testing.T) {
+	m := Money{Minor: -5, Currency: "USD"}
+	rawJSON, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedJSON := `{"minorUnits":-5,"currency":"USD","value":"-0.05"}`
+	if strings.Compare(string(rawJSON), expectedJSON) != 0 {
+		t.Fatalf("invalid JSON, got '%s', expected '%s'", rawJSON, expectedJSON)
+	}
+	var decoded Money
+	if err := json.Unmarshal(rawJSON, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded != m {
+		t.Fatalf("invalid decoded value, got %s, expected %s", decoded, m)
+	}
+}
+
+// TestMoneyMajor covers the conversion of amounts to major units
+func TestMoneyMajor(t *testing.T) {
+	for _, c := range []struct {
+		m        Money
+		expected int64
+		ok       bool
+	}{
+		{Money{Minor: 50000, Currency: "USD"}, 500, true},
+		{Money{Minor: -300, Currency: "EUR"}, -3, true},
+		{Money{Minor: 1050, Currency: "USD"}, 0, false},
+		{Money{Minor: 150000, Currency: "PYG"}, 150000, true},
+		{Money{Minor: 1500, Currency: "BHD"}, 0, false},
+	} {
+		major, ok := c.m.Major()
+		if major != c.expected || ok != c.ok {
+			t.Fatalf("invalid major units for %s, got %d %t, expected %d %t", c.m, major, ok, c.expected, c.ok)
+		}
+	}
+}
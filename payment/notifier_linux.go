@@ -0,0 +1,184 @@
+//go:build linux
+// +build linux
+
+package payment
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	// inotifyMask selects the events that may reveal new directories or complete payments files,
+	// file creations are ignored until the file is closed or moved into place.
+	// Removed directories are reported with IN_DELETE_SELF, followed by IN_IGNORED when their watch goes away:
+	inotifyMask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF
+)
+
+// newNotifier returns an inotify based notifier for filesystem stores,
+// the polling notifier is used for other stores or when inotify isn't available.
+func newNotifier(p *PaymentsService, interval time.Duration) notifier {
+	fsStore, ok := p.Store.(*FSStore)
+	if !ok {
+		return newPollNotifier(interval)
+	}
+	n, err := newInotifyNotifier(fsStore.BaseDir)
+	if err != nil {
+		log.Printf("watcher: inotify isn't available, falling back to polling: %s\n", err.Error())
+		return newPollNotifier(interval)
+	}
+	return n
+}
+
+// inotifyNotifier is a notifier backed by Linux inotify
+type inotifyNotifier struct {
+	baseDir string
+	// file wraps the non-blocking inotify descriptor so reads go through the runtime poller
+	// and are interrupted by Close:
+	file *os.File
+	fd   int
+	ch   chan struct{}
+
+	mu      sync.Mutex
+	watches map[string]bool
+	// dirs maps watch descriptors to date directories, the base directory is mapped to "":
+	dirs map[int32]string
+	// changed holds the directories with events since the last call to Changes, overflow is set when events were lost:
+	changed  map[string]bool
+	overflow bool
+}
+
+// newInotifyNotifier initializes inotify and watches the base directory
+func newInotifyNotifier(baseDir string) (*inotifyNotifier, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	n := &inotifyNotifier{
+		baseDir: baseDir,
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		fd:      fd,
+		// A single pending notification is enough, changes are collected until the watcher asks for them:
+		ch:      make(chan struct{}, 1),
+		watches: make(map[string]bool),
+		dirs:    make(map[int32]string),
+		changed: make(map[string]bool),
+	}
+	if err := n.addWatch(baseDir, ""); err != nil {
+		n.file.Close()
+		return nil, err
+	}
+	go n.readEvents()
+	return n, nil
+}
+
+func (n *inotifyNotifier) Notifications() <-chan struct{} { return n.ch }
+
+// Watch adds an inotify watch for a date directory
+func (n *inotifyNotifier) Watch(dir string) error {
+	return n.addWatch(filepath.Join(n.baseDir, dir), dir)
+}
+
+// Changes satisfies notifier, the whole store is only scanned when the inotify queue overflowed
+func (n *inotifyNotifier) Changes() ([]string, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.overflow {
+		n.overflow = false
+		n.changed = make(map[string]bool)
+		return nil, true
+	}
+	dirs := make([]string, 0, len(n.changed))
+	for dir := range n.changed {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	n.changed = make(map[string]bool)
+	return dirs, false
+}
+
+func (n *inotifyNotifier) Close() error {
+	return n.file.Close()
+}
+
+// addWatch is a helper that adds an inotify watch for a given path once, dir is reported by Changes for its events
+func (n *inotifyNotifier) addWatch(path, dir string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.watches[path] {
+		return nil
+	}
+	wd, err := syscall.InotifyAddWatch(n.fd, path, inotifyMask|syscall.IN_ONLYDIR)
+	if err != nil {
+		return os.NewSyscallError("inotify_add_watch", err)
+	}
+	n.watches[path] = true
+	n.dirs[int32(wd)] = dir
+	return nil
+}
+
+// readEvents reads inotify events until the notifier is closed
+func (n *inotifyNotifier) readEvents() {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		count, err := n.file.Read(buf)
+		if err != nil {
+			return
+		}
+		notify := false
+		n.mu.Lock()
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= count; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			offset = nameStart + int(event.Len)
+			if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				n.overflow = true
+				notify = true
+				continue
+			}
+			// Plain file creations are followed by IN_CLOSE_WRITE once the file is complete:
+			if event.Mask&syscall.IN_CREATE != 0 && event.Mask&syscall.IN_ISDIR == 0 {
+				continue
+			}
+			dir, ok := n.dirs[event.Wd]
+			if !ok {
+				continue
+			}
+			if event.Mask&(syscall.IN_IGNORED|syscall.IN_DELETE_SELF) != 0 {
+				// Forget the watch so it's added again if the directory comes back,
+				// the directory is reported so the watcher notices it's gone:
+				delete(n.dirs, event.Wd)
+				if dir != "" {
+					delete(n.watches, filepath.Join(n.baseDir, dir))
+					n.changed[dir] = true
+					notify = true
+				}
+				continue
+			}
+			if dir == "" {
+				// Only new directories matter in the base directory, the name is padded with NUL bytes:
+				if event.Mask&syscall.IN_ISDIR == 0 {
+					continue
+				}
+				dir = strings.TrimRight(string(buf[nameStart:offset]), "\x00")
+			}
+			n.changed[dir] = true
+			notify = true
+		}
+		n.mu.Unlock()
+		if !notify {
+			continue
+		}
+		select {
+		case n.ch <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,77 @@
+//go:build linux
+// +build linux
+
+package payment
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestInotifyChanges checks that inotify events are reported for the directory they happened in
+func TestInotifyChanges(t *testing.T) {
+	baseDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(baseDir, "20220717"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	n, err := newInotifyNotifier(baseDir)
+	if err != nil {
+		t.Skipf("inotify isn't available: %s", err.Error())
+	}
+	defer n.Close()
+	if err := n.Watch("20220717"); err != nil {
+		t.Fatal(err)
+	}
+
+	// waitChanges is a helper that collects the reported directories until a given number is reached:
+	waitChanges := func(count int) map[string]bool {
+		t.Helper()
+		changed := make(map[string]bool)
+		for len(changed) < count {
+			select {
+			case <-n.Notifications():
+				dirs, all := n.Changes()
+				if all {
+					t.Fatal("unexpected full scan")
+				}
+				for _, dir := range dirs {
+					changed[dir] = true
+				}
+			case <-time.After(testWatcherTimeout):
+				t.Fatalf("timeout waiting for changes, got %v", changed)
+			}
+		}
+		return changed
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(baseDir, "20220717", "090000.payments"), []byte(testRawCSV), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Files in the base directory are ignored:
+	if err := ioutil.WriteFile(filepath.Join(baseDir, "notes.txt"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(baseDir, "20220718"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	changed := waitChanges(2)
+	if len(changed) != 2 || !changed["20220717"] || !changed["20220718"] {
+		t.Fatalf("unexpected changes %v", changed)
+	}
+	// Removed directories are reported and their watch is forgotten:
+	if err := os.RemoveAll(filepath.Join(baseDir, "20220717")); err != nil {
+		t.Fatal(err)
+	}
+	if changed := waitChanges(1); len(changed) != 1 || !changed["20220717"] {
+		t.Fatalf("unexpected changes %v", changed)
+	}
+	n.mu.Lock()
+	watched := n.watches[filepath.Join(baseDir, "20220717")]
+	n.mu.Unlock()
+	if watched {
+		t.Fatal("the watch of a removed directory should be forgotten")
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package payment
+
+import (
+	"time"
+)
+
+// newNotifier returns a polling notifier, inotify is only available on Linux
+func newNotifier(p *PaymentsService, interval time.Duration) notifier {
+	return newPollNotifier(interval)
+}
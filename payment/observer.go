@@ -0,0 +1,47 @@
+package payment
+
+import (
+	"time"
+)
+
+// ParseStats describes a single parse of a payments file
+type ParseStats struct {
+	// Duration is the time spent reading and parsing the file, including the callbacks of streamed files:
+	Duration time.Duration
+	// Rows and Rejected count the valid and rejected rows, rows accepted with warnings are valid:
+	Rows     int
+	Rejected int
+	// Err is set when the file couldn't be parsed, e.g. because of an invalid header:
+	Err error
+}
+
+// ParseObserver is notified every time a payments file is parsed,
+// cached files aren't parsed again so they don't reach the observer.
+// ObserveParse may be called from multiple goroutines.
+type ParseObserver interface {
+	ObserveParse(stats ParseStats)
+}
+
+// observeScan is a helper that wraps the callbacks of scanPayments to count rows,
+// done must be called with the result of the scan to notify the observer
+func (p *PaymentsService) observeScan(onPayment func(Payment) error, onRejected func(RowError) error) (func(Payment) error, func(RowError) error, func(error)) {
+	if p.Observer == nil {
+		return onPayment, onRejected, func(error) {}
+	}
+	start := time.Now()
+	var stats ParseStats
+	countPayment := func(payment Payment) error {
+		stats.Rows++
+		return onPayment(payment)
+	}
+	countRejected := func(rowError RowError) error {
+		stats.Rejected++
+		return onRejected(rowError)
+	}
+	done := func(err error) {
+		stats.Duration = time.Since(start)
+		stats.Err = err
+		p.Observer.ObserveParse(stats)
+	}
+	return countPayment, countRejected, done
+}
@@ -0,0 +1,59 @@
+package payment
+
+import (
+	"sync"
+	"testing"
+)
+
+// testObserver keeps every ParseStats it receives
+type testObserver struct {
+	mu    sync.Mutex
+	stats []ParseStats
+}
+
+func (o *testObserver) ObserveParse(stats ParseStats) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.stats = append(o.stats, stats)
+}
+
+// TestParseObserver covers the stats reported for parsed, streamed and cached files
+func TestParseObserver(t *testing.T) {
+	paymentsService, store := serviceWithMemoryStore()
+	observer := &testObserver{}
+	paymentsService.Observer = observer
+	store.AddFile("20220717", "090000.payments", []byte(testRawCSV+"\n20220717,090000,212,abc,payment3"))
+	store.AddFile("20220717", "100000.payments", []byte("sequence,amount\n1,10"))
+
+	if _, err := paymentsService.GetPayments("20220717/090000.payments"); err != nil {
+		t.Fatal(err)
+	}
+	if err := paymentsService.StreamPayments("20220717/090000.payments", func(Payment) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := paymentsService.GetPayments("20220717/100000.payments"); err == nil {
+		t.Fatal("expected error for missing columns")
+	}
+	if len(observer.stats) != 3 {
+		t.Fatalf("invalid number of observations, got %d, expected %d", len(observer.stats), 3)
+	}
+	for i, stats := range observer.stats[:2] {
+		if stats.Rows != 1 || stats.Rejected != 1 || stats.Err != nil {
+			t.Fatalf("invalid stats for observation %d: %+v", i, stats)
+		}
+	}
+	if observer.stats[2].Err == nil {
+		t.Fatal("expected error in the stats of an invalid file")
+	}
+
+	// Cached files aren't parsed again:
+	paymentsService.Cache = NewCache(1 << 20)
+	for i := 0; i < 2; i++ {
+		if _, err := paymentsService.GetPayments("20220717/090000.payments"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(observer.stats) != 4 {
+		t.Fatalf("invalid number of observations, got %d, expected %d", len(observer.stats), 4)
+	}
+}
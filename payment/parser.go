@@ -0,0 +1,294 @@
+package payment
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseMode controls how invalid rows are handled when reading payments files
+type ParseMode int
+
+const (
+	// ParseLenient skips invalid rows and reports them along with the parsed payments:
+	ParseLenient ParseMode = iota
+	// ParseStrict rejects the whole file with a *ParseError when any row is invalid:
+	ParseStrict
+)
+
+// Column names recognized in the header of payments files, regardless of their case,
+// any other column is exposed through Payment.Extra under its original name:
+const (
+	columnDate     = "date"
+	columnTime     = "time"
+	columnSequence = "sequence"
+	columnAmount   = "amount"
+	columnCurrency = "currency"
+	columnComment  = "comment"
+)
+
+// requiredColumns lists the columns every payments file must contain:
+var requiredColumns = []string{columnDate, columnTime, columnSequence, columnAmount}
+
+// ParseResult holds the outcome of parsing a payments file
+type ParseResult struct {
+	Payments []Payment `json:"payments"`
+	// Rejected contains every row that couldn't be parsed, these rows aren't part of Payments:
+	Rejected []RowError `json:"rejected"`
+	// Warnings contains rows that were accepted with a problem, like a date that doesn't match the directory:
+	Warnings []RowError `json:"warnings,omitempty"`
+	// Consistent is false when the date or time of any row doesn't match the directory and file name:
+	Consistent bool `json:"consistent"`
+}
+
+// columnMapping maps the known column names to their position in the CSV rows
+type columnMapping struct {
+	// header contains the column names without surrounding spaces:
+	header []string
+	// index maps known column names, in lowercase, to their position:
+	index map[string]int
+	// extra maps the position of unknown columns to their names as written in the header:
+	extra map[int]string
+}
+
+// newColumnMapping builds a columnMapping from a CSV header row.
+// Known column names are case insensitive while unknown ones are kept as they are,
+// missing required columns and duplicated columns are reported as a *ParseError.
+func newColumnMapping(line int, header []string) (*columnMapping, error) {
+	m := &columnMapping{
+		header: make([]string, len(header)),
+		index:  make(map[string]int),
+		extra:  make(map[int]string),
+	}
+	var rowErrors []RowError
+	seen := make(map[string]bool)
+	for i, name := range header {
+		// Ignore the UTF-8 BOM that some tools add to CSV files:
+		if i == 0 {
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		name = strings.TrimSpace(name)
+		m.header[i] = name
+		// Known columns are matched in lowercase, unknown ones keep their original name:
+		key, known := strings.ToLower(name), true
+		switch key {
+		case columnDate, columnTime, columnSequence, columnAmount, columnCurrency, columnComment:
+		default:
+			key, known = name, false
+		}
+		if seen[key] {
+			rowErrors = append(rowErrors, RowError{Line: line, Column: key, Reason: "duplicated column"})
+			continue
+		}
+		seen[key] = true
+		if known {
+			m.index[key] = i
+		} else {
+			m.extra[i] = name
+		}
+	}
+	for _, name := range requiredColumns {
+		if _, ok := m.index[name]; !ok {
+			rowErrors = append(rowErrors, RowError{Line: line, Column: name, Reason: "missing required column"})
+		}
+	}
+	if len(rowErrors) > 0 {
+		return nil, &ParseError{Rows: rowErrors}
+	}
+	return m, nil
+}
+
+// parsePayments is a helper that takes an io.Reader with CSV data
+// and returns a list of payments ([]Payment) together with the list of rows that couldn't be parsed.
+// Header problems are returned as a *ParseError, other errors are returned when the data can't be read at all.
+func (p *PaymentsService) parsePayments(r io.Reader) (*ParseResult, error) {
+	return p.parsePaymentsAt(nil, r)
+}
+
+// parsePaymentsAt is like parsePayments, it also checks the date and time of every row
+// against a file location according to LocationPolicy, unless loc is nil.
+func (p *PaymentsService) parsePaymentsAt(loc *fileLocation, r io.Reader) (*ParseResult, error) {
+	result := &ParseResult{
+		Payments:   make([]Payment, 0),
+		Rejected:   make([]RowError, 0),
+		Consistent: true,
+	}
+	err := p.scanPayments(r, loc, func(payment Payment) error {
+		result.Payments = append(result.Payments, payment)
+		return nil
+	}, func(rowError RowError) error {
+		result.Rejected = append(result.Rejected, rowError)
+		if rowError.mismatch {
+			result.Consistent = false
+		}
+		return nil
+	}, func(rowError RowError) error {
+		result.Warnings = append(result.Warnings, rowError)
+		if rowError.mismatch {
+			result.Consistent = false
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// scanPayments is a helper that reads CSV data row by row, calling onPayment for every valid row,
+// onRejected for every row that couldn't be parsed and onWarning for rows accepted with a problem.
+// Only the current row is kept in memory, loc is used to check the date and time of every row when set.
+// Header problems are returned as a *ParseError, errors returned by the callbacks stop the scan and are returned as they are.
+func (p *PaymentsService) scanPayments(r io.Reader, loc *fileLocation, onPayment func(Payment) error, onRejected, onWarning func(RowError) error) (err error) {
+	onPayment, onRejected, done := p.observeScan(onPayment, onRejected)
+	defer func() { done(err) }()
+	return p.scanRows(r, loc, onPayment, onRejected, onWarning)
+}
+
+// scanRows is like scanPayments without notifying the observer, it's used to read a file again after checking it
+func (p *PaymentsService) scanRows(r io.Reader, loc *fileLocation, onPayment func(Payment) error, onRejected, onWarning func(RowError) error) error {
+	csvReader := csv.NewReader(r)
+	// Rows with a wrong number of columns are reported instead of failing the whole file:
+	csvReader.FieldsPerRecord = -1
+	// Fields are immutable strings, only the row slice itself is reused between rows:
+	csvReader.ReuseRecord = true
+	// Parse CSV header:
+	header, err := csvReader.Read()
+	if err != nil {
+		var csvErr *csv.ParseError
+		switch {
+		case err == io.EOF:
+			return &ParseError{Rows: []RowError{{Line: 1, Reason: errMissingHeader.Error()}}}
+		case errors.As(err, &csvErr):
+			return &ParseError{Rows: []RowError{{Line: csvErr.Line, Reason: csvErr.Err.Error()}}}
+		}
+		return err
+	}
+	headerLine, _ := csvReader.FieldPos(0)
+	columns, err := newColumnMapping(headerLine, header)
+	if err != nil {
+		return err
+	}
+	for {
+		row, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// CSV syntax errors only affect the current row:
+			var csvErr *csv.ParseError
+			if errors.As(err, &csvErr) {
+				if err := onRejected(RowError{Line: csvErr.Line, Reason: csvErr.Err.Error()}); err != nil {
+					return err
+				}
+				continue
+			}
+			return err
+		}
+		line, _ := csvReader.FieldPos(0)
+		payment, rowErrors := p.parseRow(columns, line, row)
+		if len(rowErrors) == 0 {
+			mismatches, reject := p.checkLocation(loc, columns, line, row, &payment)
+			if reject {
+				rowErrors = mismatches
+			} else {
+				for _, mismatch := range mismatches {
+					if err := onWarning(mismatch); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		if len(rowErrors) > 0 {
+			for _, rowError := range rowErrors {
+				if err := onRejected(rowError); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := onPayment(payment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseRow is a helper that builds a Payment from a CSV row,
+// every invalid field is reported as a RowError.
+func (p *PaymentsService) parseRow(columns *columnMapping, line int, row []string) (Payment, []RowError) {
+	if len(row) != len(columns.header) {
+		return Payment{}, []RowError{{
+			Line:   line,
+			Reason: fmt.Sprintf("expected %d columns, got %d", len(columns.header), len(row)),
+		}}
+	}
+	var rowErrors []RowError
+	field := func(name string) string {
+		return row[columns.index[name]]
+	}
+	invalidField := func(name string, err error) {
+		rowErrors = append(rowErrors, RowError{
+			Line:   line,
+			Column: name,
+			Value:  field(name),
+			Reason: err.Error(),
+		})
+	}
+	// Validate date and time:
+	if _, err := time.Parse(dateLayout, field(columnDate)); err != nil {
+		invalidField(columnDate, err)
+	}
+	if _, err := time.Parse(rowTimeLayout, field(columnTime)); err != nil {
+		invalidField(columnTime, err)
+	}
+	// Parse sequence:
+	sequence, err := strconv.Atoi(field(columnSequence))
+	if err != nil {
+		invalidField(columnSequence, err)
+	}
+	// Parse currency (optional) and amount fields:
+	currency := p.defaultCurrency()
+	validCurrency := true
+	if _, ok := columns.index[columnCurrency]; ok && field(columnCurrency) != "" {
+		currency = strings.ToUpper(strings.TrimSpace(field(columnCurrency)))
+		if _, err := CurrencyExponent(currency); err != nil {
+			invalidField(columnCurrency, err)
+			validCurrency = false
+		}
+	}
+	var amount Money
+	if validCurrency {
+		// An unsupported default currency is reported on the amount column:
+		if amount, err = ParseMoney(field(columnAmount), currency); err != nil {
+			invalidField(columnAmount, err)
+		}
+	}
+	if len(rowErrors) > 0 {
+		return Payment{}, rowErrors
+	}
+	// Convert date and time to a timestamp in the data time zone, both fields were validated above:
+	asOf, _ := time.ParseInLocation(timestampLayout, field(columnDate)+field(columnTime), p.timeZone())
+	// Build payment object:
+	payment := Payment{
+		AsOf:     asOf,
+		Sequence: sequence,
+		Amount:   amount,
+	}
+	// Parse comment (optional):
+	if _, ok := columns.index[columnComment]; ok {
+		payment.Comment = field(columnComment)
+	}
+	// Unknown columns are kept as they are:
+	if len(columns.extra) > 0 {
+		payment.Extra = make(map[string]string, len(columns.extra))
+		for i, name := range columns.extra {
+			payment.Extra[name] = row[i]
+		}
+	}
+	return payment, nil
+}
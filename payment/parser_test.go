@@ -0,0 +1,196 @@
+package payment
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+var (
+	// testInvalidRawCSV contains valid rows mixed with all kinds of invalid rows
+	testInvalidRawCSV = `date,time,sequence,amount,comment
+20220717,090000,211,500,payment2
+20220717,090000,212
+2022071,090000,213,500,payment4
+20220717,250000,214,500,payment5
+20220717,090000,xyz,abc,payment6
+20220717,090000,"21"5",500,payment7
+20220717,090000,216,700,payment8`
+
+	// testExpectedRowErrors lists the row errors reported for testInvalidRawCSV
+	testExpectedRowErrors = []RowError{
+		{Line: 3},
+		{Line: 4, Column: "date", Value: "2022071"},
+		{Line: 5, Column: "time", Value: "250000"},
+		{Line: 6, Column: "sequence", Value: "xyz"},
+		{Line: 6, Column: "amount", Value: "abc"},
+		{Line: 7},
+	}
+)
+
+// TestParsePaymentsRowErrors covers the row-level error report of parsePayments
+func TestParsePaymentsRowErrors(t *testing.T) {
+	paymentsService, _ := serviceWithMemoryStore()
+	result, err := paymentsService.parsePayments(strings.NewReader(testInvalidRawCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Payments) != 2 {
+		t.Fatalf("invalid payments length, got %d, expected %d", len(result.Payments), 2)
+	}
+	if result.Payments[0].Sequence != 211 || result.Payments[1].Sequence != 216 {
+		t.Fatalf("unexpected payments %v", result.Payments)
+	}
+	if len(result.Rejected) != len(testExpectedRowErrors) {
+		t.Fatalf("invalid rejected rows length, got %d, expected %d: %v", len(result.Rejected), len(testExpectedRowErrors), result.Rejected)
+	}
+	for i, rowError := range result.Rejected {
+		expected := testExpectedRowErrors[i]
+		if rowError.Line != expected.Line || rowError.Column != expected.Column || rowError.Value != expected.Value {
+			t.Fatalf("unexpected row error at position %d, got %+v, expected %+v", i, rowError, expected)
+		}
+		if rowError.Reason == "" {
+			t.Fatalf("row error at position %d doesn't have a reason", i)
+		}
+	}
+	t.Run("missing header", func(t *testing.T) {
+		if _, err := paymentsService.parsePayments(strings.NewReader("")); err == nil {
+			t.Fatal("should error")
+		}
+	})
+}
+
+// TestParseModes covers GetPayments and GetPaymentsReport with both parse modes
+func TestParseModes(t *testing.T) {
+	paymentsService, store := serviceWithMemoryStore()
+	store.AddFile("20220717", "090000.payments", []byte(testInvalidRawCSV))
+	t.Run("lenient", func(t *testing.T) {
+		paymentsService.ParseMode = ParseLenient
+		payments, err := paymentsService.GetPayments("20220717/090000.payments")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(payments) != 2 {
+			t.Fatalf("invalid payments length, got %d, expected %d", len(payments), 2)
+		}
+		result, err := paymentsService.GetPaymentsReport("20220717/090000.payments")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Rejected) != len(testExpectedRowErrors) {
+			t.Fatalf("invalid rejected rows length, got %d, expected %d", len(result.Rejected), len(testExpectedRowErrors))
+		}
+	})
+	t.Run("strict", func(t *testing.T) {
+		paymentsService.ParseMode = ParseStrict
+		_, err := paymentsService.GetPayments("20220717/090000.payments")
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Fatalf("expected *ParseError, got %v", err)
+		}
+		if len(parseErr.Rows) != len(testExpectedRowErrors) {
+			t.Fatalf("invalid row errors length, got %d, expected %d", len(parseErr.Rows), len(testExpectedRowErrors))
+		}
+		// Valid files are unaffected by strict mode:
+		store.AddFile("20220717", "100000.payments", []byte(testRawCSV))
+		if _, err := paymentsService.GetPayments("20220717/100000.payments"); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// TestParsePaymentsHeader covers the header-driven column mapping of parsePayments
+func TestParsePaymentsHeader(t *testing.T) {
+	paymentsService, _ := serviceWithMemoryStore()
+	t.Run("reordered and extra columns", func(t *testing.T) {
+		rawCSV := `Amount,branch,Sequence,comment, date ,time,Channel,channel
+500,asuncion,211,payment2,20220717,090000,web,app`
+		result, err := paymentsService.parsePayments(strings.NewReader(rawCSV))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Payments) != 1 {
+			t.Fatalf("invalid payments length, got %d, expected %d", len(result.Payments), 1)
+		}
+		payment := result.Payments[0]
+		if err := testValidatePayment(&payment); err != nil {
+			t.Fatal(err)
+		}
+		// Extra columns keep the names used in the header:
+		if len(payment.Extra) != 3 || payment.Extra["branch"] != "asuncion" || payment.Extra["Channel"] != "web" || payment.Extra["channel"] != "app" {
+			t.Fatalf("unexpected extra columns %v", payment.Extra)
+		}
+	})
+	t.Run("optional comment column", func(t *testing.T) {
+		rawCSV := `date,time,sequence,amount
+20220717,090000,211,500`
+		result, err := paymentsService.parsePayments(strings.NewReader(rawCSV))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Payments) != 1 || result.Payments[0].Comment != "" || result.Payments[0].Extra != nil {
+			t.Fatalf("unexpected payments %v", result.Payments)
+		}
+	})
+	t.Run("missing and duplicated columns", func(t *testing.T) {
+		rawCSV := `date,time,comment,date
+20220717,090000,payment2,20220717`
+		_, err := paymentsService.parsePayments(strings.NewReader(rawCSV))
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Fatalf("expected *ParseError, got %v", err)
+		}
+		expectedColumns := []string{"date", "sequence", "amount"}
+		if len(parseErr.Rows) != len(expectedColumns) {
+			t.Fatalf("invalid row errors length, got %d, expected %d: %v", len(parseErr.Rows), len(expectedColumns), parseErr.Rows)
+		}
+		for i, rowError := range parseErr.Rows {
+			if rowError.Line != 1 || rowError.Column != expectedColumns[i] {
+				t.Fatalf("unexpected row error at position %d: %+v", i, rowError)
+			}
+		}
+	})
+}
+
+// TestParsePaymentsCurrency covers decimal amounts and the optional currency column
+func TestParsePaymentsCurrency(t *testing.T) {
+	paymentsService, _ := serviceWithMemoryStore()
+	rawCSV := `date,time,sequence,amount,currency,comment
+20220717,090000,211,10.50,usd,payment2
+20220717,090000,212,150000,PYG,payment3
+20220717,090000,213,7.25,,payment4
+20220717,090000,214,10.505,USD,payment5
+20220717,090000,215,10,XYZ,payment6`
+	result, err := paymentsService.parsePayments(strings.NewReader(rawCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedAmounts := []Money{
+		{Minor: 1050, Currency: "USD"},
+		{Minor: 150000, Currency: "PYG"},
+		{Minor: 725, Currency: "USD"},
+	}
+	if len(result.Payments) != len(expectedAmounts) {
+		t.Fatalf("invalid payments length, got %d, expected %d", len(result.Payments), len(expectedAmounts))
+	}
+	for i, payment := range result.Payments {
+		if payment.Amount != expectedAmounts[i] {
+			t.Fatalf("invalid amount at position %d, got %s, expected %s", i, payment.Amount, expectedAmounts[i])
+		}
+	}
+	if len(result.Rejected) != 2 || result.Rejected[0].Column != "amount" || result.Rejected[1].Column != "currency" {
+		t.Fatalf("unexpected row errors %v", result.Rejected)
+	}
+	t.Run("configured default currency", func(t *testing.T) {
+		paymentsService.DefaultCurrency = "EUR"
+		defer func() { paymentsService.DefaultCurrency = "" }()
+		result, err := paymentsService.parsePayments(strings.NewReader(testRawCSV))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedAmount := Money{Minor: 50000, Currency: "EUR"}
+		if result.Payments[0].Amount != expectedAmount {
+			t.Fatalf("invalid amount, got %s, expected %s", result.Payments[0].Amount, expectedAmount)
+		}
+	})
+}
@@ -1,15 +1,11 @@
 package payment
 
 import (
-	"bytes"
-	"encoding/csv"
-	"fmt"
-	"io"
-	"io/ioutil"
+	"encoding/json"
+	"errors"
 	"log"
-	"os"
-	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -17,40 +13,124 @@ const (
 	// These layouts are used to validate both file names and directory names:
 	dateLayout = "20060102"
 	timeLayout = "150405.payments"
+	// rowTimeLayout is used to validate the time column of payments files:
+	rowTimeLayout = "150405"
 )
 
 // PaymentsService is the base building block of the payments service
 type PaymentsService struct {
+	// BaseDir is only set when the service is backed by the local filesystem:
 	BaseDir string
+	// Store is the storage backend used to list and read payments files:
+	Store Store
+	// ParseMode controls how invalid rows are handled when reading payments files:
+	ParseMode ParseMode
+	// DefaultCurrency is used for files without a currency column, DefaultCurrency (USD) is used when empty:
+	DefaultCurrency string
+	// Cache keeps parsed files in memory when set:
+	Cache *Cache
+	// LocationPolicy controls rows whose date and time don't match the directory and file name, LocationWarn by default:
+	LocationPolicy LocationPolicy
+	// TimeZone is the time zone of the dates and times found in the data directory, UTC is used when nil:
+	TimeZone *time.Location
+	// Observer is notified about every parsed payments file when set:
+	Observer ParseObserver
 }
 
 // Payment is the data structure used by the external representation format:
 type Payment struct {
-	// AsOf uses date+time format: 20220717063000
-	AsOf     int    `json:"asOf"`
-	Sequence int    `json:"sequence"`
-	Amount   int    `json:"amount"`
-	Comment  string `json:"comment,omitempty"`
+	// AsOf is the date and time of the payment in the time zone of the data directory,
+	// see MarshalJSON for its JSON representation:
+	AsOf     time.Time `json:"-"`
+	Sequence int       `json:"sequence"`
+	Amount   Money     `json:"amount"`
+	Comment  string    `json:"comment,omitempty"`
+	// Extra contains the columns that aren't part of the standard format, keyed by their header name:
+	Extra map[string]string `json:"extra,omitempty"`
+}
+
+// paymentJSON is the JSON representation of Payment
+type paymentJSON struct {
+	// AsOf is the legacy date+time format: 20220717063000
+	AsOf int `json:"asOf"`
+	// Timestamp is the RFC 3339 representation of AsOf, including the time zone offset:
+	Timestamp string `json:"timestamp"`
+	Sequence  int    `json:"sequence"`
+	// Amount is the legacy integer format, in major units, it's omitted for amounts with a fractional part:
+	Amount *int64 `json:"amount,omitempty"`
+	// Money is the amount with its currency, see Money.MarshalJSON:
+	Money   Money             `json:"money"`
+	Comment string            `json:"comment,omitempty"`
+	Extra   map[string]string `json:"extra,omitempty"`
+}
+
+// LegacyAsOf returns AsOf in the date+time format used before timestamps were added: 20220717063000
+func (p Payment) LegacyAsOf() int {
+	asOf, _ := strconv.Atoi(p.AsOf.Format(timestampLayout))
+	return asOf
+}
+
+// MarshalJSON renders AsOf both in the legacy format and as an RFC 3339 timestamp, and Amount both in the legacy
+// integer format and with its currency, e.g. {"asOf":20220717063000,"timestamp":"2022-07-17T06:30:00Z","amount":10,"money":{...},...}
+func (p Payment) MarshalJSON() ([]byte, error) {
+	v := paymentJSON{
+		AsOf:      p.LegacyAsOf(),
+		Timestamp: p.AsOf.Format(time.RFC3339),
+		Sequence:  p.Sequence,
+		Money:     p.Amount,
+		Comment:   p.Comment,
+		Extra:     p.Extra,
+	}
+	if major, ok := p.Amount.Major(); ok {
+		v.Amount = &major
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON reads the timestamp and money fields, the legacy asOf field is read as UTC when there's no timestamp
+// and the legacy amount field is read in DefaultCurrency when there's no money field
+func (p *Payment) UnmarshalJSON(data []byte) error {
+	var v paymentJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*p = Payment{Sequence: v.Sequence, Amount: v.Money, Comment: v.Comment, Extra: v.Extra}
+	var err error
+	if v.Money.Currency == "" && v.Amount != nil {
+		if p.Amount, err = ParseMoney(strconv.FormatInt(*v.Amount, 10), DefaultCurrency); err != nil {
+			return err
+		}
+	}
+	if v.Timestamp != "" {
+		p.AsOf, err = time.Parse(time.RFC3339, v.Timestamp)
+	} else {
+		p.AsOf, err = time.Parse(timestampLayout, strconv.Itoa(v.AsOf))
+	}
+	return err
 }
 
 // NewWithBaseDir initializes PaymentsService with a given base data directory (BaseDir):
 func NewWithBaseDir(baseDir string) (*PaymentsService, error) {
-	// Ensure it's possible to read the base directory:
-	if _, err := os.ReadDir(baseDir); err != nil {
+	store, err := NewFSStore(baseDir)
+	if err != nil {
 		return nil, err
 	}
-	return &PaymentsService{BaseDir: baseDir}, nil
+	return &PaymentsService{BaseDir: baseDir, Store: store}, nil
+}
+
+// NewWithStore initializes PaymentsService with a given storage backend:
+func NewWithStore(store Store) *PaymentsService {
+	return &PaymentsService{Store: store}
 }
 
-// ListDirectories lists all directories available in the base data directory (BaseDir):
+// ListDirectories lists all directories available in the store:
 func (p *PaymentsService) ListDirectories() ([]string, error) {
-	entries, err := os.ReadDir(p.BaseDir)
+	names, err := p.Store.ListPartitions()
 	if err != nil {
 		return nil, err
 	}
 	directories := make([]string, 0)
-	for _, entry := range entries {
-		name := entry.Name()
+	for _, name := range names {
 		// Ensure the directory name is valid, print a warning and skip the entry if not:
 		if err := p.validateDirName(name); err != nil {
 			log.Println(err)
@@ -63,14 +143,15 @@ func (p *PaymentsService) ListDirectories() ([]string, error) {
 
 // ListPayments takes a given directory and lists its payment files:
 func (p *PaymentsService) ListPayments(dir string) ([]string, error) {
-	paymentsDirPath := filepath.Join(p.BaseDir, dir)
-	entries, err := os.ReadDir(paymentsDirPath)
+	if err := p.validateDirName(dir); err != nil {
+		return nil, err
+	}
+	names, err := p.Store.ListFiles(dir)
 	if err != nil {
 		return nil, err
 	}
 	payments := make([]string, 0)
-	for _, entry := range entries {
-		name := entry.Name()
+	for _, name := range names {
 		// Ensure the file name is valid, print a warning and skip the entry if not:
 		if err := p.validateFileName(name); err != nil {
 			log.Println(err)
@@ -81,59 +162,69 @@ func (p *PaymentsService) ListPayments(dir string) ([]string, error) {
 	return payments, nil
 }
 
-// parsePayments is a helper that takes an io.Reader with CSV data
-// and returns a list of payments ([]Payment)
-func (p *PaymentsService) parsePayments(r io.Reader) ([]Payment, error) {
-	csvReader := csv.NewReader(r)
-	records, err := csvReader.ReadAll()
+// CountPayments counts the date directories and payments files in a single pass over the store.
+// Invalid names are skipped without warnings and so are directories that can't be listed,
+// so it can be called often, e.g. to expose metrics.
+func (p *PaymentsService) CountPayments() (dirs, files int, err error) {
+	names, err := p.Store.ListPartitions()
 	if err != nil {
-		return nil, err
+		return 0, 0, err
 	}
-	payments := make([]Payment, 0)
-	for i, row := range records {
-		// Skip CSV header:
-		if i == 0 {
-			continue
-		}
-		// Parse and convert date and time to int:
-		dateTimeStr := row[0] + row[1]
-		dateTime, err := strconv.Atoi(dateTimeStr)
-		if err != nil {
-			log.Printf("Invalid date/time field in row %d: %s\n", i, err.Error())
-			continue
-		}
-		// Parse sequence:
-		sequence, err := strconv.Atoi(row[2])
-		if err != nil {
-			log.Printf("Invalid sequence field in row %d: %s\n", i, err.Error())
+	for _, dir := range names {
+		if err := p.validateDirName(dir); err != nil {
 			continue
 		}
-		// Parse amount field:
-		amount, err := strconv.Atoi(row[3])
+		dirs++
+		fileNames, err := p.Store.ListFiles(dir)
 		if err != nil {
-			log.Printf("Invalid amount field in row %d: %s\n", i, err.Error())
 			continue
 		}
-		// Parse comment (optional)
-		comment := row[4]
-
-		// Build payment object:
-		payment := Payment{
-			AsOf:     dateTime,
-			Sequence: sequence,
-			Amount:   amount,
-			Comment:  comment,
+		for _, name := range fileNames {
+			if err := p.validateFileName(name); err == nil {
+				files++
+			}
 		}
-		payments = append(payments, payment)
 	}
-	return payments, nil
+	return dirs, files, nil
+}
+
+// defaultCurrency returns the currency used for rows without a currency column:
+func (p *PaymentsService) defaultCurrency() string {
+	if p.DefaultCurrency == "" {
+		return DefaultCurrency
+	}
+	return p.DefaultCurrency
+}
+
+// timeZone is a helper that returns the time zone of the data directory
+func (p *PaymentsService) timeZone() *time.Location {
+	if p.TimeZone == nil {
+		return time.UTC
+	}
+	return p.TimeZone
+}
+
+// splitPath is a helper that splits a YYYYMMDD/HHMMSS.payments path and validates both names,
+// so paths can never reference anything outside of the date directories:
+func (p *PaymentsService) splitPath(path string) (dir, name string, err error) {
+	parts := strings.Split(path, "/")
+	if len(parts) != 2 {
+		return "", "", &NameError{Kind: "payments path", Name: path, Err: errors.New("expected YYYYMMDD/HHMMSS.payments")}
+	}
+	if err := p.validateDirName(parts[0]); err != nil {
+		return "", "", err
+	}
+	if err := p.validateFileName(parts[1]); err != nil {
+		return "", "", err
+	}
+	return parts[0], parts[1], nil
 }
 
 // validateDirName validates an input string against the YYYYMMDD format:
 func (p *PaymentsService) validateDirName(s string) error {
 	_, err := time.Parse(dateLayout, s)
 	if err != nil {
-		return fmt.Errorf("invalid directory name '%s': %s", s, err.Error())
+		return &NameError{Kind: "directory", Name: s, Err: err}
 	}
 	return nil
 }
@@ -142,17 +233,71 @@ func (p *PaymentsService) validateDirName(s string) error {
 func (p *PaymentsService) validateFileName(s string) error {
 	_, err := time.Parse(timeLayout, s)
 	if err != nil {
-		return fmt.Errorf("invalid file name '%s': %s", s, err.Error())
+		return &NameError{Kind: "file", Name: s, Err: err}
 	}
 	return nil
 }
 
-// GetPayments parses a given file and returns its external representation format:
+// GetPayments parses a given file and returns its external representation format.
+// In lenient mode invalid rows are logged and skipped, in strict mode they make GetPayments return a *ParseError.
 func (p *PaymentsService) GetPayments(path string) ([]Payment, error) {
-	paymentsPath := filepath.Join(p.BaseDir, path)
-	rawCSV, err := ioutil.ReadFile(paymentsPath)
+	result, err := p.GetPaymentsReport(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, rowError := range result.Rejected {
+		log.Printf("%s: %s\n", path, rowError.Error())
+	}
+	for _, rowError := range result.Warnings {
+		log.Printf("%s: %s\n", path, rowError.Error())
+	}
+	return result.Payments, nil
+}
+
+// GetPaymentsReport parses a given file and returns both its payments and the rows that were rejected.
+// In strict mode any rejected row makes GetPaymentsReport return a *ParseError instead.
+func (p *PaymentsService) GetPaymentsReport(path string) (*ParseResult, error) {
+	dir, name, err := p.splitPath(path)
+	if err != nil {
+		return nil, err
+	}
+	result, err := p.readPayments(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	if p.ParseMode == ParseStrict && len(result.Rejected) > 0 {
+		return nil, &ParseError{Rows: result.Rejected}
+	}
+	return result, nil
+}
+
+// readPayments is a helper that parses a payments file, going through the cache when it's enabled
+func (p *PaymentsService) readPayments(dir, name string) (*ParseResult, error) {
+	if p.Cache == nil {
+		return p.parseFile(dir, name)
+	}
+	fi, err := p.Store.Stat(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	key := dir + "/" + name
+	if result, ok := p.Cache.Get(key, fi.ModTime(), fi.Size()); ok {
+		return result, nil
+	}
+	result, err := p.parseFile(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	p.Cache.Put(key, fi.ModTime(), fi.Size(), result)
+	return result, nil
+}
+
+// parseFile is a helper that opens and parses a payments file
+func (p *PaymentsService) parseFile(dir, name string) (*ParseResult, error) {
+	f, err := p.Store.Open(dir, name)
 	if err != nil {
 		return nil, err
 	}
-	return p.parsePayments(bytes.NewReader(rawCSV))
+	defer f.Close()
+	return p.parsePaymentsAt(newFileLocation(dir, name), f)
 }
@@ -1,12 +1,12 @@
 package payment
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"os"
-	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 var (
@@ -36,12 +36,7 @@ func TestNewWithBaseDir(t *testing.T) {
 		}
 	})
 	t.Run("initialize with an existing directory", func(t *testing.T) {
-		tempDir, err := ioutil.TempDir("/tmp", "test")
-		if err != nil {
-			t.Fatal(err)
-		}
-		defer os.RemoveAll(tempDir)
-		paymentsService, err := NewWithBaseDir(tempDir)
+		paymentsService, err := NewWithBaseDir(t.TempDir())
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -53,44 +48,31 @@ func TestNewWithBaseDir(t *testing.T) {
 
 // TestParsePayments covers parsePayments functionality
 func TestParsePayments(t *testing.T) {
-	paymentsService, tempDir, err := serviceWithTempDir()
+	paymentsService, _ := serviceWithMemoryStore()
+	result, err := paymentsService.parsePayments(strings.NewReader(testRawCSV))
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer os.RemoveAll(tempDir)
-	payments, err := paymentsService.parsePayments(strings.NewReader(testRawCSV))
-	if err != nil {
-		t.Fatal(err)
+	if len(result.Payments) != 1 {
+		t.Fatalf("invalid payments length, got %d, expected %d", len(result.Payments), 1)
 	}
-	if len(payments) != 1 {
-		t.Fatalf("invalid payments length, got %d, expected %d", len(payments), 1)
+	if len(result.Rejected) != 0 {
+		t.Fatalf("invalid rejected rows length, got %d, expected %d", len(result.Rejected), 0)
 	}
-	if err := testValidatePayment(&payments[0]); err != nil {
+	if err := testValidatePayment(&result.Payments[0]); err != nil {
 		t.Fatal(err)
 	}
 }
 
-// serviceWithTempDir is a helper that initializes PaymentsService with a temp dir
-// The caller takes care of temp dir removal
-func serviceWithTempDir() (*PaymentsService, string, error) {
-	tempDir, err := ioutil.TempDir("/tmp", "test")
-	if err != nil {
-		return nil, "", err
-	}
-	paymentsService, err := NewWithBaseDir(tempDir)
-	if err != nil {
-		return nil, "", err
-	}
-	return paymentsService, tempDir, nil
+// serviceWithMemoryStore is a helper that initializes PaymentsService with an empty MemoryStore
+func serviceWithMemoryStore() (*PaymentsService, *MemoryStore) {
+	store := NewMemoryStore()
+	return NewWithStore(store), store
 }
 
 // TestListDirectories is a basic test for ListDirectories functionality
 func TestListDirectories(t *testing.T) {
-	paymentsService, tempDir, err := serviceWithTempDir()
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer os.RemoveAll(tempDir)
+	paymentsService, _ := serviceWithMemoryStore()
 	payments, err := paymentsService.ListDirectories()
 	if err != nil {
 		t.Fatal(err)
@@ -99,16 +81,8 @@ func TestListDirectories(t *testing.T) {
 		t.Fatal("invalid initial directory length, expected 0")
 	}
 	t.Run("list single valid directory", func(t *testing.T) {
-		paymentsService, tempDir, err := serviceWithTempDir()
-		if err != nil {
-			t.Fatal(err)
-		}
-		defer os.RemoveAll(tempDir)
-		testDirectoryPath := filepath.Join(tempDir, "20220717")
-		if os.Mkdir(testDirectoryPath, 0700); err != nil {
-			t.Fatal(err)
-		}
-		defer os.Remove(testDirectoryPath)
+		paymentsService, store := serviceWithMemoryStore()
+		store.AddPartition("20220717")
 		payments, _ := paymentsService.ListDirectories()
 		if len(payments) != 1 {
 			t.Fatal("invalid directory length, expected 1")
@@ -116,46 +90,23 @@ func TestListDirectories(t *testing.T) {
 	})
 
 	t.Run("list invalid directories", func(t *testing.T) {
-		paymentsService, tempDir, err := serviceWithTempDir()
-		if err != nil {
-			t.Fatal(err)
-		}
-		defer os.RemoveAll(tempDir)
+		paymentsService, store := serviceWithMemoryStore()
 		for _, invalidDir := range testInvalidDirectories {
-			testDirectoryPath := filepath.Join(tempDir, invalidDir)
-			if os.Mkdir(testDirectoryPath, 0700); err != nil {
-				t.Fatal(err)
-			}
+			store.AddPartition(invalidDir)
 			// The returned error is ignored here because ListDirectories only warns when invalid directories are used:
 			payments, _ := paymentsService.ListDirectories()
 			if len(payments) != 0 {
-
 				t.Fatal("invalid directory length, should be 0")
 			}
-			os.Remove(testDirectoryPath)
 		}
 	})
 }
 
 // TestListPayments covers ListPayments functionality
 func TestListPayments(t *testing.T) {
-	paymentsService, tempDir, err := serviceWithTempDir()
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer os.RemoveAll(tempDir)
-	testDirPath := filepath.Join(tempDir, "20220717")
-	if err := os.Mkdir(testDirPath, 0700); err != nil {
-		t.Fatal(err)
-	}
-	defer os.RemoveAll(testDirPath)
+	paymentsService, store := serviceWithMemoryStore()
 	testPaymentsFileName := "090030.payments"
-	testFilePath := filepath.Join(testDirPath, testPaymentsFileName)
-	testFile, err := os.Create(testFilePath)
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer testFile.Close()
+	store.AddFile("20220717", testPaymentsFileName, nil)
 	payments, err := paymentsService.ListPayments("20220717")
 	if err != nil {
 		t.Fatal(err)
@@ -164,29 +115,14 @@ func TestListPayments(t *testing.T) {
 		t.Fatalf("invalid payments length, got %d, expected %d", len(payments), 1)
 	}
 	if strings.Compare(payments[0], testPaymentsFileName) != 0 {
-		t.Fatalf("invalid payments file name, got '%s', expected '%s'", payments[1], testPaymentsFileName)
+		t.Fatalf("invalid payments file name, got '%s', expected '%s'", payments[0], testPaymentsFileName)
 	}
 }
 
 // TestGetPayments covers GetPayments functionality
 func TestGetPayments(t *testing.T) {
-	paymentsService, tempDir, err := serviceWithTempDir()
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer os.RemoveAll(tempDir)
-	testDirPath := filepath.Join(tempDir, "20220717")
-	if err := os.Mkdir(testDirPath, 0700); err != nil {
-		t.Fatal(err)
-	}
-	defer os.RemoveAll(testDirPath)
-	testPaymentsFileName := "090030.payments"
-	testFilePath := filepath.Join(testDirPath, testPaymentsFileName)
-	err = ioutil.WriteFile(testFilePath, []byte(testRawCSV), 0700)
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer os.Remove(testFilePath)
+	paymentsService, store := serviceWithMemoryStore()
+	store.AddFile("20220717", "090030.payments", []byte(testRawCSV))
 	payments, err := paymentsService.GetPayments("20220717/090030.payments")
 	if err != nil {
 		t.Fatal(err)
@@ -199,13 +135,36 @@ func TestGetPayments(t *testing.T) {
 	}
 }
 
+// TestPathTraversal covers name validation on all service entry points
+func TestPathTraversal(t *testing.T) {
+	paymentsService, store := serviceWithMemoryStore()
+	store.AddFile("20220717", "090000.payments", []byte(testRawCSV))
+	invalidPaths := []string{
+		"../20220717/090000.payments",
+		"20220717/../090000.payments",
+		"20220717/090000.payments/..",
+		"/20220717/090000.payments",
+		"20220717//090000.payments",
+		"20220717\\..\\090000.payments",
+		"%2e%2e/090000.payments",
+		"..",
+		"",
+	}
+	for _, path := range invalidPaths {
+		if _, err := paymentsService.GetPayments(path); !errors.Is(err, ErrInvalidName) {
+			t.Fatalf("expected ErrInvalidName with path '%s', got %v", path, err)
+		}
+	}
+	for _, dir := range []string{"..", "../20220717", "20220717/..", ".", "", "%2e%2e"} {
+		if _, err := paymentsService.ListPayments(dir); !errors.Is(err, ErrInvalidName) {
+			t.Fatalf("expected ErrInvalidName with directory '%s', got %v", dir, err)
+		}
+	}
+}
+
 // TestValidateDirName covers validateDirName functionality using sample inputs
 func TestValidateDirName(t *testing.T) {
-	paymentsService, tempDir, err := serviceWithTempDir()
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer os.RemoveAll(tempDir)
+	paymentsService, _ := serviceWithMemoryStore()
 	for _, d := range testInvalidDirectories {
 		if err := paymentsService.validateDirName(d); err == nil {
 			t.Fatalf("should error with dir name '%s'", d)
@@ -220,11 +179,7 @@ func TestValidateDirName(t *testing.T) {
 
 // TestValidateFileName covers validateFileName functionality using sample inputs
 func TestValidateFileName(t *testing.T) {
-	paymentsService, tempDir, err := serviceWithTempDir()
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer os.RemoveAll(tempDir)
+	paymentsService, _ := serviceWithMemoryStore()
 	for _, f := range testInvalidFiles {
 		if err := paymentsService.validateFileName(f); err == nil {
 			t.Fatalf("should error with filename '%s'", f)
@@ -237,19 +192,28 @@ func TestValidateFileName(t *testing.T) {
 	}
 }
 
+// testTimestamp is a helper that parses a date+time string like 20220717090000 as UTC
+func testTimestamp(s string) time.Time {
+	t, _ := ParseTimestamp(s)
+	return t
+}
+
 // testValidatePayment is a helper to validate the payment record that's contained in testRawCSV
 func testValidatePayment(payment *Payment) error {
 	expectedAsOfVal := 20220717090000
-	if payment.AsOf != expectedAsOfVal {
-		return fmt.Errorf("invalid asOf value, got %d, expected %d", payment.AsOf, expectedAsOfVal)
+	if payment.LegacyAsOf() != expectedAsOfVal {
+		return fmt.Errorf("invalid asOf value, got %d, expected %d", payment.LegacyAsOf(), expectedAsOfVal)
+	}
+	if !payment.AsOf.Equal(testTimestamp("20220717090000")) {
+		return fmt.Errorf("invalid asOf value, got %s, expected UTC", payment.AsOf)
 	}
 	expectedSequence := 211
 	if payment.Sequence != expectedSequence {
 		return fmt.Errorf("invalid sequence value, got %d, expected %d", payment.Sequence, expectedSequence)
 	}
-	expectedAmount := 500
+	expectedAmount := Money{Minor: 50000, Currency: "USD"}
 	if payment.Amount != expectedAmount {
-		return fmt.Errorf("invalid amount value, got %d, expected %d", payment.Amount, expectedAmount)
+		return fmt.Errorf("invalid amount value, got %s, expected %s", payment.Amount, expectedAmount)
 	}
 	expectedComment := "payment2"
 	if strings.Compare(expectedComment, payment.Comment) != 0 {
@@ -257,3 +221,94 @@ func testValidatePayment(payment *Payment) error {
 	}
 	return nil
 }
+
+// TestTimeZone covers timestamps of data directories that aren't in UTC
+func TestTimeZone(t *testing.T) {
+	paymentsService, store := serviceWithMemoryStore()
+	paymentsService.TimeZone = time.FixedZone("UTC-3", -3*60*60)
+	store.AddFile("20220717", "090000.payments", []byte(testRawCSV))
+
+	t.Run("parse", func(t *testing.T) {
+		payments, err := paymentsService.GetPayments("20220717/090000.payments")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := testTimestamp("20220717120000")
+		if !payments[0].AsOf.Equal(expected) {
+			t.Fatalf("invalid asOf value, got %s, expected %s", payments[0].AsOf, expected)
+		}
+		if payments[0].LegacyAsOf() != 20220717090000 {
+			t.Fatalf("invalid legacy asOf value, got %d, expected %d", payments[0].LegacyAsOf(), 20220717090000)
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		payments, err := paymentsService.GetPayments("20220717/090000.payments")
+		if err != nil {
+			t.Fatal(err)
+		}
+		output, err := json.Marshal(payments[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, field := range []string{`"asOf":20220717090000`, `"timestamp":"2022-07-17T09:00:00-03:00"`, `"amount":500`, `"money":{"minorUnits":50000`} {
+			if !strings.Contains(string(output), field) {
+				t.Fatalf("expected %s in %s", field, output)
+			}
+		}
+		var decoded Payment
+		if err := json.Unmarshal(output, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if !decoded.AsOf.Equal(payments[0].AsOf) {
+			t.Fatalf("invalid asOf value, got %s, expected %s", decoded.AsOf, payments[0].AsOf)
+		}
+		if decoded.Amount != payments[0].Amount {
+			t.Fatalf("invalid amount value, got %s, expected %s", decoded.Amount, payments[0].Amount)
+		}
+		// Legacy documents without a timestamp are read as UTC, and their amounts in the default currency:
+		if err := json.Unmarshal([]byte(`{"asOf":20220717090000,"sequence":1,"amount":500}`), &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if !decoded.AsOf.Equal(testTimestamp("20220717090000")) {
+			t.Fatalf("invalid asOf value, got %s", decoded.AsOf)
+		}
+		if expected := (Money{Minor: 50000, Currency: DefaultCurrency}); decoded.Amount != expected {
+			t.Fatalf("invalid amount value, got %s, expected %s", decoded.Amount, expected)
+		}
+		// Amounts with a fractional part don't have a legacy representation:
+		output, err = json.Marshal(Payment{AsOf: payments[0].AsOf, Amount: Money{Minor: 1050, Currency: "USD"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(output), `"amount"`) {
+			t.Fatalf("unexpected legacy amount in %s", output)
+		}
+	})
+
+	t.Run("range", func(t *testing.T) {
+		from, err := paymentsService.ParseTimestamp("2022-07-17T11:00:00Z")
+		if err != nil {
+			t.Fatal(err)
+		}
+		to, err := paymentsService.ParseTimestamp("20220717100000")
+		if err != nil {
+			t.Fatal(err)
+		}
+		payments, err := paymentsService.QueryRange(from, to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(payments) != 1 {
+			t.Fatalf("invalid number of payments, got %d, expected %d", len(payments), 1)
+		}
+		// The file is at 12:00 UTC, so a UTC range ending at 10:00 doesn't include it:
+		payments, err = paymentsService.QueryRange(testTimestamp("20220717000000"), testTimestamp("20220717100000"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(payments) != 0 {
+			t.Fatalf("invalid number of payments, got %d, expected %d", len(payments), 0)
+		}
+	})
+}
@@ -0,0 +1,103 @@
+package payment
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+const (
+	// timestampLayout is used by range queries, it matches the legacy AsOf format: 20220717063000
+	timestampLayout = "20060102150405"
+)
+
+// ParseTimestamp parses a date+time string like 20220717063000 as UTC:
+func ParseTimestamp(s string) (time.Time, error) {
+	return parseTimestamp(s, time.UTC)
+}
+
+// ParseTimestamp parses a date+time string like 20220717063000 in the time zone of the data directory,
+// RFC 3339 timestamps like 2022-07-17T06:30:00-03:00 are also accepted:
+func (p *PaymentsService) ParseTimestamp(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return parseTimestamp(s, p.timeZone())
+}
+
+// parseTimestamp is a helper that parses a date+time string in a given time zone
+func parseTimestamp(s string, loc *time.Location) (time.Time, error) {
+	t, err := time.ParseInLocation(timestampLayout, s, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp '%s': %s", s, err.Error())
+	}
+	return t, nil
+}
+
+// fileTimestamp is a helper that returns the timestamp encoded in a directory and file name pair,
+// e.g. 20220717 and 063000.payments:
+func (p *PaymentsService) fileTimestamp(dir, name string) (time.Time, error) {
+	return time.ParseInLocation(dateLayout+timeLayout, dir+name, p.timeZone())
+}
+
+// QueryRange returns the payments of all files whose timestamp (as encoded in the directory and file names)
+// is within the [from, to] interval. Records are merged and sorted by AsOf and Sequence.
+func (p *PaymentsService) QueryRange(from, to time.Time) ([]Payment, error) {
+	paths, err := p.filesInRange(from, to)
+	if err != nil {
+		return nil, err
+	}
+	payments := make([]Payment, 0)
+	for _, path := range paths {
+		filePayments, err := p.GetPayments(path)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, filePayments...)
+	}
+	sort.SliceStable(payments, func(i, j int) bool {
+		if !payments[i].AsOf.Equal(payments[j].AsOf) {
+			return payments[i].AsOf.Before(payments[j].AsOf)
+		}
+		return payments[i].Sequence < payments[j].Sequence
+	})
+	return payments, nil
+}
+
+// filesInRange is a helper that lists the paths of all files whose timestamp is within the [from, to] interval,
+// like YYYYMMDD/HHMMSS.payments
+func (p *PaymentsService) filesInRange(from, to time.Time) ([]string, error) {
+	if to.Before(from) {
+		return nil, fmt.Errorf("invalid range: %s is before %s", to.Format(timestampLayout), from.Format(timestampLayout))
+	}
+	dirs, err := p.ListDirectories()
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0)
+	for _, dir := range dirs {
+		// Skip directories for days that are entirely outside the range,
+		// ListDirectories already validated the name so the error is ignored:
+		day, _ := time.ParseInLocation(dateLayout, dir, p.timeZone())
+		if day.After(to) || !day.AddDate(0, 0, 1).After(from) {
+			continue
+		}
+		files, err := p.ListPayments(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range files {
+			ts, err := p.fileTimestamp(dir, name)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			if ts.Before(from) || ts.After(to) {
+				continue
+			}
+			paths = append(paths, dir+"/"+name)
+		}
+	}
+	return paths, nil
+}
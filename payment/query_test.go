@@ -0,0 +1,73 @@
+package payment
+
+import (
+	"testing"
+)
+
+// TestQueryRange covers QueryRange functionality across multiple directories and files
+func TestQueryRange(t *testing.T) {
+	paymentsService, store := serviceWithMemoryStore()
+	store.AddFile("20220717", "090000.payments", []byte(`date,time,sequence,amount,comment
+20220717,090000,212,600,payment3
+20220717,090000,211,500,payment2`))
+	store.AddFile("20220717", "063000.payments", []byte(`date,time,sequence,amount,comment
+20220717,063000,111,1000,payment1`))
+	store.AddFile("20220718", "010101.payments", []byte(`date,time,sequence,amount,comment
+20220718,010101,300,1500,payment4`))
+	// Invalid names are skipped:
+	store.AddFile("20220718", "invalid.payments", []byte(testRawCSV))
+	store.AddPartition("xyz")
+
+	testCases := []struct {
+		from, to  string
+		sequences []int
+	}{
+		{"20220717000000", "20220718235959", []int{111, 211, 212, 300}},
+		{"20220717063000", "20220717090000", []int{111, 211, 212}},
+		{"20220717063001", "20220718010100", []int{211, 212}},
+		{"20220718010101", "20220718010101", []int{300}},
+		{"20220719000000", "20220720000000", []int{}},
+	}
+	for _, tc := range testCases {
+		from, err := ParseTimestamp(tc.from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		to, err := ParseTimestamp(tc.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payments, err := paymentsService.QueryRange(from, to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(payments) != len(tc.sequences) {
+			t.Fatalf("invalid payments length for %s-%s, got %d, expected %d", tc.from, tc.to, len(payments), len(tc.sequences))
+		}
+		for i, p := range payments {
+			if p.Sequence != tc.sequences[i] {
+				t.Fatalf("invalid sequence at position %d, got %d, expected %d", i, p.Sequence, tc.sequences[i])
+			}
+		}
+	}
+
+	t.Run("reversed range", func(t *testing.T) {
+		from, _ := ParseTimestamp("20220718000000")
+		to, _ := ParseTimestamp("20220717000000")
+		if _, err := paymentsService.QueryRange(from, to); err == nil {
+			t.Fatal("should error")
+		}
+	})
+}
+
+// TestParseTimestamp covers ParseTimestamp using sample inputs
+func TestParseTimestamp(t *testing.T) {
+	for _, s := range []string{"", "20220717", "2022071706300", "20221317063000", "xyz"} {
+		if _, err := ParseTimestamp(s); err == nil {
+			t.Fatalf("should error with timestamp '%s'", s)
+		}
+	}
+	if _, err := ParseTimestamp("20220717063000"); err != nil {
+		t.Fatal(err)
+	}
+}
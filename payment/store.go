@@ -0,0 +1,320 @@
+package payment
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store abstracts the storage backend used by PaymentsService.
+// Payments are organized in partitions (the YYYYMMDD directories) that contain
+// payments files (HHMMSS.payments); a Store doesn't validate any of these names,
+// that's handled by PaymentsService.
+type Store interface {
+	// ListPartitions lists all partition names available in the store:
+	ListPartitions() ([]string, error)
+	// ListFiles lists all file names inside a given partition:
+	ListFiles(partition string) ([]string, error)
+	// Open opens a file for reading, the caller takes care of closing it:
+	Open(partition, name string) (io.ReadCloser, error)
+	// Stat returns file information for a given file:
+	Stat(partition, name string) (fs.FileInfo, error)
+}
+
+// WritableStore is a Store that also accepts new files
+type WritableStore interface {
+	Store
+	// WriteFile stores a file atomically, creating its partition if needed.
+	// Readers should either see the previous contents or the new ones, never a partial file.
+	WriteFile(partition, name string, data []byte) error
+}
+
+// FSStore is a Store backed by a directory in the local filesystem.
+// All paths are confined to BaseDir: names that resolve outside of it, either lexically or through symlinks,
+// fail with ErrOutsideBaseDir.
+type FSStore struct {
+	BaseDir string
+	// realBaseDir is BaseDir with all symlinks resolved:
+	realBaseDir string
+}
+
+// NewFSStore initializes a FSStore with a given base directory:
+func NewFSStore(baseDir string) (*FSStore, error) {
+	// Ensure it's possible to read the base directory:
+	if _, err := os.ReadDir(baseDir); err != nil {
+		return nil, err
+	}
+	realBaseDir, err := filepath.EvalSymlinks(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	return &FSStore{BaseDir: baseDir, realBaseDir: realBaseDir}, nil
+}
+
+// ListPartitions lists all entries of the base directory:
+func (s *FSStore) ListPartitions() ([]string, error) {
+	return s.readDir(s.BaseDir)
+}
+
+// ListFiles lists all entries of a given partition directory:
+func (s *FSStore) ListFiles(partition string) ([]string, error) {
+	path, err := s.resolve(partition)
+	if err != nil {
+		return nil, err
+	}
+	return s.readDir(path)
+}
+
+// Open opens a payments file from the filesystem:
+func (s *FSStore) Open(partition, name string) (io.ReadCloser, error) {
+	path, err := s.resolve(partition, name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// Stat returns the filesystem information of a payments file:
+func (s *FSStore) Stat(partition, name string) (fs.FileInfo, error) {
+	path, err := s.resolve(partition, name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(path)
+}
+
+// WriteFile writes a payments file to a temporary file in the same directory
+// and renames it once it's complete, so readers never see partial files:
+func (s *FSStore) WriteFile(partition, name string, data []byte) error {
+	if _, err := s.join(partition, name); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(s.BaseDir, partition), 0755); err != nil {
+		return err
+	}
+	// The partition might be a symlink, only the file itself may not exist yet:
+	dirPath, err := s.resolve(partition)
+	if err != nil {
+		return err
+	}
+	// Temporary files are prefixed with a dot, so they never pass file name validation:
+	tempFile, err := ioutil.TempFile(dirPath, "."+name+".tmp-")
+	if err != nil {
+		return err
+	}
+	tempPath := tempFile.Name()
+	// Cleanup the temporary file unless it was renamed:
+	renamed := false
+	defer func() {
+		if !renamed {
+			os.Remove(tempPath)
+		}
+	}()
+	if _, err := tempFile.Write(data); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err := tempFile.Sync(); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tempPath, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tempPath, filepath.Join(dirPath, name)); err != nil {
+		return err
+	}
+	renamed = true
+	return nil
+}
+
+// join is a helper that joins path elements to BaseDir, failing if the result is lexically outside of it
+func (s *FSStore) join(elem ...string) (string, error) {
+	path := filepath.Join(append([]string{s.BaseDir}, elem...)...)
+	rel, err := filepath.Rel(s.BaseDir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", &fs.PathError{Op: "resolve", Path: filepath.Join(elem...), Err: ErrOutsideBaseDir}
+	}
+	return path, nil
+}
+
+// resolve is a helper that joins path elements to BaseDir and resolves all symlinks,
+// failing if the resolved path is outside of the (also resolved) base directory.
+func (s *FSStore) resolve(elem ...string) (string, error) {
+	path, err := s.join(elem...)
+	if err != nil {
+		return "", err
+	}
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	realBaseDir := s.realBaseDir
+	if realBaseDir == "" {
+		if realBaseDir, err = filepath.EvalSymlinks(s.BaseDir); err != nil {
+			return "", err
+		}
+	}
+	rel, err := filepath.Rel(realBaseDir, realPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", &fs.PathError{Op: "resolve", Path: filepath.Join(elem...), Err: ErrOutsideBaseDir}
+	}
+	return realPath, nil
+}
+
+// readDir is a helper that returns the entry names of a given directory:
+func (s *FSStore) readDir(path string) ([]string, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names, nil
+}
+
+// MemoryStore is a Store that keeps all partitions and files in memory,
+// it's mostly useful for tests and short lived services.
+type MemoryStore struct {
+	mu         sync.RWMutex
+	partitions map[string]map[string]*memoryFile
+}
+
+// memoryFile holds the contents of a single MemoryStore file
+type memoryFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemoryStore initializes an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{partitions: make(map[string]map[string]*memoryFile)}
+}
+
+// AddPartition creates an empty partition, it's a no-op if the partition already exists:
+func (s *MemoryStore) AddPartition(partition string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addPartition(partition)
+}
+
+// AddFile stores a file with the given contents, creating its partition if needed:
+func (s *MemoryStore) AddFile(partition, name string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	files := s.addPartition(partition)
+	files[name] = &memoryFile{data: append([]byte(nil), data...), modTime: time.Now()}
+}
+
+// SetModTime changes the modification time of a file, like os.Chtimes:
+func (s *MemoryStore) SetModTime(partition, name string, modTime time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.partitions[partition][name]
+	if !ok {
+		return &fs.PathError{Op: "chtimes", Path: filepath.Join(partition, name), Err: fs.ErrNotExist}
+	}
+	// Files are replaced instead of modified, Stat reads them without holding the lock:
+	s.partitions[partition][name] = &memoryFile{data: f.data, modTime: modTime}
+	return nil
+}
+
+// WriteFile stores a file, replacing any previous contents:
+func (s *MemoryStore) WriteFile(partition, name string, data []byte) error {
+	s.AddFile(partition, name, data)
+	return nil
+}
+
+// addPartition is a helper that returns the files of a partition, creating it if needed.
+// The caller must hold the write lock.
+func (s *MemoryStore) addPartition(partition string) map[string]*memoryFile {
+	files, ok := s.partitions[partition]
+	if !ok {
+		files = make(map[string]*memoryFile)
+		s.partitions[partition] = files
+	}
+	return files
+}
+
+// ListPartitions lists all partitions in lexical order:
+func (s *MemoryStore) ListPartitions() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.partitions))
+	for name := range s.partitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ListFiles lists all files of a partition in lexical order:
+func (s *MemoryStore) ListFiles(partition string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	files, ok := s.partitions[partition]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: partition, Err: fs.ErrNotExist}
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Open returns a reader for the contents of a file:
+func (s *MemoryStore) Open(partition, name string) (io.ReadCloser, error) {
+	f, err := s.file("open", partition, name)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(f.data)), nil
+}
+
+// Stat returns file information for a file:
+func (s *MemoryStore) Stat(partition, name string) (fs.FileInfo, error) {
+	f, err := s.file("stat", partition, name)
+	if err != nil {
+		return nil, err
+	}
+	return &memoryFileInfo{name: name, size: int64(len(f.data)), modTime: f.modTime}, nil
+}
+
+// file is a helper that looks up a file and returns a fs.PathError when it doesn't exist:
+func (s *MemoryStore) file(op, partition, name string) (*memoryFile, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	f, ok := s.partitions[partition][name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: filepath.Join(partition, name), Err: fs.ErrNotExist}
+	}
+	return f, nil
+}
+
+// memoryFileInfo implements fs.FileInfo for MemoryStore files
+type memoryFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (fi *memoryFileInfo) Name() string       { return fi.name }
+func (fi *memoryFileInfo) Size() int64        { return fi.size }
+func (fi *memoryFileInfo) Mode() fs.FileMode  { return 0444 }
+func (fi *memoryFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *memoryFileInfo) IsDir() bool        { return false }
+func (fi *memoryFileInfo) Sys() interface{}   { return nil }
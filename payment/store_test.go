@@ -0,0 +1,184 @@
+package payment
+
+import (
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testStores is a helper that returns every Store implementation populated with the same data:
+// a "20220717" partition containing a single file with testRawCSV
+func testStores(t *testing.T) map[string]Store {
+	memoryStore := NewMemoryStore()
+	memoryStore.AddFile("20220717", "090000.payments", []byte(testRawCSV))
+
+	baseDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(baseDir, "20220717"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	err := ioutil.WriteFile(filepath.Join(baseDir, "20220717", "090000.payments"), []byte(testRawCSV), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsStore, err := NewFSStore(baseDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]Store{"memory": memoryStore, "fs": fsStore}
+}
+
+// TestStores covers the behavior shared by all Store implementations
+func TestStores(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			partitions, err := store.ListPartitions()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(partitions) != 1 || partitions[0] != "20220717" {
+				t.Fatalf("unexpected partitions %v", partitions)
+			}
+			files, err := store.ListFiles("20220717")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != 1 || files[0] != "090000.payments" {
+				t.Fatalf("unexpected files %v", files)
+			}
+			fi, err := store.Stat("20220717", "090000.payments")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Size() != int64(len(testRawCSV)) {
+				t.Fatalf("invalid size, got %d, expected %d", fi.Size(), len(testRawCSV))
+			}
+			f, err := store.Open("20220717", "090000.payments")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			data, err := ioutil.ReadAll(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Compare(string(data), testRawCSV) != 0 {
+				t.Fatal("file contents don't match")
+			}
+			// Missing partitions and files should return errors matching fs.ErrNotExist:
+			if _, err := store.ListFiles("20220718"); !errors.Is(err, fs.ErrNotExist) {
+				t.Fatalf("expected fs.ErrNotExist, got %v", err)
+			}
+			if _, err := store.Open("20220717", "100000.payments"); !errors.Is(err, fs.ErrNotExist) {
+				t.Fatalf("expected fs.ErrNotExist, got %v", err)
+			}
+			if _, err := store.Stat("20220717", "100000.payments"); !errors.Is(err, fs.ErrNotExist) {
+				t.Fatalf("expected fs.ErrNotExist, got %v", err)
+			}
+		})
+	}
+}
+
+// TestFSStoreWriteFile covers FSStore atomic writes
+func TestFSStoreWriteFile(t *testing.T) {
+	baseDir := t.TempDir()
+	store, err := NewFSStore(baseDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := store.WriteFile("20220717", "090000.payments", []byte(testRawCSV)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Only the final file should be left in the partition directory:
+	files, err := store.ListFiles("20220717")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0] != "090000.payments" {
+		t.Fatalf("unexpected files %v", files)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(baseDir, "20220717", "090000.payments"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Compare(string(data), testRawCSV) != 0 {
+		t.Fatal("file contents don't match")
+	}
+}
+
+// TestFSStoreConfinement covers lexical and symlink escapes from the base directory
+func TestFSStoreConfinement(t *testing.T) {
+	rootDir := t.TempDir()
+	baseDir := filepath.Join(rootDir, "data")
+	outsideDir := filepath.Join(rootDir, "outside")
+	for _, dir := range []string{filepath.Join(baseDir, "20220717"), filepath.Join(outsideDir, "20220718")} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, path := range []string{
+		filepath.Join(baseDir, "20220717", "090000.payments"),
+		filepath.Join(outsideDir, "20220718", "090000.payments"),
+		filepath.Join(outsideDir, "secret.payments"),
+	} {
+		if err := ioutil.WriteFile(path, []byte(testRawCSV), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A partition that points outside of the base directory:
+	if err := os.Symlink(filepath.Join(outsideDir, "20220718"), filepath.Join(baseDir, "20220718")); err != nil {
+		t.Fatal(err)
+	}
+	// A file that points outside of the base directory:
+	if err := os.Symlink(filepath.Join(outsideDir, "secret.payments"), filepath.Join(baseDir, "20220717", "100000.payments")); err != nil {
+		t.Fatal(err)
+	}
+	// A file that points to another file inside the base directory:
+	if err := os.Symlink(filepath.Join(baseDir, "20220717", "090000.payments"), filepath.Join(baseDir, "20220717", "110000.payments")); err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewFSStore(baseDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	escapes := []struct {
+		partition, name string
+	}{
+		{"..", "secret.payments"},
+		{"../outside", "secret.payments"},
+		{"20220717", "../../outside/secret.payments"},
+		{"20220718", "090000.payments"},
+		{"20220717", "100000.payments"},
+	}
+	for _, escape := range escapes {
+		if _, err := store.Open(escape.partition, escape.name); !errors.Is(err, ErrOutsideBaseDir) {
+			t.Fatalf("expected ErrOutsideBaseDir opening '%s/%s', got %v", escape.partition, escape.name, err)
+		}
+		if _, err := store.Stat(escape.partition, escape.name); !errors.Is(err, ErrOutsideBaseDir) {
+			t.Fatalf("expected ErrOutsideBaseDir with stat '%s/%s', got %v", escape.partition, escape.name, err)
+		}
+	}
+	for _, partition := range []string{"..", "../outside/20220718", "20220718"} {
+		if _, err := store.ListFiles(partition); !errors.Is(err, ErrOutsideBaseDir) {
+			t.Fatalf("expected ErrOutsideBaseDir listing '%s', got %v", partition, err)
+		}
+	}
+	if err := store.WriteFile("20220718", "120000.payments", []byte(testRawCSV)); !errors.Is(err, ErrOutsideBaseDir) {
+		t.Fatalf("expected ErrOutsideBaseDir writing through a symlink, got %v", err)
+	}
+	if err := store.WriteFile("..", "120000.payments", []byte(testRawCSV)); !errors.Is(err, ErrOutsideBaseDir) {
+		t.Fatalf("expected ErrOutsideBaseDir writing outside, got %v", err)
+	}
+	// Symlinks that stay inside the base directory are allowed:
+	f, err := store.Open("20220717", "110000.payments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+}
@@ -0,0 +1,133 @@
+package payment
+
+import (
+	"log"
+)
+
+const (
+	// maxStreamedRejected caps the rows listed in the *ParseError of streamed files in strict mode,
+	// so files full of invalid rows don't have to be kept in memory:
+	maxStreamedRejected = 1000
+)
+
+// StreamPayments calls fn for every payment in a given file, in file order.
+// Rows that couldn't be parsed are logged, see StreamPaymentsReport.
+func (p *PaymentsService) StreamPayments(path string, fn func(Payment) error) error {
+	return p.StreamPaymentsReport(path, fn, func(rowError RowError) error {
+		log.Printf("%s: %s\n", path, rowError.Error())
+		return nil
+	})
+}
+
+// StreamPaymentsReport calls onPayment for every payment in a given file and onRejected for every row that couldn't be parsed.
+// Files are read row by row so memory usage doesn't depend on the file size. Files that are already in the cache are
+// read from it, but streamed files aren't added to the cache.
+// Errors returned by the callbacks stop the iteration and are returned as they are, which is useful for cancellation.
+// In strict mode the file is read twice: it's checked first and a *ParseError listing the first invalid rows is returned
+// before calling onPayment, so callers can still report invalid files as a whole. Files that change in between can still
+// fail after onPayment was called.
+// Rows accepted with warnings, see LocationPolicy, are logged.
+func (p *PaymentsService) StreamPaymentsReport(path string, onPayment func(Payment) error, onRejected func(RowError) error) error {
+	dir, name, err := p.splitPath(path)
+	if err != nil {
+		return err
+	}
+	if result, ok := p.cachedPayments(dir, name); ok {
+		return p.replayPayments(path, result, onPayment, onRejected)
+	}
+	onWarning := func(rowError RowError) error {
+		log.Printf("%s: %s\n", path, rowError.Error())
+		return nil
+	}
+	if p.ParseMode == ParseStrict {
+		// The check is the parse reported to the observer, the second read isn't:
+		if err := p.validateFile(dir, name); err != nil {
+			return err
+		}
+	}
+	f, err := p.Store.Open(dir, name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if p.ParseMode != ParseStrict {
+		return p.scanPayments(f, newFileLocation(dir, name), onPayment, onRejected, onWarning)
+	}
+	// Rows can only be rejected here if the file changed after the check:
+	var rejected []RowError
+	err = p.scanRows(f, newFileLocation(dir, name), func(payment Payment) error {
+		if len(rejected) > 0 {
+			return nil
+		}
+		return onPayment(payment)
+	}, func(rowError RowError) error {
+		if len(rejected) < maxStreamedRejected {
+			rejected = append(rejected, rowError)
+		}
+		return nil
+	}, onWarning)
+	if err == nil && len(rejected) > 0 {
+		err = &ParseError{Rows: rejected}
+	}
+	return err
+}
+
+// validateFile is a helper that reads a whole file and returns its first invalid rows as a *ParseError.
+// Warnings are ignored, they're logged when the file is read again.
+func (p *PaymentsService) validateFile(dir, name string) error {
+	f, err := p.Store.Open(dir, name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var rejected []RowError
+	err = p.scanPayments(f, newFileLocation(dir, name), func(Payment) error {
+		return nil
+	}, func(rowError RowError) error {
+		if len(rejected) < maxStreamedRejected {
+			rejected = append(rejected, rowError)
+		}
+		return nil
+	}, func(RowError) error {
+		return nil
+	})
+	if err == nil && len(rejected) > 0 {
+		err = &ParseError{Rows: rejected}
+	}
+	return err
+}
+
+// cachedPayments is a helper that returns the cached result of a file, if any
+func (p *PaymentsService) cachedPayments(dir, name string) (*ParseResult, bool) {
+	if p.Cache == nil {
+		return nil, false
+	}
+	fi, err := p.Store.Stat(dir, name)
+	if err != nil {
+		// Let the caller report the error when opening the file:
+		return nil, false
+	}
+	return p.Cache.Get(dir+"/"+name, fi.ModTime(), fi.Size())
+}
+
+// replayPayments is a helper that calls the callbacks of StreamPaymentsReport for a parsed file,
+// invalid files are reported before calling onPayment in strict mode
+func (p *PaymentsService) replayPayments(path string, result *ParseResult, onPayment func(Payment) error, onRejected func(RowError) error) error {
+	if p.ParseMode == ParseStrict && len(result.Rejected) > 0 {
+		return &ParseError{Rows: result.Rejected}
+	}
+	for _, rowError := range result.Rejected {
+		if err := onRejected(rowError); err != nil {
+			return err
+		}
+	}
+	for _, rowError := range result.Warnings {
+		log.Printf("%s: %s\n", path, rowError.Error())
+	}
+	for _, payment := range result.Payments {
+		if err := onPayment(payment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+package payment
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"testing"
+)
+
+// testLargeCSV is a helper that generates a payments file with a given number of rows,
+// every tenth row has an invalid amount
+func testLargeCSV(rows int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("date,time,sequence,amount,comment\n")
+	for i := 0; i < rows; i++ {
+		amount := "10.25"
+		if i%10 == 9 {
+			amount = "abc"
+		}
+		fmt.Fprintf(&buf, "20220717,090000,%d,%s,payment%d\n", i, amount, i)
+	}
+	return buf.Bytes()
+}
+
+// TestStreamPayments covers StreamPayments and StreamPaymentsReport functionality
+func TestStreamPayments(t *testing.T) {
+	paymentsService, store := serviceWithMemoryStore()
+	store.AddFile("20220717", "090000.payments", testLargeCSV(10000))
+
+	t.Run("all rows", func(t *testing.T) {
+		var payments, rejected int
+		err := paymentsService.StreamPaymentsReport("20220717/090000.payments", func(payment Payment) error {
+			if payment.Sequence%10 == 9 {
+				t.Fatalf("invalid row %d wasn't rejected", payment.Sequence)
+			}
+			payments++
+			return nil
+		}, func(rowError RowError) error {
+			rejected++
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if payments != 9000 {
+			t.Fatalf("invalid payments count, got %d, expected %d", payments, 9000)
+		}
+		if rejected != 1000 {
+			t.Fatalf("invalid rejected count, got %d, expected %d", rejected, 1000)
+		}
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		errStop := errors.New("stop")
+		var payments int
+		err := paymentsService.StreamPayments("20220717/090000.payments", func(payment Payment) error {
+			payments++
+			if payments == 5 {
+				return errStop
+			}
+			return nil
+		})
+		if err != errStop {
+			t.Fatalf("invalid error, got %v, expected %v", err, errStop)
+		}
+		if payments != 5 {
+			t.Fatalf("invalid payments count, got %d, expected %d", payments, 5)
+		}
+	})
+
+	t.Run("strict mode", func(t *testing.T) {
+		strictService := NewWithStore(store)
+		strictService.ParseMode = ParseStrict
+		// Invalid files are reported before streaming any payment, the first invalid row is line 11:
+		var payments int
+		err := strictService.StreamPayments("20220717/090000.payments", func(payment Payment) error {
+			payments++
+			return nil
+		})
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Fatalf("expected *ParseError, got %v", err)
+		}
+		if payments != 0 {
+			t.Fatalf("invalid payments count, got %d, expected %d", payments, 0)
+		}
+		if len(parseErr.Rows) != 1000 || parseErr.Rows[0].Line != 11 {
+			t.Fatalf("unexpected rejected rows, got %d, first line %d", len(parseErr.Rows), parseErr.Rows[0].Line)
+		}
+	})
+
+	t.Run("cache", func(t *testing.T) {
+		cachedService := NewWithStore(store)
+		cachedService.Cache = NewCache(1 << 30)
+		// Streamed files aren't added to the cache:
+		for i := 0; i < 2; i++ {
+			if err := cachedService.StreamPayments("20220717/090000.payments", func(Payment) error { return nil }); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if stats := cachedService.Cache.Stats(); stats.Hits != 0 || stats.Entries != 0 {
+			t.Fatalf("file shouldn't be cached, got %+v", stats)
+		}
+		// But files cached by GetPayments are streamed from the cache:
+		if _, err := cachedService.GetPayments("20220717/090000.payments"); err != nil {
+			t.Fatal(err)
+		}
+		var payments int
+		if err := cachedService.StreamPayments("20220717/090000.payments", func(Payment) error {
+			payments++
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if stats := cachedService.Cache.Stats(); stats.Hits != 1 {
+			t.Fatalf("invalid cache hits, got %d, expected %d", stats.Hits, 1)
+		}
+		if payments != 9000 {
+			t.Fatalf("invalid payments count, got %d, expected %d", payments, 9000)
+		}
+	})
+
+	t.Run("invalid path", func(t *testing.T) {
+		err := paymentsService.StreamPayments("../090000.payments", func(Payment) error { return nil })
+		if !errors.Is(err, ErrInvalidName) {
+			t.Fatalf("expected ErrInvalidName, got %v", err)
+		}
+	})
+}
+
+// rowGenerator is a helper that generates the rows of a payments file while it's read,
+// so the file itself doesn't take any memory. The row with index invalid has an invalid amount,
+// sample is called every sampleEvery rows when set.
+type rowGenerator struct {
+	rows, next, invalid int
+	sample              func()
+	buf                 bytes.Buffer
+}
+
+// sampleEvery is the number of generated rows between calls to rowGenerator.sample
+const sampleEvery = 50000
+
+func newRowGenerator(rows, invalid int, sample func()) *rowGenerator {
+	g := &rowGenerator{rows: rows, invalid: invalid, sample: sample}
+	g.buf.WriteString("date,time,sequence,amount,comment\n")
+	return g
+}
+
+func (g *rowGenerator) Read(p []byte) (int, error) {
+	for g.buf.Len() < len(p) && g.next < g.rows {
+		amount := "10.25"
+		if g.next == g.invalid {
+			amount = "abc"
+		}
+		fmt.Fprintf(&g.buf, "20220717,090000,%d,%s,payment%d\n", g.next, amount, g.next)
+		g.next++
+		if g.sample != nil && g.next%sampleEvery == 0 {
+			g.sample()
+		}
+	}
+	if g.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return g.buf.Read(p)
+}
+
+func (g *rowGenerator) Close() error { return nil }
+
+// generatedStore is a MemoryStore that serves generated files
+type generatedStore struct {
+	*MemoryStore
+	rows, invalid int
+	sample        func()
+}
+
+func (s *generatedStore) Open(partition, name string) (io.ReadCloser, error) {
+	if _, err := s.MemoryStore.Open(partition, name); err != nil {
+		return nil, err
+	}
+	return newRowGenerator(s.rows, s.invalid, s.sample), nil
+}
+
+// TestStreamPaymentsMemory checks that streaming doesn't keep the file in memory in both parse modes,
+// including the check of strict mode
+func TestStreamPaymentsMemory(t *testing.T) {
+	const rows = 500000
+	// The generated file is about 25MB, and its payments take several times that once parsed:
+	const maxHeapGrowth = 8 << 20
+	cases := []struct {
+		name     string
+		mode     ParseMode
+		invalid  int
+		payments int
+	}{
+		{"lenient", ParseLenient, rows - 1, rows - 1},
+		{"strict", ParseStrict, -1, rows},
+		{"strict with an invalid row", ParseStrict, rows - 1, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			heapAlloc := func() uint64 {
+				runtime.GC()
+				var m runtime.MemStats
+				runtime.ReadMemStats(&m)
+				return m.HeapAlloc
+			}
+			var peak uint64
+			store := &generatedStore{MemoryStore: NewMemoryStore(), rows: rows, invalid: c.invalid, sample: func() {
+				if alloc := heapAlloc(); alloc > peak {
+					peak = alloc
+				}
+			}}
+			store.AddFile("20220717", "090000.payments", nil)
+			paymentsService := NewWithStore(store)
+			paymentsService.ParseMode = c.mode
+			paymentsService.Cache = NewCache(1 << 30)
+
+			base := heapAlloc()
+			var payments int
+			err := paymentsService.StreamPaymentsReport("20220717/090000.payments", func(payment Payment) error {
+				payments++
+				return nil
+			}, func(RowError) error { return nil })
+			var parseErr *ParseError
+			if c.mode == ParseStrict && c.invalid >= 0 {
+				if !errors.As(err, &parseErr) {
+					t.Fatalf("expected *ParseError, got %v", err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if payments != c.payments {
+				t.Fatalf("invalid payments count, got %d, expected %d", payments, c.payments)
+			}
+			if peak > base && peak-base > maxHeapGrowth {
+				t.Fatalf("heap grew by %d bytes while streaming, expected at most %d", peak-base, maxHeapGrowth)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+)
+
+const (
+	// hourLayout identifies hourly buckets, it's a prefix of timestampLayout:
+	hourLayout = "2006010215"
+)
+
+// errSumOverflow is returned when the total of a currency doesn't fit in an int64 of minor units
+var errSumOverflow = errors.New("amount sum out of range")
+
+// Summary holds the aggregates of a set of payments.
+// Amounts in different currencies are never added together, so amount statistics are reported per currency.
+type Summary struct {
+	Count int `json:"count"`
+	// Rejected counts the rows that couldn't be parsed:
+	Rejected int `json:"rejected"`
+	// FirstSequence and LastSequence belong to the earliest and latest payments by AsOf, they're nil without payments:
+	FirstSequence *int `json:"firstSequence"`
+	LastSequence  *int `json:"lastSequence"`
+	// Currencies maps currency codes to their amount statistics:
+	Currencies map[string]AmountStats `json:"currencies"`
+	// Hours contains a bucket for every hour with payments, in chronological order:
+	Hours []HourBucket `json:"hours"`
+}
+
+// AmountStats holds the statistics of the amounts in a single currency
+type AmountStats struct {
+	Count int   `json:"count"`
+	Sum   Money `json:"sum"`
+	Min   Money `json:"min"`
+	Max   Money `json:"max"`
+	// Mean and Median are rounded half away from zero to the minor unit:
+	Mean   Money `json:"mean"`
+	Median Money `json:"median"`
+}
+
+// HourBucket aggregates the payments of a single hour
+type HourBucket struct {
+	// Hour is the start of the bucket in the time zone of the data, like 2022071709:
+	Hour  string `json:"hour"`
+	Count int    `json:"count"`
+	// Sums maps currency codes to the total amount of the hour:
+	Sums map[string]Money `json:"sums"`
+}
+
+// Aggregator computes a Summary incrementally, payments are added one at a time.
+// Only amounts are kept in memory, which is needed for the median.
+type Aggregator struct {
+	summary Summary
+	first   *Payment
+	last    *Payment
+	amounts map[string][]int64
+	sums    map[string]int64
+	hours   map[string]*HourBucket
+	err     error
+}
+
+// NewAggregator initializes an empty Aggregator
+func NewAggregator() *Aggregator {
+	return &Aggregator{
+		amounts: make(map[string][]int64),
+		sums:    make(map[string]int64),
+		hours:   make(map[string]*HourBucket),
+	}
+}
+
+// Add includes a payment in the summary
+func (a *Aggregator) Add(p Payment) {
+	a.summary.Count++
+	// Ties keep the first payment seen as first and the last one seen as last:
+	if a.first == nil || p.AsOf.Before(a.first.AsOf) {
+		first := p
+		a.first = &first
+	}
+	if a.last == nil || !p.AsOf.Before(a.last.AsOf) {
+		last := p
+		a.last = &last
+	}
+	currency := p.Amount.Currency
+	a.amounts[currency] = append(a.amounts[currency], p.Amount.Minor)
+	sum, ok := addMinor(a.sums[currency], p.Amount.Minor)
+	if !ok && a.err == nil {
+		a.err = fmt.Errorf("%s: %w", currency, errSumOverflow)
+	}
+	a.sums[currency] = sum
+
+	hour := p.AsOf.Format(hourLayout)
+	bucket, ok := a.hours[hour]
+	if !ok {
+		bucket = &HourBucket{Hour: hour, Sums: make(map[string]Money)}
+		a.hours[hour] = bucket
+	}
+	bucket.Count++
+	hourSum, ok := addMinor(bucket.Sums[currency].Minor, p.Amount.Minor)
+	if !ok && a.err == nil {
+		a.err = fmt.Errorf("%s: %w", currency, errSumOverflow)
+	}
+	bucket.Sums[currency] = Money{Minor: hourSum, Currency: currency}
+}
+
+// AddRejected counts rows that couldn't be parsed
+func (a *Aggregator) AddRejected(rowError RowError) {
+	a.summary.Rejected++
+}
+
+// Summary returns the aggregates of all payments added so far,
+// an error is returned if the total of a currency overflows.
+func (a *Aggregator) Summary() (*Summary, error) {
+	if a.err != nil {
+		return nil, a.err
+	}
+	summary := a.summary
+	if a.first != nil {
+		firstSequence, lastSequence := a.first.Sequence, a.last.Sequence
+		summary.FirstSequence, summary.LastSequence = &firstSequence, &lastSequence
+	}
+	summary.Currencies = make(map[string]AmountStats, len(a.amounts))
+	for currency, amounts := range a.amounts {
+		sorted := append([]int64(nil), amounts...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		money := func(minor int64) Money {
+			return Money{Minor: minor, Currency: currency}
+		}
+		sum := a.sums[currency]
+		n := len(sorted)
+		median := sorted[n/2]
+		if n%2 == 0 {
+			median = midpoint(sorted[n/2-1], sorted[n/2])
+		}
+		summary.Currencies[currency] = AmountStats{
+			Count:  n,
+			Sum:    money(sum),
+			Min:    money(sorted[0]),
+			Max:    money(sorted[n-1]),
+			Mean:   money(divRound(sum, int64(n))),
+			Median: money(median),
+		}
+	}
+	summary.Hours = make([]HourBucket, 0, len(a.hours))
+	for _, bucket := range a.hours {
+		summary.Hours = append(summary.Hours, *bucket)
+	}
+	sort.Slice(summary.Hours, func(i, j int) bool {
+		return summary.Hours[i].Hour < summary.Hours[j].Hour
+	})
+	return &summary, nil
+}
+
+// addMinor is a helper that adds two amounts, ok is false when the result overflows
+func addMinor(a, b int64) (int64, bool) {
+	sum := a + b
+	if (b > 0 && sum < a) || (b < 0 && sum > a) {
+		return sum, false
+	}
+	return sum, true
+}
+
+// divRound is a helper that divides rounding half away from zero, d must be positive
+func divRound(n, d int64) int64 {
+	q, r := n/d, n%d
+	if r < 0 {
+		r = -r
+	}
+	if r*2 >= d {
+		if n < 0 {
+			return q - 1
+		}
+		return q + 1
+	}
+	return q
+}
+
+// midpoint is a helper that returns the average of two amounts rounded half away from zero,
+// big.Int is used so the sum can't overflow
+func midpoint(a, b int64) int64 {
+	sum := new(big.Int).Add(big.NewInt(a), big.NewInt(b))
+	half, remainder := new(big.Int).QuoRem(sum, big.NewInt(2), new(big.Int))
+	// QuoRem truncates towards zero, the remainder has the sign of the sum:
+	return half.Int64() + remainder.Int64()
+}
+
+// SummarizeFile aggregates the payments of a single file, which is read row by row
+func (p *PaymentsService) SummarizeFile(path string) (*Summary, error) {
+	aggregator := NewAggregator()
+	if err := p.summarizeFile(aggregator, path); err != nil {
+		return nil, err
+	}
+	return aggregator.Summary()
+}
+
+// SummarizeDirectory aggregates the payments of all files in a date directory
+func (p *PaymentsService) SummarizeDirectory(dir string) (*Summary, error) {
+	files, err := p.ListPayments(dir)
+	if err != nil {
+		return nil, err
+	}
+	aggregator := NewAggregator()
+	for _, name := range files {
+		if err := p.summarizeFile(aggregator, dir+"/"+name); err != nil {
+			return nil, err
+		}
+	}
+	return aggregator.Summary()
+}
+
+// SummarizeRange aggregates the payments of all files within the [from, to] interval, see QueryRange
+func (p *PaymentsService) SummarizeRange(from, to time.Time) (*Summary, error) {
+	paths, err := p.filesInRange(from, to)
+	if err != nil {
+		return nil, err
+	}
+	aggregator := NewAggregator()
+	for _, path := range paths {
+		if err := p.summarizeFile(aggregator, path); err != nil {
+			return nil, err
+		}
+	}
+	return aggregator.Summary()
+}
+
+// summarizeFile is a helper that adds the payments and rejected rows of a file to an aggregator
+func (p *PaymentsService) summarizeFile(aggregator *Aggregator, path string) error {
+	return p.StreamPaymentsReport(path, func(payment Payment) error {
+		aggregator.Add(payment)
+		return nil
+	}, func(rowError RowError) error {
+		aggregator.AddRejected(rowError)
+		return nil
+	})
+}
@@ -0,0 +1,134 @@
+package payment
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+// TestAggregator covers the statistics computed by Aggregator
+func TestAggregator(t *testing.T) {
+	aggregator := NewAggregator()
+	for _, p := range []Payment{
+		{AsOf: testTimestamp("20220717100000"), Sequence: 3, Amount: Money{Minor: 300, Currency: "USD"}},
+		{AsOf: testTimestamp("20220717090000"), Sequence: 1, Amount: Money{Minor: 100, Currency: "USD"}},
+		{AsOf: testTimestamp("20220717093000"), Sequence: 2, Amount: Money{Minor: 1000, Currency: "JPY"}},
+		{AsOf: testTimestamp("20220717101500"), Sequence: 4, Amount: Money{Minor: 601, Currency: "USD"}},
+		{AsOf: testTimestamp("20220717101500"), Sequence: 5, Amount: Money{Minor: -50, Currency: "USD"}},
+	} {
+		aggregator.Add(p)
+	}
+	aggregator.AddRejected(RowError{Line: 7})
+	summary, err := aggregator.Summary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Count != 5 || summary.Rejected != 1 {
+		t.Fatalf("invalid counts, got %d and %d, expected 5 and 1", summary.Count, summary.Rejected)
+	}
+	if *summary.FirstSequence != 1 || *summary.LastSequence != 5 {
+		t.Fatalf("invalid first/last sequence, got %d and %d, expected 1 and 5", *summary.FirstSequence, *summary.LastSequence)
+	}
+	usd := summary.Currencies["USD"]
+	expected := AmountStats{
+		Count: 4,
+		Sum:   Money{Minor: 951, Currency: "USD"},
+		Min:   Money{Minor: -50, Currency: "USD"},
+		Max:   Money{Minor: 601, Currency: "USD"},
+		// 951/4 = 237.75:
+		Mean: Money{Minor: 238, Currency: "USD"},
+		// (100+300)/2:
+		Median: Money{Minor: 200, Currency: "USD"},
+	}
+	if usd != expected {
+		t.Fatalf("invalid USD stats, got %+v, expected %+v", usd, expected)
+	}
+	if jpy := summary.Currencies["JPY"]; jpy.Count != 1 || jpy.Median.Minor != 1000 || jpy.Mean.Minor != 1000 {
+		t.Fatalf("invalid JPY stats, got %+v", jpy)
+	}
+	if len(summary.Hours) != 2 {
+		t.Fatalf("invalid number of hour buckets, got %d, expected 2", len(summary.Hours))
+	}
+	if bucket := summary.Hours[0]; bucket.Hour != "2022071709" || bucket.Count != 2 || bucket.Sums["JPY"].Minor != 1000 {
+		t.Fatalf("invalid hour bucket, got %+v", bucket)
+	}
+	if bucket := summary.Hours[1]; bucket.Hour != "2022071710" || bucket.Count != 3 || bucket.Sums["USD"].Minor != 851 {
+		t.Fatalf("invalid hour bucket, got %+v", bucket)
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		summary, err := NewAggregator().Summary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if summary.Count != 0 || summary.FirstSequence != nil || len(summary.Currencies) != 0 {
+			t.Fatalf("invalid empty summary %+v", summary)
+		}
+	})
+
+	t.Run("overflow", func(t *testing.T) {
+		aggregator := NewAggregator()
+		aggregator.Add(Payment{AsOf: testTimestamp("20220717090000"), Amount: Money{Minor: math.MaxInt64, Currency: "USD"}})
+		aggregator.Add(Payment{AsOf: testTimestamp("20220717090000"), Amount: Money{Minor: 1, Currency: "USD"}})
+		if _, err := aggregator.Summary(); !errors.Is(err, errSumOverflow) {
+			t.Fatalf("expected overflow error, got %v", err)
+		}
+	})
+
+	t.Run("rounding", func(t *testing.T) {
+		cases := []struct{ a, b, midpoint int64 }{
+			{1, 2, 2},
+			{-1, -2, -2},
+			{-1, 2, 1},
+			{math.MaxInt64, math.MaxInt64 - 2, math.MaxInt64 - 1},
+		}
+		for _, c := range cases {
+			if got := midpoint(c.a, c.b); got != c.midpoint {
+				t.Fatalf("invalid midpoint of %d and %d, got %d, expected %d", c.a, c.b, got, c.midpoint)
+			}
+		}
+		if got := divRound(-7, 2); got != -4 {
+			t.Fatalf("invalid rounding, got %d, expected -4", got)
+		}
+	})
+}
+
+// TestSummarize covers the file, directory and range summaries of PaymentsService
+func TestSummarize(t *testing.T) {
+	paymentsService, store := serviceWithMemoryStore()
+	store.AddFile("20220717", "090000.payments", []byte(`date,time,sequence,amount
+20220717,090000,1,10
+20220717,090000,2,20
+20220717,090000,3,abc`))
+	store.AddFile("20220717", "100000.payments", []byte(`date,time,sequence,amount
+20220717,100000,4,30`))
+	store.AddFile("20220718", "090000.payments", []byte(`date,time,sequence,amount
+20220718,090000,5,40`))
+
+	summary, err := paymentsService.SummarizeFile("20220717/090000.payments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Count != 2 || summary.Rejected != 1 || summary.Currencies["USD"].Sum.Minor != 3000 {
+		t.Fatalf("invalid file summary %+v", summary)
+	}
+	summary, err = paymentsService.SummarizeDirectory("20220717")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Count != 3 || *summary.LastSequence != 4 || len(summary.Hours) != 2 {
+		t.Fatalf("invalid directory summary %+v", summary)
+	}
+	from, _ := ParseTimestamp("20220717093000")
+	to, _ := ParseTimestamp("20220718090000")
+	summary, err = paymentsService.SummarizeRange(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Count != 2 || *summary.FirstSequence != 4 || summary.Currencies["USD"].Median.Minor != 3500 {
+		t.Fatalf("invalid range summary %+v", summary)
+	}
+	if _, err := paymentsService.SummarizeDirectory("../etc"); !errors.Is(err, ErrInvalidName) {
+		t.Fatalf("expected ErrInvalidName, got %v", err)
+	}
+}
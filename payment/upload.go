@@ -0,0 +1,46 @@
+package payment
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// ErrReadOnlyStore is returned when trying to upload files to a Store that doesn't implement WritableStore
+var ErrReadOnlyStore = errors.New("store is read-only")
+
+// PutPayments validates and stores a payments file in a given directory.
+// The whole file is parsed before being stored, any invalid row rejects the file with a *ParseError.
+// created reports whether the file didn't exist before.
+func (p *PaymentsService) PutPayments(dir, name string, data []byte) (payments []Payment, created bool, err error) {
+	if err := p.validateDirName(dir); err != nil {
+		return nil, false, err
+	}
+	if err := p.validateFileName(name); err != nil {
+		return nil, false, err
+	}
+	store, ok := p.Store.(WritableStore)
+	if !ok {
+		return nil, false, ErrReadOnlyStore
+	}
+	// Uploads are always parsed in strict mode, regardless of ParseMode:
+	result, err := p.parsePaymentsAt(newFileLocation(dir, name), bytes.NewReader(data))
+	if err != nil {
+		return nil, false, err
+	}
+	if len(result.Rejected) > 0 {
+		return nil, false, &ParseError{Rows: result.Rejected}
+	}
+	_, err = store.Stat(dir, name)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		created = true
+	case err != nil:
+		return nil, false, fmt.Errorf("couldn't stat '%s/%s': %w", dir, name, err)
+	}
+	if err := store.WriteFile(dir, name, data); err != nil {
+		return nil, false, err
+	}
+	return result.Payments, created, nil
+}
@@ -0,0 +1,89 @@
+package payment
+
+import (
+	"errors"
+	"testing"
+)
+
+// readOnlyStore hides the WriteFile method of a MemoryStore
+type readOnlyStore struct {
+	Store
+}
+
+// TestPutPayments covers PutPayments functionality
+func TestPutPayments(t *testing.T) {
+	paymentsService, _ := serviceWithMemoryStore()
+	t.Run("upload valid file", func(t *testing.T) {
+		payments, created, err := paymentsService.PutPayments("20220717", "090000.payments", []byte(testRawCSV))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !created {
+			t.Fatal("file should be created")
+		}
+		if len(payments) != 1 {
+			t.Fatalf("invalid payments length, got %d, expected %d", len(payments), 1)
+		}
+		storedPayments, err := paymentsService.GetPayments("20220717/090000.payments")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := testValidatePayment(&storedPayments[0]); err != nil {
+			t.Fatal(err)
+		}
+		// Uploading the same file again replaces it:
+		_, created, err = paymentsService.PutPayments("20220717", "090000.payments", []byte(testRawCSV))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if created {
+			t.Fatal("file shouldn't be created twice")
+		}
+	})
+	t.Run("upload with invalid names", func(t *testing.T) {
+		for _, d := range testInvalidDirectories {
+			_, _, err := paymentsService.PutPayments(d, "090000.payments", []byte(testRawCSV))
+			if !errors.Is(err, ErrInvalidName) {
+				t.Fatalf("expected ErrInvalidName with dir name '%s', got %v", d, err)
+			}
+		}
+		for _, f := range testInvalidFiles {
+			_, _, err := paymentsService.PutPayments("20220717", f, []byte(testRawCSV))
+			if !errors.Is(err, ErrInvalidName) {
+				t.Fatalf("expected ErrInvalidName with file name '%s', got %v", f, err)
+			}
+		}
+	})
+	t.Run("upload invalid file", func(t *testing.T) {
+		rawCSV := `date,time,sequence,amount,comment
+20220717,090000,211,500,payment2
+20220717,090000,xyz,500,payment3
+20220717,090000,213,abc,payment4`
+		_, _, err := paymentsService.PutPayments("20220718", "090000.payments", []byte(rawCSV))
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Fatalf("expected *ParseError, got %v", err)
+		}
+		if len(parseErr.Rows) != 2 || parseErr.Rows[0].Line != 3 || parseErr.Rows[1].Line != 4 {
+			t.Fatalf("unexpected row errors %v", parseErr.Rows)
+		}
+		// Rejected files are never stored:
+		if _, err := paymentsService.GetPayments("20220718/090000.payments"); err == nil {
+			t.Fatal("rejected file shouldn't be stored")
+		}
+	})
+	t.Run("upload empty file", func(t *testing.T) {
+		_, _, err := paymentsService.PutPayments("20220718", "090000.payments", nil)
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Fatalf("expected *ParseError, got %v", err)
+		}
+	})
+	t.Run("upload to read-only store", func(t *testing.T) {
+		paymentsService := NewWithStore(readOnlyStore{NewMemoryStore()})
+		_, _, err := paymentsService.PutPayments("20220717", "090000.payments", []byte(testRawCSV))
+		if !errors.Is(err, ErrReadOnlyStore) {
+			t.Fatalf("expected ErrReadOnlyStore, got %v", err)
+		}
+	})
+}
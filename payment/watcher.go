@@ -0,0 +1,280 @@
+package payment
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"sync"
+	"time"
+)
+
+// EventType differentiates the kinds of events published by Watcher
+type EventType string
+
+const (
+	// EventDirectory is published when a new date directory is detected:
+	EventDirectory EventType = "directory"
+	// EventPayments is published when a new payments file is detected:
+	EventPayments EventType = "payments"
+)
+
+const (
+	// subscriberBuffer is the number of events kept for slow subscribers before dropping them:
+	subscriberBuffer = 64
+)
+
+// Event describes a new directory or payments file
+type Event struct {
+	Type EventType `json:"type"`
+	// Path is either YYYYMMDD or YYYYMMDD/HHMMSS.payments:
+	Path string `json:"path"`
+	// Payments and Rejected are only set for EventPayments:
+	Payments []Payment  `json:"payments,omitempty"`
+	Rejected []RowError `json:"rejected,omitempty"`
+	// Error is set when a new payments file couldn't be parsed:
+	Error string `json:"error,omitempty"`
+}
+
+// notifier wakes the watcher up when the store may contain new directories or files
+type notifier interface {
+	// Notifications returns a channel that receives a value every time the store should be scanned:
+	Notifications() <-chan struct{}
+	// Changes returns the date directories that changed since the last call,
+	// all is set when the whole store should be scanned:
+	Changes() (dirs []string, all bool)
+	// Watch is called for every date directory known to the watcher:
+	Watch(dir string) error
+	Close() error
+}
+
+// Watcher detects new date directories and payments files in the store of a PaymentsService
+// and publishes them to all its subscribers.
+// When the service is backed by the local filesystem inotify is used where available,
+// other stores are polled at a given interval.
+type Watcher struct {
+	service  *PaymentsService
+	interval time.Duration
+	// notifier is set by Start, unless it was replaced by tests:
+	notifier notifier
+
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+	knownDirs   map[string]bool
+	// knownFiles maps date directories to the names of their payments files:
+	knownFiles map[string]map[string]bool
+
+	startOnce sync.Once
+	closeOnce sync.Once
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewWatcher initializes a Watcher for a given service, pollInterval is used when inotify isn't available.
+// The watcher doesn't do anything until Start is called.
+func NewWatcher(p *PaymentsService, pollInterval time.Duration) *Watcher {
+	return &Watcher{
+		service:     p,
+		interval:    pollInterval,
+		subscribers: make(map[chan Event]struct{}),
+		knownDirs:   make(map[string]bool),
+		knownFiles:  make(map[string]map[string]bool),
+		done:        make(chan struct{}),
+	}
+}
+
+// Start scans the store and starts watching it, the directories and files that already exist aren't published.
+// Calling Start more than once is a no-op.
+func (w *Watcher) Start() {
+	w.startOnce.Do(func() {
+		if w.notifier == nil {
+			w.notifier = newNotifier(w.service, w.interval)
+		}
+		w.scan(false)
+		w.wg.Add(1)
+		go w.run()
+	})
+}
+
+// Subscribe returns a channel that receives all new events and a function that cancels the subscription.
+// Events are dropped for subscribers that don't keep up.
+func (w *Watcher) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+	w.mu.Lock()
+	w.subscribers[ch] = struct{}{}
+	w.mu.Unlock()
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			w.mu.Lock()
+			delete(w.subscribers, ch)
+			w.mu.Unlock()
+		})
+	}
+	return ch, cancel
+}
+
+// Close stops the watcher, it's safe to call it even if the watcher wasn't started
+func (w *Watcher) Close() error {
+	var err error
+	w.closeOnce.Do(func() {
+		close(w.done)
+		// Prevent a later Start from spawning goroutines:
+		w.startOnce.Do(func() {})
+		if w.notifier != nil {
+			err = w.notifier.Close()
+		}
+		w.wg.Wait()
+	})
+	return err
+}
+
+// run scans the directories reported by the notifier every time it signals a change
+func (w *Watcher) run() {
+	defer w.wg.Done()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-w.notifier.Notifications():
+			dirs, all := w.notifier.Changes()
+			if all {
+				w.scan(true)
+				continue
+			}
+			for _, dir := range dirs {
+				w.scanDir(dir, true)
+			}
+		}
+	}
+}
+
+// scan lists all directories and files, publishing the ones that weren't seen before when publish is set
+func (w *Watcher) scan(publish bool) {
+	partitions, err := w.service.Store.ListPartitions()
+	if err != nil {
+		log.Printf("watcher: %s\n", err.Error())
+		return
+	}
+	seen := make(map[string]bool, len(partitions))
+	for _, dir := range partitions {
+		seen[dir] = true
+		w.scanDir(dir, publish)
+	}
+	for dir := range w.knownDirs {
+		if !seen[dir] {
+			w.forgetDir(dir)
+		}
+	}
+}
+
+// scanDir lists the files of a single directory, publishing the directory and files that weren't seen before when publish is set.
+// Invalid names are skipped without warnings, scans happen too often to log them.
+func (w *Watcher) scanDir(dir string, publish bool) {
+	if err := w.service.validateDirName(dir); err != nil {
+		return
+	}
+	// Watch the directory before listing it, so files created in between aren't missed.
+	// Watches go away with their directories, Watch is called every time so recreated directories are watched again:
+	watchErr := w.notifier.Watch(dir)
+	names, err := w.service.Store.ListFiles(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		w.forgetDir(dir)
+		return
+	}
+	if watchErr != nil && !w.knownDirs[dir] {
+		log.Printf("watcher: %s\n", watchErr.Error())
+	}
+	if err != nil {
+		log.Printf("watcher: %s\n", err.Error())
+		return
+	}
+	if !w.knownDirs[dir] {
+		w.knownDirs[dir] = true
+		if publish {
+			w.publish(Event{Type: EventDirectory, Path: dir})
+		}
+	}
+	knownFiles := make(map[string]bool, len(names))
+	for _, name := range names {
+		if err := w.service.validateFileName(name); err != nil {
+			continue
+		}
+		knownFiles[name] = true
+		if w.knownFiles[dir][name] || !publish {
+			continue
+		}
+		path := dir + "/" + name
+		event := Event{Type: EventPayments, Path: path}
+		result, err := w.service.GetPaymentsReport(path)
+		if err != nil {
+			event.Error = err.Error()
+		} else {
+			event.Payments, event.Rejected = result.Payments, result.Rejected
+		}
+		w.publish(event)
+	}
+	// Files that were removed are published again if they come back:
+	w.knownFiles[dir] = knownFiles
+}
+
+// forgetDir is a helper that drops a removed directory, it's published again with its files if it comes back
+func (w *Watcher) forgetDir(dir string) {
+	delete(w.knownDirs, dir)
+	delete(w.knownFiles, dir)
+}
+
+// publish sends an event to all subscribers without blocking
+func (w *Watcher) publish(event Event) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for ch := range w.subscribers {
+		select {
+		case ch <- event:
+		default:
+			log.Printf("watcher: dropping %s event for slow subscriber\n", event.Path)
+		}
+	}
+}
+
+// pollNotifier is a notifier that fires at a fixed interval
+type pollNotifier struct {
+	ticker *time.Ticker
+	ch     chan struct{}
+	done   chan struct{}
+}
+
+// newPollNotifier initializes a pollNotifier with a given interval
+func newPollNotifier(interval time.Duration) *pollNotifier {
+	n := &pollNotifier{
+		ticker: time.NewTicker(interval),
+		ch:     make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go func() {
+		for {
+			select {
+			case <-n.done:
+				return
+			case <-n.ticker.C:
+				select {
+				case n.ch <- struct{}{}:
+				case <-n.done:
+					return
+				}
+			}
+		}
+	}()
+	return n
+}
+
+func (n *pollNotifier) Notifications() <-chan struct{} { return n.ch }
+func (n *pollNotifier) Watch(dir string) error         { return nil }
+
+// Changes satisfies notifier, polled stores are always scanned completely
+func (n *pollNotifier) Changes() ([]string, bool) { return nil, true }
+
+func (n *pollNotifier) Close() error {
+	n.ticker.Stop()
+	close(n.done)
+	return nil
+}
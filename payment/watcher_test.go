@@ -0,0 +1,204 @@
+package payment
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testWatcherTimeout bounds how long tests wait for watcher events
+const testWatcherTimeout = 5 * time.Second
+
+// expectEvent is a helper that waits for an event and checks its type and path
+func expectEvent(t *testing.T, events <-chan Event, eventType EventType, path string) Event {
+	t.Helper()
+	select {
+	case event := <-events:
+		if event.Type != eventType || event.Path != path {
+			t.Fatalf("unexpected event %+v, expected %s event for '%s'", event, eventType, path)
+		}
+		return event
+	case <-time.After(testWatcherTimeout):
+		t.Fatalf("timeout waiting for %s event for '%s'", eventType, path)
+	}
+	return Event{}
+}
+
+// nextEvent is a helper that waits for the next event, whatever its type
+func nextEvent(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(testWatcherTimeout):
+		t.Fatal("timeout waiting for an event")
+	}
+	return Event{}
+}
+
+// TestWatcherPolling covers Watcher with a store that's polled
+func TestWatcherPolling(t *testing.T) {
+	paymentsService, store := serviceWithMemoryStore()
+	// Existing files aren't published:
+	store.AddFile("20220717", "090000.payments", []byte(testRawCSV))
+	watcher := NewWatcher(paymentsService, 10*time.Millisecond)
+	watcher.Start()
+	defer watcher.Close()
+	events, cancel := watcher.Subscribe()
+	defer cancel()
+
+	store.AddFile("20220717", "100000.payments", []byte(testRawCSV))
+	event := expectEvent(t, events, EventPayments, "20220717/100000.payments")
+	if len(event.Payments) != 1 {
+		t.Fatalf("invalid payments length, got %d, expected %d", len(event.Payments), 1)
+	}
+	if err := testValidatePayment(&event.Payments[0]); err != nil {
+		t.Fatal(err)
+	}
+	// Invalid names are ignored:
+	store.AddFile("xyz", "100000.payments", []byte(testRawCSV))
+	store.AddFile("20220718", "invalid.payments", []byte(testRawCSV))
+	expectEvent(t, events, EventDirectory, "20220718")
+	store.AddFile("20220718", "010101.payments", []byte("invalid"))
+	event = expectEvent(t, events, EventPayments, "20220718/010101.payments")
+	if event.Error == "" {
+		t.Fatal("event should contain the parsing error")
+	}
+}
+
+// TestWatcherFilesystem covers Watcher with a filesystem store, using inotify on Linux
+func TestWatcherFilesystem(t *testing.T) {
+	baseDir := t.TempDir()
+	paymentsService, err := NewWithBaseDir(baseDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A long polling interval ensures events come from inotify where it's available:
+	interval := time.Hour
+	n := newNotifier(paymentsService, interval)
+	if _, ok := n.(*pollNotifier); ok {
+		interval = 10 * time.Millisecond
+	}
+	n.Close()
+	watcher := NewWatcher(paymentsService, interval)
+	watcher.Start()
+	defer watcher.Close()
+	events, cancel := watcher.Subscribe()
+	defer cancel()
+
+	store := paymentsService.Store.(*FSStore)
+	if err := os.Mkdir(filepath.Join(baseDir, "20220717"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, events, EventDirectory, "20220717")
+	if err := store.WriteFile("20220717", "090000.payments", []byte(testRawCSV)); err != nil {
+		t.Fatal(err)
+	}
+	event := expectEvent(t, events, EventPayments, "20220717/090000.payments")
+	if len(event.Payments) != 1 {
+		t.Fatalf("invalid payments length, got %d, expected %d", len(event.Payments), 1)
+	}
+	// Directories that are removed and created again are still watched,
+	// the directory event depends on whether the removal was noticed first:
+	if err := os.RemoveAll(filepath.Join(baseDir, "20220717")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(baseDir, "20220717"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.WriteFile("20220717", "100000.payments", []byte(testRawCSV)); err != nil {
+		t.Fatal(err)
+	}
+	for event := nextEvent(t, events); event.Path != "20220717/100000.payments"; event = nextEvent(t, events) {
+		if event.Type != EventDirectory || event.Path != "20220717" {
+			t.Fatalf("unexpected event %+v", event)
+		}
+	}
+	if err := watcher.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testNotifier is a notifier that reports the directories passed to notify
+type testNotifier struct {
+	ch   chan struct{}
+	mu   sync.Mutex
+	dirs []string
+}
+
+func (n *testNotifier) notify(dirs ...string) {
+	n.mu.Lock()
+	n.dirs = append(n.dirs, dirs...)
+	n.mu.Unlock()
+	n.ch <- struct{}{}
+}
+
+func (n *testNotifier) Notifications() <-chan struct{} { return n.ch }
+func (n *testNotifier) Watch(dir string) error         { return nil }
+func (n *testNotifier) Close() error                   { return nil }
+
+func (n *testNotifier) Changes() ([]string, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	dirs := n.dirs
+	n.dirs = nil
+	return dirs, false
+}
+
+// countingStore is a MemoryStore that counts how many times every directory is listed
+type countingStore struct {
+	*MemoryStore
+	mu         sync.Mutex
+	partitions int
+	files      map[string]int
+}
+
+func (s *countingStore) ListPartitions() ([]string, error) {
+	s.mu.Lock()
+	s.partitions++
+	s.mu.Unlock()
+	return s.MemoryStore.ListPartitions()
+}
+
+func (s *countingStore) ListFiles(partition string) ([]string, error) {
+	s.mu.Lock()
+	s.files[partition]++
+	s.mu.Unlock()
+	return s.MemoryStore.ListFiles(partition)
+}
+
+// TestWatcherChanges checks that only the directories reported by the notifier are listed again
+func TestWatcherChanges(t *testing.T) {
+	store := &countingStore{MemoryStore: NewMemoryStore(), files: make(map[string]int)}
+	store.AddFile("20220717", "090000.payments", []byte(testRawCSV))
+	store.AddFile("20220718", "090000.payments", []byte(testRawCSV))
+	watcher := NewWatcher(NewWithStore(store), time.Hour)
+	n := &testNotifier{ch: make(chan struct{})}
+	watcher.notifier = n
+	watcher.Start()
+	defer watcher.Close()
+	events, cancel := watcher.Subscribe()
+	defer cancel()
+
+	store.AddFile("20220718", "100000.payments", []byte(testRawCSV))
+	n.notify("20220718")
+	expectEvent(t, events, EventPayments, "20220718/100000.payments")
+	// New directories are published without listing the others:
+	store.AddFile("20220719", "090000.payments", []byte(testRawCSV))
+	n.notify("20220719")
+	expectEvent(t, events, EventDirectory, "20220719")
+	expectEvent(t, events, EventPayments, "20220719/090000.payments")
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.partitions != 1 {
+		t.Fatalf("invalid directory listings, got %d, expected %d", store.partitions, 1)
+	}
+	for dir, expected := range map[string]int{"20220717": 1, "20220718": 2, "20220719": 1} {
+		if store.files[dir] != expected {
+			t.Fatalf("invalid listings of '%s', got %d, expected %d", dir, store.files[dir], expected)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Package server runs an HTTP handler with the timeouts of the config package and stops it gracefully.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/matiasinsaurralde/product-services/config"
+)
+
+// Server wraps an http.Server with a graceful shutdown
+type Server struct {
+	httpServer      *http.Server
+	tls             config.TLS
+	shutdownTimeout time.Duration
+	// reloadInterval limits how often the TLS certificate files are checked for changes:
+	reloadInterval time.Duration
+
+	mu      sync.Mutex
+	closers []io.Closer
+}
+
+// New initializes a Server for a given handler, timeouts and TLS settings are read from cfg
+func New(cfg *config.Config, handler http.Handler) *Server {
+	return &Server{
+		httpServer: &http.Server{
+			Addr:              cfg.ListenAddr,
+			Handler:           handler,
+			ReadHeaderTimeout: time.Duration(cfg.Timeouts.ReadHeader),
+			ReadTimeout:       time.Duration(cfg.Timeouts.Read),
+			WriteTimeout:      time.Duration(cfg.Timeouts.Write),
+			IdleTimeout:       time.Duration(cfg.Timeouts.Idle),
+		},
+		tls:             cfg.TLS,
+		shutdownTimeout: time.Duration(cfg.Timeouts.Shutdown),
+		reloadInterval:  defaultReloadInterval,
+	}
+}
+
+// RegisterOnShutdown adds a closer that is called as soon as the shutdown starts, before waiting for active requests.
+// It's meant for background work and long-lived requests that would otherwise delay the shutdown, like event streams.
+func (s *Server) RegisterOnShutdown(c io.Closer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closers = append(s.closers, c)
+}
+
+// ListenAndServe listens on the configured address and calls Serve
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	ln, err := net.Listen("tcp", s.httpServer.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, ln)
+}
+
+// Serve accepts connections on ln until ctx is done, then stops accepting connections and waits for active requests
+// up to the shutdown timeout. Connections that are still active after the timeout are closed and an error is returned.
+// Serve returns nil after a graceful shutdown.
+// When TLS is configured the certificate files are reloaded on change, see certReloader.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	if s.tls.Enabled() {
+		tlsConfig, err := newTLSConfig(s.tls, s.reloadInterval)
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		s.httpServer.TLSConfig = tlsConfig
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		if s.tls.Enabled() {
+			// The certificate comes from TLSConfig.GetCertificate:
+			serveErr <- s.httpServer.ServeTLS(ln, "", "")
+			return
+		}
+		serveErr <- s.httpServer.Serve(ln)
+	}()
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Println("Shutting down, waiting for active requests")
+	shutdownCtx := context.Background()
+	if s.shutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		shutdownCtx, cancel = context.WithTimeout(shutdownCtx, s.shutdownTimeout)
+		defer cancel()
+	}
+	closeErr := s.close()
+	if err := s.httpServer.Shutdown(shutdownCtx); err != nil {
+		s.httpServer.Close()
+		return fmt.Errorf("couldn't finish active requests: %w", err)
+	}
+	if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return closeErr
+}
+
+// close is a helper that calls every registered closer and returns the first error
+func (s *Server) close() error {
+	s.mu.Lock()
+	closers := s.closers
+	s.mu.Unlock()
+	var firstErr error
+	for _, c := range closers {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
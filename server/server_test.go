@@ -0,0 +1,271 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matiasinsaurralde/product-services/api"
+	"github.com/matiasinsaurralde/product-services/config"
+	"github.com/matiasinsaurralde/product-services/payment"
+)
+
+const testRawCSV = `date,time,sequence,amount,comment
+20220717,090000,211,500,payment2
+20220717,090000,212,600,payment3`
+
+// blockingStore serves payments files that block in the middle of the first read until release is closed
+type blockingStore struct {
+	payment.Store
+	reading chan struct{}
+	release chan struct{}
+}
+
+func newBlockingStore() *blockingStore {
+	store := payment.NewMemoryStore()
+	store.AddFile("20220717", "090000.payments", []byte(testRawCSV))
+	return &blockingStore{Store: store, reading: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (s *blockingStore) Open(partition, name string) (io.ReadCloser, error) {
+	f, err := s.Store.Open(partition, name)
+	if err != nil {
+		return nil, err
+	}
+	return &blockingReader{ReadCloser: f, store: s}, nil
+}
+
+// blockingReader signals the store when it's first read and waits for the release
+type blockingReader struct {
+	io.ReadCloser
+	store   *blockingStore
+	started bool
+}
+
+func (r *blockingReader) Read(p []byte) (int, error) {
+	if !r.started {
+		r.started = true
+		close(r.store.reading)
+		<-r.store.release
+	}
+	return r.ReadCloser.Read(p)
+}
+
+// startTestServer is a helper that serves handler on a random port until the returned cancel function is called,
+// Serve's result is sent to the returned channel
+func startTestServer(t *testing.T, handler *api.Handler, shutdownTimeout time.Duration) (string, context.CancelFunc, <-chan error) {
+	cfg := config.Default()
+	cfg.Timeouts.Shutdown = config.Duration(shutdownTimeout)
+	return startTestServerWithConfig(t, handler, cfg)
+}
+
+// startTestServerWithConfig is like startTestServer with the timeouts of cfg
+func startTestServerWithConfig(t *testing.T, handler *api.Handler, cfg *config.Config) (string, context.CancelFunc, <-chan error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := New(cfg, handler)
+	srv.RegisterOnShutdown(handler)
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() {
+		result <- srv.Serve(ctx, ln)
+	}()
+	return "http://" + ln.Addr().String(), cancel, result
+}
+
+// waitForListenerClose is a helper that waits until addr stops accepting connections
+func waitForListenerClose(t *testing.T, baseURL string) {
+	addr := strings.TrimPrefix(baseURL, "http://")
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return
+		}
+		conn.Close()
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("server still accepts connections")
+}
+
+// TestGracefulShutdown covers requests that are active when the server stops
+func TestGracefulShutdown(t *testing.T) {
+	t.Run("in-flight download completes", func(t *testing.T) {
+		store := newBlockingStore()
+		handler := api.NewHandlerWithService(payment.NewWithStore(store))
+		baseURL, cancel, result := startTestServer(t, handler, 5*time.Second)
+		defer cancel()
+
+		type response struct {
+			res  *http.Response
+			body []byte
+			err  error
+		}
+		responses := make(chan response, 1)
+		go func() {
+			res, err := http.Get(baseURL + "/20220717/090000.payments")
+			if err != nil {
+				responses <- response{err: err}
+				return
+			}
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			responses <- response{res: res, body: body, err: err}
+		}()
+
+		<-store.reading
+		cancel()
+		waitForListenerClose(t, baseURL)
+		select {
+		case err := <-result:
+			t.Fatalf("server stopped before the download finished: %v", err)
+		default:
+		}
+		close(store.release)
+
+		r := <-responses
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		if r.res.StatusCode != 200 {
+			t.Fatalf("invalid status code, got %d, expected %d", r.res.StatusCode, 200)
+		}
+		var payments []payment.Payment
+		if err := json.Unmarshal(r.body, &payments); err != nil {
+			t.Fatalf("incomplete download: %s", err)
+		}
+		if len(payments) != 2 {
+			t.Fatalf("invalid payments length, got %d, expected %d", len(payments), 2)
+		}
+		if err := <-result; err != nil {
+			t.Fatalf("expected graceful shutdown, got %v", err)
+		}
+	})
+
+	t.Run("event streams end", func(t *testing.T) {
+		handler := api.NewHandlerWithService(payment.NewWithStore(payment.NewMemoryStore()))
+		baseURL, cancel, result := startTestServer(t, handler, 5*time.Second)
+		defer cancel()
+
+		res, err := http.Get(baseURL + "/events")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		reader := bufio.NewReader(res.Body)
+		if _, err := reader.ReadString('\n'); err != nil {
+			t.Fatal(err)
+		}
+		cancel()
+		if _, err := ioutil.ReadAll(reader); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case err := <-result:
+			if err != nil {
+				t.Fatalf("expected graceful shutdown, got %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("server didn't stop")
+		}
+	})
+
+	t.Run("shutdown timeout", func(t *testing.T) {
+		store := newBlockingStore()
+		defer close(store.release)
+		handler := api.NewHandlerWithService(payment.NewWithStore(store))
+		baseURL, cancel, result := startTestServer(t, handler, 50*time.Millisecond)
+		defer cancel()
+
+		go func() {
+			res, err := http.Get(baseURL + "/20220717/090000.payments")
+			if err == nil {
+				io.Copy(ioutil.Discard, res.Body)
+				res.Body.Close()
+			}
+		}()
+		<-store.reading
+		cancel()
+		if err := <-result; err == nil {
+			t.Fatal("expected error when active requests outlive the shutdown timeout")
+		}
+	})
+}
+
+// TestWriteTimeout covers streaming responses that outlive the write timeout
+func TestWriteTimeout(t *testing.T) {
+	const writeTimeout = 200 * time.Millisecond
+	cfg := config.Default()
+	cfg.Timeouts.Write = config.Duration(writeTimeout)
+
+	t.Run("event stream", func(t *testing.T) {
+		store := payment.NewMemoryStore()
+		handler := api.NewHandlerWithService(payment.NewWithStore(store))
+		baseURL, cancel, _ := startTestServerWithConfig(t, handler, cfg)
+		defer cancel()
+
+		res, err := http.Get(baseURL + "/events")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		lines := make(chan string)
+		go func() {
+			defer close(lines)
+			scanner := bufio.NewScanner(res.Body)
+			for scanner.Scan() {
+				lines <- scanner.Text()
+			}
+		}()
+		time.Sleep(2 * writeTimeout)
+		store.AddFile("20220717", "090000.payments", []byte(testRawCSV))
+		timeout := time.After(10 * time.Second)
+		for {
+			select {
+			case line, ok := <-lines:
+				if !ok {
+					t.Fatal("event stream closed by the write timeout")
+				}
+				if strings.HasPrefix(line, "event: ") {
+					return
+				}
+			case <-timeout:
+				t.Fatal("timeout waiting for events")
+			}
+		}
+	})
+
+	t.Run("streamed download", func(t *testing.T) {
+		store := newBlockingStore()
+		handler := api.NewHandlerWithService(payment.NewWithStore(store))
+		baseURL, cancel, _ := startTestServerWithConfig(t, handler, cfg)
+		defer cancel()
+
+		go func() {
+			<-store.reading
+			time.Sleep(2 * writeTimeout)
+			close(store.release)
+		}()
+		res, err := http.Get(baseURL + "/20220717/090000.payments")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var payments []payment.Payment
+		if err := json.NewDecoder(res.Body).Decode(&payments); err != nil {
+			t.Fatalf("incomplete download: %s", err)
+		}
+		if len(payments) != 2 {
+			t.Fatalf("invalid payments length, got %d, expected %d", len(payments), 2)
+		}
+	})
+}
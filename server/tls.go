@@ -0,0 +1,125 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/matiasinsaurralde/product-services/config"
+)
+
+const (
+	// defaultReloadInterval limits how often the certificate files are checked for changes:
+	defaultReloadInterval = 10 * time.Second
+)
+
+// certReloader serves a certificate and key pair, reloading them when the files change.
+// Files are checked during handshakes, at most once per interval, so no background goroutine is needed.
+type certReloader struct {
+	certFile, keyFile string
+	interval          time.Duration
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	certStamp fileStamp
+	keyStamp  fileStamp
+	checked   time.Time
+}
+
+// fileStamp is used to detect file changes
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// statFile is a helper that returns the stamp of a file
+func statFile(path string) (fileStamp, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{modTime: fi.ModTime(), size: fi.Size()}, nil
+}
+
+// newCertReloader loads a certificate and key pair, failing if they can't be loaded
+func newCertReloader(certFile, keyFile string, interval time.Duration) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, interval: interval}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload is a helper that loads the certificate files, the caller must hold r.mu unless r isn't shared yet
+func (r *certReloader) reload() error {
+	certStamp, err := statFile(r.certFile)
+	if err != nil {
+		return err
+	}
+	keyStamp, err := statFile(r.keyFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("couldn't load TLS certificate: %w", err)
+	}
+	r.cert, r.certStamp, r.keyStamp = &cert, certStamp, keyStamp
+	return nil
+}
+
+// GetCertificate satisfies tls.Config.GetCertificate.
+// When the files changed and can't be loaded, e.g. because only one of them was replaced so far,
+// the error is logged and the previous certificate is kept.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if now := time.Now(); now.Sub(r.checked) >= r.interval {
+		r.checked = now
+		certStamp, certErr := statFile(r.certFile)
+		keyStamp, keyErr := statFile(r.keyFile)
+		changed := certErr != nil || keyErr != nil || certStamp != r.certStamp || keyStamp != r.keyStamp
+		if changed {
+			if err := r.reload(); err != nil {
+				log.Printf("error: %s\n", err.Error())
+			} else {
+				log.Printf("Reloaded TLS certificate '%s'\n", r.certFile)
+			}
+		}
+	}
+	return r.cert, nil
+}
+
+// newTLSConfig builds the TLS settings of the server, client certificates are verified when a CA bundle is set
+func newTLSConfig(cfg config.TLS, reloadInterval time.Duration) (*tls.Config, error) {
+	reloader, err := newCertReloader(cfg.CertFile, cfg.KeyFile, reloadInterval)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if cfg.ClientCAFile != "" {
+		bundle, err := ioutil.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read client CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, errors.New("client CA bundle doesn't contain any PEM certificate")
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		if cfg.RequireClientCert {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/matiasinsaurralde/product-services/api"
+	"github.com/matiasinsaurralde/product-services/config"
+)
+
+// testCA signs the certificates used by the TLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+// newTestCA is a helper that generates a self-signed CA
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue is a helper that signs a server or client certificate and returns the PEM encoded certificate and key
+func (ca *testCA) issue(t *testing.T, serial int64, commonName string, usage x509.ExtKeyUsage) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"Payments"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM
+}
+
+// writeFiles is a helper that writes a certificate and key pair
+func writeFiles(t *testing.T, certFile string, certPEM []byte, keyFile string, keyPEM []byte) {
+	if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// identityHandler writes the name of the request identity
+var identityHandler = api.ClientCertificateIdentity(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	if identity, ok := api.IdentityFromContext(r.Context()); ok {
+		fmt.Fprintf(w, "%s %s", identity.Method, identity.Name)
+		return
+	}
+	fmt.Fprint(w, "anonymous")
+}))
+
+// startTLSServer is a helper that serves identityHandler with the given TLS settings
+func startTLSServer(t *testing.T, tlsConfig config.TLS) (string, <-chan error) {
+	cfg := config.Default()
+	cfg.TLS = tlsConfig
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := New(cfg, identityHandler)
+	srv.reloadInterval = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	result := make(chan error, 1)
+	go func() {
+		result <- srv.Serve(ctx, ln)
+	}()
+	return "https://" + ln.Addr().String(), result
+}
+
+// testClient is a helper that builds a client trusting ca, with an optional client certificate
+func testClient(t *testing.T, ca *testCA, certPEM, keyPEM []byte) *http.Client {
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca.pem)
+	tlsConfig := &tls.Config{RootCAs: pool}
+	if certPEM != nil {
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	// New connections are used for every request so certificate changes are visible:
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig, DisableKeepAlives: true}}
+}
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key")
+	serverCA := newTestCA(t, "server CA")
+	clientCA := newTestCA(t, "client CA")
+	clientCAFile := filepath.Join(dir, "clients.pem")
+	if err := ioutil.WriteFile(clientCAFile, clientCA.pem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	certPEM, keyPEM := serverCA.issue(t, 100, "payments", x509.ExtKeyUsageServerAuth)
+	writeFiles(t, certFile, certPEM, keyFile, keyPEM)
+
+	t.Run("certificate reload", func(t *testing.T) {
+		baseURL, _ := startTLSServer(t, config.TLS{CertFile: certFile, KeyFile: keyFile})
+		client := testClient(t, serverCA, nil, nil)
+		serial := func() int64 {
+			res, err := client.Get(baseURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			return res.TLS.PeerCertificates[0].SerialNumber.Int64()
+		}
+		if s := serial(); s != 100 {
+			t.Fatalf("invalid serial number, got %d, expected %d", s, 100)
+		}
+		renewedCert, renewedKey := serverCA.issue(t, 101, "payments", x509.ExtKeyUsageServerAuth)
+		writeFiles(t, certFile, renewedCert, keyFile, renewedKey)
+		if s := serial(); s != 101 {
+			t.Fatalf("invalid serial number after reload, got %d, expected %d", s, 101)
+		}
+		// Invalid files keep the previous certificate:
+		if err := ioutil.WriteFile(certFile, []byte("invalid"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if s := serial(); s != 101 {
+			t.Fatalf("invalid serial number after failed reload, got %d, expected %d", s, 101)
+		}
+		writeFiles(t, certFile, certPEM, keyFile, keyPEM)
+	})
+
+	t.Run("optional client certificate", func(t *testing.T) {
+		baseURL, _ := startTLSServer(t, config.TLS{CertFile: certFile, KeyFile: keyFile, ClientCAFile: clientCAFile})
+		clientCert, clientKey := clientCA.issue(t, 200, "batch-job", x509.ExtKeyUsageClientAuth)
+		cases := []struct {
+			name     string
+			client   *http.Client
+			expected string
+		}{
+			{"without certificate", testClient(t, serverCA, nil, nil), "anonymous"},
+			{"with certificate", testClient(t, serverCA, clientCert, clientKey), "client-certificate batch-job"},
+		}
+		for _, c := range cases {
+			res, err := c.client.Get(baseURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if string(body) != c.expected {
+				t.Fatalf("invalid identity %s, got '%s', expected '%s'", c.name, body, c.expected)
+			}
+		}
+	})
+
+	t.Run("required client certificate", func(t *testing.T) {
+		baseURL, _ := startTLSServer(t, config.TLS{CertFile: certFile, KeyFile: keyFile, ClientCAFile: clientCAFile, RequireClientCert: true})
+		if _, err := testClient(t, serverCA, nil, nil).Get(baseURL); err == nil {
+			t.Fatal("expected handshake error without client certificate")
+		}
+		// Certificates signed by another CA are rejected:
+		otherCert, otherKey := serverCA.issue(t, 300, "intruder", x509.ExtKeyUsageClientAuth)
+		if _, err := testClient(t, serverCA, otherCert, otherKey).Get(baseURL); err == nil {
+			t.Fatal("expected handshake error with an untrusted client certificate")
+		}
+		clientCert, clientKey := clientCA.issue(t, 201, "batch-job", x509.ExtKeyUsageClientAuth)
+		res, err := testClient(t, serverCA, clientCert, clientKey).Get(baseURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	})
+
+	t.Run("invalid certificate", func(t *testing.T) {
+		invalidFile := filepath.Join(dir, "invalid.pem")
+		if err := ioutil.WriteFile(invalidFile, []byte("invalid"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		_, result := startTLSServer(t, config.TLS{CertFile: invalidFile, KeyFile: keyFile})
+		if err := <-result; err == nil {
+			t.Fatal("expected error for invalid certificate")
+		}
+	})
+}